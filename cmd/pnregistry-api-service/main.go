@@ -4,16 +4,20 @@ import (
 	"context"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/bmathus/pnregistry-webapi/api"
+	"github.com/bmathus/pnregistry-webapi/internal/auth"
 	"github.com/bmathus/pnregistry-webapi/internal/db_service"
 	"github.com/bmathus/pnregistry-webapi/internal/pn_registry"
+	"github.com/bmathus/pnregistry-webapi/internal/tenant"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
 	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
 )
 
 func main() {
@@ -33,30 +37,317 @@ func main() {
 	corsMiddleware := cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "PUT", "POST", "DELETE", "PATCH"},
-		AllowHeaders:     []string{"Origin", "Authorization", "Content-Type"},
-		ExposeHeaders:    []string{""},
+		AllowHeaders:     []string{"Origin", "Authorization", "Content-Type", "If-Match", "If-None-Match", "X-Request-ID", "X-User", "X-Tenant-ID", "X-API-Key"},
+		ExposeHeaders:    []string{"X-Total-Count", "ETag", "X-Request-ID"},
 		AllowCredentials: false,
 		MaxAge:           12 * time.Hour,
 	})
 	engine.Use(corsMiddleware)
 
-	// setup context update middleware
-	dbService := db_service.NewMongoService[pn_registry.Record](db_service.MongoServiceConfig{})
-	defer dbService.Disconnect(context.Background())
+	// setup request id middleware - id is propagated to audit trail
 	engine.Use(func(ctx *gin.Context) {
-		ctx.Set("db_service", dbService)
+		requestId := ctx.GetHeader("X-Request-ID")
+		if requestId == "" {
+			requestId = uuid.New().String()
+		}
+		ctx.Set("request_id", requestId)
+		ctx.Header("X-Request-ID", requestId)
 		ctx.Next()
 	})
 
-	// register custom validators for patientId,fullname,employer and reason fields
+	// setup context update middleware
+	auditCollection := os.Getenv("PN_REGISTRY_API_MONGODB_AUDIT_COLLECTION")
+	if auditCollection == "" {
+		auditCollection = "record_audit"
+	}
+	patientCollection := os.Getenv("PN_REGISTRY_API_MONGODB_PATIENT_COLLECTION")
+	if patientCollection == "" {
+		patientCollection = "patient"
+	}
+	employerCollection := os.Getenv("PN_REGISTRY_API_MONGODB_EMPLOYER_COLLECTION")
+	if employerCollection == "" {
+		employerCollection = "employer"
+	}
+	// setup encryption of personal data in records and patients, keys are loaded from key file or environment
+	var encryption pn_registry.RecordEncryption
+	encryptionKeyFile := os.Getenv("PN_REGISTRY_API_ENCRYPTION_KEYFILE")
+	encryptionKeyList := os.Getenv("PN_REGISTRY_API_ENCRYPTION_KEYS")
+	if encryptionKeyFile != "" || encryptionKeyList != "" {
+		encryptionKeys := db_service.EncryptionKeys{
+			KeyId:    os.Getenv("PN_REGISTRY_API_ENCRYPTION_KEY_ID"),
+			Keys:     map[string]string{},
+			IndexKey: os.Getenv("PN_REGISTRY_API_ENCRYPTION_INDEX_KEY"),
+		}
+		if encryptionKeyFile != "" {
+			var err error
+			if encryptionKeys, err = db_service.LoadEncryptionKeyFile(encryptionKeyFile); err != nil {
+				log.Fatalf("Failed to load encryption keys: %v", err)
+			}
+		} else {
+			// comma separated list of <key id>:<base64 encoded key>
+			for _, entry := range strings.FieldsFunc(encryptionKeyList, func(r rune) bool { return r == ',' || r == ' ' }) {
+				keyId, key, _ := strings.Cut(entry, ":")
+				encryptionKeys.Keys[keyId] = key
+			}
+		}
+
+		var err error
+		if encryption, err = pn_registry.NewRecordEncryption(encryptionKeys); err != nil {
+			log.Fatalf("Failed to setup encryption of records: %v", err)
+		}
+		log.Printf("Personal data in records and patients are encrypted")
+	}
+
+	// every tenant (clinic) has separate database, single unnamed tenant is used when multi-tenancy is disabled
+	tenants := []string{""}
+	tenantClaim := os.Getenv("PN_REGISTRY_API_TENANT_CLAIM")
+	if tenantClaim == "" {
+		tenantClaim = "tenant"
+	}
+	if tenantList := os.Getenv("PN_REGISTRY_API_TENANTS"); tenantList != "" {
+		tenants = strings.FieldsFunc(tenantList, func(r rune) bool { return r == ',' || r == ' ' })
+		if err := tenant.ValidateTenants(tenants); err != nil {
+			log.Fatalf("Failed to setup tenants: %v", err)
+		}
+	}
+	dbServices := db_service.NewTenantServices(tenants, func(tenantId string) db_service.DbService[pn_registry.Record] {
+		return newDbService[pn_registry.Record]("", tenantId, encryption.Records)
+	})
+	auditServices := db_service.NewTenantServices(tenants, func(tenantId string) db_service.DbService[pn_registry.AuditEntry] {
+		return newDbService[pn_registry.AuditEntry](auditCollection, tenantId, encryption.Audit)
+	})
+	patientServices := db_service.NewTenantServices(tenants, func(tenantId string) db_service.DbService[pn_registry.Patient] {
+		return newDbService[pn_registry.Patient](patientCollection, tenantId, encryption.Patients)
+	})
+	employerServices := db_service.NewTenantServices(tenants, func(tenantId string) db_service.DbService[pn_registry.Employer] {
+		return newDbService[pn_registry.Employer](employerCollection, tenantId, nil)
+	})
+	defer dbServices.Disconnect(context.Background())
+	defer auditServices.Disconnect(context.Background())
+	defer patientServices.Disconnect(context.Background())
+	defer employerServices.Disconnect(context.Background())
+
+	// records of the same patient or employer are serialized by locks shared by all replicas through database
+	lockCollection := os.Getenv("PN_REGISTRY_API_MONGODB_LOCK_COLLECTION")
+	if lockCollection == "" {
+		lockCollection = "record_lock"
+	}
+	lockService := newDbService[pn_registry.LockLease](lockCollection, "", nil)
+	defer lockService.Disconnect(context.Background())
+	pn_registry.EnableDatabaseLocks(lockService, pn_registry.LockConfig{})
+
+	// unique indexes are created at startup, documents could not be written consistently without them
+	for _, err := range []error{
+		dbServices.Connect(context.Background()),
+		auditServices.Connect(context.Background()),
+		patientServices.Connect(context.Background()),
+		employerServices.Connect(context.Background()),
+		db_service.Connect(context.Background(), lockService),
+	} {
+		if err != nil {
+			log.Fatalf("Failed to connect to database: %v", err)
+		}
+	}
+	servicesMiddleware := func(ctx *gin.Context) {
+		tenantId := tenant.FromContext(ctx)
+		dbService, _ := dbServices.For(tenantId)
+		auditService, _ := auditServices.For(tenantId)
+		patientService, _ := patientServices.For(tenantId)
+		employerService, _ := employerServices.For(tenantId)
+		ctx.Set("db_service", dbService)
+		ctx.Set("audit_service", auditService)
+		ctx.Set("patient_service", patientService)
+		ctx.Set("employer_service", employerService)
+		ctx.Set("record_encryption", encryption.Records)
+		ctx.Next()
+	}
+
+	// setup authentication middleware, requests are authenticated only when HMAC secret or JWKS is configured
+	jwtSecret := os.Getenv("PN_REGISTRY_API_AUTH_JWT_SECRET")
+	jwksSource := os.Getenv("PN_REGISTRY_API_AUTH_JWKS")
+	authenticated := jwtSecret != "" || jwksSource != ""
+	if authenticated {
+		authConfig := auth.Config{
+			HmacSecret:   []byte(jwtSecret),
+			JwksSource:   jwksSource,
+			JwksRefresh:  time.Hour,
+			Issuer:       os.Getenv("PN_REGISTRY_API_AUTH_ISSUER"),
+			Audience:     os.Getenv("PN_REGISTRY_API_AUTH_AUDIENCE"),
+			Leeway:       time.Minute,
+			PublicRoutes: []string{"/openapi"},
+			RolesClaim:   "roles",
+		}
+		if rolesClaim := os.Getenv("PN_REGISTRY_API_AUTH_ROLES_CLAIM"); rolesClaim != "" {
+			authConfig.RolesClaim = rolesClaim
+		}
+		if minutes, err := strconv.Atoi(os.Getenv("PN_REGISTRY_API_AUTH_JWKS_REFRESH_MINUTES")); err == nil && minutes > 0 {
+			authConfig.JwksRefresh = time.Duration(minutes) * time.Minute
+		}
+		if seconds, err := strconv.Atoi(os.Getenv("PN_REGISTRY_API_AUTH_LEEWAY_SECONDS")); err == nil && seconds >= 0 {
+			authConfig.Leeway = time.Duration(seconds) * time.Second
+		}
+		if routes, exists := os.LookupEnv("PN_REGISTRY_API_AUTH_PUBLIC_ROUTES"); exists {
+			authConfig.PublicRoutes = strings.FieldsFunc(routes, func(r rune) bool { return r == ',' || r == ' ' })
+		}
+
+		authCtx, authCancel := context.WithCancel(context.Background())
+		defer authCancel()
+
+		// API keys of machine clients are shared by all tenants, every key is bound to tenant of its issuer
+		if strings.EqualFold(os.Getenv("PN_REGISTRY_API_API_KEYS_ENABLED"), "true") {
+			apiKeyCollection := os.Getenv("PN_REGISTRY_API_MONGODB_API_KEY_COLLECTION")
+			if apiKeyCollection == "" {
+				apiKeyCollection = "api_key"
+			}
+			apiKeyUsageCollection := os.Getenv("PN_REGISTRY_API_MONGODB_API_KEY_USAGE_COLLECTION")
+			if apiKeyUsageCollection == "" {
+				apiKeyUsageCollection = "api_key_usage"
+			}
+			apiKeyService := newDbService[pn_registry.ApiKey](apiKeyCollection, "", nil)
+			apiKeyUsageService := newDbService[pn_registry.ApiKeyUsage](apiKeyUsageCollection, "", nil)
+			defer apiKeyService.Disconnect(context.Background())
+			defer apiKeyUsageService.Disconnect(context.Background())
+			for _, err := range []error{
+				db_service.Connect(context.Background(), apiKeyService),
+				db_service.Connect(context.Background(), apiKeyUsageService),
+			} {
+				if err != nil {
+					log.Fatalf("Failed to connect to database: %v", err)
+				}
+			}
+
+			apiKeyConfig := pn_registry.ApiKeyConfig{
+				TenantClaim:   tenantClaim,
+				AdminRole:     "admin",
+				FlushInterval: time.Minute,
+			}
+			if role := os.Getenv("PN_REGISTRY_API_API_KEYS_ADMIN_ROLE"); role != "" {
+				apiKeyConfig.AdminRole = role
+			}
+			if seconds, err := strconv.Atoi(os.Getenv("PN_REGISTRY_API_API_KEYS_USAGE_FLUSH_SECONDS")); err == nil && seconds > 0 {
+				apiKeyConfig.FlushInterval = time.Duration(seconds) * time.Second
+			}
+			authConfig.ApiKeys = pn_registry.EnableApiKeys(authCtx, apiKeyService, apiKeyUsageService, apiKeyConfig)
+		}
+
+		authenticator, err := auth.NewAuthenticator(authCtx, authConfig)
+		if err != nil {
+			log.Fatalf("Failed to setup authentication: %v", err)
+		}
+		engine.Use(authenticator.Middleware())
+	} else if strings.EqualFold(os.Getenv("PN_REGISTRY_API_API_KEYS_ENABLED"), "true") {
+		log.Fatalf("API keys require authentication, configure PN_REGISTRY_API_AUTH_JWT_SECRET or PN_REGISTRY_API_AUTH_JWKS")
+	} else {
+		log.Printf("Authentication of requests is disabled, configure PN_REGISTRY_API_AUTH_JWT_SECRET or PN_REGISTRY_API_AUTH_JWKS to enable it")
+	}
+
+	// setup access policy of API operations, roles of callers are known only when authentication is enabled
+	if policyFile := os.Getenv("PN_REGISTRY_API_ACCESS_POLICY_FILE"); policyFile != "" {
+		if !authenticated {
+			log.Fatalf("Access policy requires authentication, configure PN_REGISTRY_API_AUTH_JWT_SECRET or PN_REGISTRY_API_AUTH_JWKS")
+		}
+		if err := pn_registry.LoadAccessPolicyFile(policyFile); err != nil {
+			log.Fatalf("Failed to load access policy: %v", err)
+		}
+	}
+
+	// setup purge job of deleted records, records are purged only when retention period is configured
+	if days, err := strconv.Atoi(os.Getenv("PN_REGISTRY_API_PURGE_RETENTION_DAYS")); err == nil && days > 0 {
+		interval := time.Hour
+		if minutes, err := strconv.Atoi(os.Getenv("PN_REGISTRY_API_PURGE_INTERVAL_MINUTES")); err == nil && minutes > 0 {
+			interval = time.Duration(minutes) * time.Minute
+		}
+		purgeCtx, purgeCancel := context.WithCancel(context.Background())
+		defer purgeCancel()
+		for _, tenantId := range tenants {
+			dbService, _ := dbServices.For(tenantId)
+			auditService, _ := auditServices.For(tenantId)
+			pn_registry.StartPurgeJob(purgeCtx, dbService, auditService, time.Duration(days)*24*time.Hour, interval)
+		}
+	}
+
+	// setup catalog of reasons, built-in reasons are used unless catalog is configured in file or database
+	switch strings.ToLower(os.Getenv("PN_REGISTRY_API_REASONS_SOURCE")) {
+	case "file":
+		if err := pn_registry.LoadReasonCatalogFile(os.Getenv("PN_REGISTRY_API_REASONS_FILE")); err != nil {
+			log.Fatalf("Failed to load reason catalog: %v", err)
+		}
+	case "database":
+		reasonCollection := os.Getenv("PN_REGISTRY_API_MONGODB_REASON_COLLECTION")
+		if reasonCollection == "" {
+			reasonCollection = "reason"
+		}
+		// catalog of reasons is shared by all tenants
+		reasonService := newDbService[pn_registry.Reason](reasonCollection, "", nil)
+		defer reasonService.Disconnect(context.Background())
+
+		interval := 5 * time.Minute
+		if minutes, err := strconv.Atoi(os.Getenv("PN_REGISTRY_API_REASONS_REFRESH_MINUTES")); err == nil && minutes > 0 {
+			interval = time.Duration(minutes) * time.Minute
+		}
+		reasonsCtx, reasonsCancel := context.WithCancel(context.Background())
+		defer reasonsCancel()
+		pn_registry.StartReasonCatalogSync(reasonsCtx, reasonService, interval)
+	}
+
+	// catalog of diagnoses bundled with the service can be replaced by full ICD-10 classification
+	if catalogFile := os.Getenv("PN_REGISTRY_API_DIAGNOSIS_CATALOG_FILE"); catalogFile != "" {
+		if err := pn_registry.LoadDiagnosisCatalogFile(catalogFile); err != nil {
+			log.Fatalf("Failed to load catalog of diagnoses: %v", err)
+		}
+	}
+
+	// register custom validators for patientId,fullname,employer,reason,diagnosis and ico fields
 	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
-		v.RegisterValidation("only-digits-max-length-10", pn_registry.PatientIDValidator)
+		// patient IDs can be optionally validated as Slovak/Czech birth numbers (rodné číslo)
+		if strings.EqualFold(os.Getenv("PN_REGISTRY_API_PATIENT_ID_VALIDATION"), "birth-number") {
+			v.RegisterValidation("only-digits-max-length-10", pn_registry.BirthNumberValidator)
+			v.RegisterStructValidation(pn_registry.PatientBirthNumberValidator, pn_registry.Patient{})
+		} else {
+			v.RegisterValidation("only-digits-max-length-10", pn_registry.PatientIDValidator)
+		}
 		v.RegisterValidation("max-length-50", pn_registry.MaxLengthValidator)
 		v.RegisterValidation("not-valid-reason-value", pn_registry.ReasonValidator)
+		v.RegisterValidation("company-id", pn_registry.CompanyIDValidator)
+		// diagnoses are validated by format of ICD-10 code unless only codes from the catalog are allowed
+		if strings.EqualFold(os.Getenv("PN_REGISTRY_API_DIAGNOSIS_VALIDATION"), "catalog") {
+			v.RegisterValidation("icd10-code", pn_registry.DiagnosisValidator)
+		} else {
+			v.RegisterValidation("icd10-code", pn_registry.DiagnosisFormatValidator)
+		}
 	}
 
 	// request routings
-	pn_registry.AddRoutes(engine)
+	// tenant of the request is resolved before services of the tenant are put into the context
+	apiMiddleware := []gin.HandlerFunc{servicesMiddleware}
+	if len(tenants) > 1 || tenants[0] != "" {
+		tenantConfig := tenant.Config{
+			Tenants:       tenants,
+			Claim:         tenantClaim,
+			Header:        "X-Tenant-ID",
+			Authenticated: authenticated,
+		}
+		if header := os.Getenv("PN_REGISTRY_API_TENANT_HEADER"); header != "" {
+			tenantConfig.Header = header
+		}
+		apiMiddleware = append([]gin.HandlerFunc{tenant.Middleware(tenantConfig)}, apiMiddleware...)
+	}
+	pn_registry.AddRoutes(engine, apiMiddleware...)
 	engine.GET("/openapi", api.HandleOpenApi)
 	engine.Run(":" + port)
 }
+
+// Utility function which creates service of documents in the collection of the tenant,
+// empty collection means default collection of records, nil encryption means no encrypted fields
+func newDbService[DocType interface{}](collection string, tenantId string, encryption *db_service.FieldEncryption) db_service.DbService[DocType] {
+	if strings.EqualFold(os.Getenv("PN_REGISTRY_API_DB_TYPE"), "memory") {
+		return db_service.NewMemoryService[DocType](db_service.MemoryServiceConfig{
+			Encryption: encryption,
+		})
+	}
+	return db_service.NewMongoService[DocType](db_service.MongoServiceConfig{
+		Collection: collection,
+		Tenant:     tenantId,
+		Encryption: encryption,
+	})
+}
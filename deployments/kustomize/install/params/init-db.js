@@ -4,6 +4,8 @@ const mongoPort = process.env.PN_REGISTRY_API_MONGODB_PORT;
 const mongoUser = process.env.PN_REGISTRY_API_MONGODB_USERNAME;
 const mongoPassword = process.env.PN_REGISTRY_API_MONGODB_PASSWORD;
 
+const replicaSet = process.env.PN_REGISTRY_API_MONGODB_REPLICA_SET;
+
 const database = process.env.PN_REGISTRY_API_MONGODB_DATABASE;
 const collection = process.env.PN_REGISTRY_API_MONGODB_COLLECTION;
 
@@ -13,8 +15,9 @@ const retrySeconds = parseInt(process.env.RETRY_CONNECTION_SECONDS || '5') || 5;
 let connection;
 while (true) {
   try {
+    // direct connection, members of replica set which is not initiated yet can not be discovered
     connection = Mongo(
-      `mongodb://${mongoUser}:${mongoPassword}@${mongoHost}:${mongoPort}`
+      `mongodb://${mongoUser}:${mongoPassword}@${mongoHost}:${mongoPort}/?directConnection=true`
     );
     break;
   } catch (exception) {
@@ -24,6 +27,31 @@ while (true) {
   }
 }
 
+// initiate single member replica set, transactions are not supported by standalone server
+if (replicaSet) {
+  const admin = connection.getDB('admin');
+  try {
+    admin.adminCommand({ replSetGetStatus: 1 });
+  } catch (exception) {
+    if (exception.codeName !== 'NotYetInitialized') {
+      throw exception;
+    }
+    print(`Initiating replica set '${replicaSet}'`);
+    admin.adminCommand({
+      replSetInitiate: {
+        _id: replicaSet,
+        members: [{ _id: 0, host: `${mongoHost}:${mongoPort}` }],
+      },
+    });
+  }
+
+  // wait until the member is elected as primary and accepts writes
+  while (!admin.adminCommand({ hello: 1 }).isWritablePrimary) {
+    print(`Waiting for primary of replica set '${replicaSet}'`);
+    sleep(1000);
+  }
+}
+
 // if database and collection exists, exit with success - already initialized
 const databases = connection.getDBNames();
 if (databases.includes(database)) {
@@ -43,7 +71,7 @@ const db = connection.getDB(database);
 db.createCollection(collection);
 
 // create indexes
-db[collection].createIndex({ id: 1 });
+db[collection].createIndex({ id: 1 }, { unique: true });
 
 //insert sample data
 let result = db[collection].insertMany([]);
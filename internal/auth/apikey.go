@@ -0,0 +1,17 @@
+package auth
+
+import (
+	"context"
+)
+
+// header with API key of machine clients
+const ApiKeyHeader = "X-API-Key"
+
+// KeyVerifier verifies API keys of machine clients, which cannot obtain bearer tokens
+type KeyVerifier interface {
+	// returns identity of the client owning the key, error when the key is not valid
+	VerifyKey(ctx context.Context, key string) (Identity, error)
+
+	// records request authenticated by the key with its response status
+	RecordUsage(keyId string, status int, clientIp string)
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testHmacSecret = []byte("test-secret-of-at-least-32-bytes!")
+
+var (
+	testRsaKeysOnce sync.Once
+	testRsaKeys     []*rsa.PrivateKey
+)
+
+// RSA keys shared by tests, because their generation is slow
+func testRsaKey(t *testing.T, index int) *rsa.PrivateKey {
+	t.Helper()
+	testRsaKeysOnce.Do(func() {
+		for i := 0; i < 2; i++ {
+			key, err := rsa.GenerateKey(rand.Reader, 2048)
+			if err != nil {
+				panic(err)
+			}
+			testRsaKeys = append(testRsaKeys, key)
+		}
+	})
+	return testRsaKeys[index]
+}
+
+// encodes header and claims into unsigned part of the token
+func unsignedToken(header map[string]interface{}, claims map[string]interface{}) string {
+	encode := func(value interface{}) string {
+		data, _ := json.Marshal(value)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	return encode(header) + "." + encode(claims)
+}
+
+// token signed by HS256 with the secret
+func signHS256(secret []byte, header map[string]interface{}, claims map[string]interface{}) string {
+	return signSegments(secret, unsignedToken(header, claims))
+}
+
+// appends HS256 signature to already encoded header and claims
+func signSegments(secret []byte, unsigned string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// token signed by RS256 with the private key
+func signRS256(t *testing.T, key *rsa.PrivateKey, header map[string]interface{}, claims map[string]interface{}) string {
+	t.Helper()
+	unsigned := unsignedToken(header, claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// valid claims of the caller, which can be changed by the test before the token is signed
+func testClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"sub":  "user-1",
+		"name": "Jozef Mrkvicka",
+		"iss":  "https://issuer.example",
+		"aud":  "pn-registry",
+		"exp":  float64(time.Now().Add(time.Hour).Unix()),
+	}
+}
+
+// JSON Web Key Set with public parts of the keys
+func testJwks(keys map[string]*rsa.PrivateKey) []byte {
+	set := jsonWebKeySet{}
+	for kid, key := range keys {
+		set.Keys = append(set.Keys, jsonWebKey{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	data, _ := json.Marshal(set)
+	return data
+}
+
+// server of JWKS, keys can be rotated and the server can fail, requests are counted
+type jwksServer struct {
+	*httptest.Server
+	lock     sync.Mutex
+	keys     map[string]*rsa.PrivateKey
+	failing  bool
+	requests int
+}
+
+func newJwksServer(t *testing.T, keys map[string]*rsa.PrivateKey) *jwksServer {
+	t.Helper()
+	server := &jwksServer{keys: keys}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		server.lock.Lock()
+		defer server.lock.Unlock()
+		server.requests++
+		if server.failing {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Write(testJwks(server.keys))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (this *jwksServer) rotate(keys map[string]*rsa.PrivateKey) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.keys = keys
+}
+
+func (this *jwksServer) fail(failing bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.failing = failing
+}
+
+func (this *jwksServer) requestCount() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.requests
+}
+
+// fails the test when the error does not contain expected message, empty message means no error is expected
+func expectError(t *testing.T, err error, message string) {
+	t.Helper()
+	switch {
+	case message == "" && err != nil:
+		t.Errorf("Expected no error, got %v", err)
+	case message != "" && err == nil:
+		t.Errorf("Expected error %q, got none", message)
+	case message != "" && !strings.Contains(err.Error(), message):
+		t.Errorf("Expected error %q, got %v", message, err)
+	}
+}
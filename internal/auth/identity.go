@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// key of the caller identity in gin context
+const identityKey = "identity"
+
+// Identity of authenticated caller taken from claims of the bearer token or from API key
+type Identity struct {
+	Subject string
+	Name    string
+	Roles   []string
+	Claims  map[string]interface{}
+	KeyId   string // ID of API key, empty when the caller is authenticated by bearer token
+}
+
+// returns identity of the caller, which is present only when authentication is enabled
+func IdentityFromContext(ctx *gin.Context) (Identity, bool) {
+	value, exists := ctx.Get(identityKey)
+	if !exists {
+		return Identity{}, false
+	}
+	identity, ok := value.(Identity)
+	return identity, ok
+}
+
+// returns value of the claim, claims nested in objects are addressed by dot separated path (e.g. "realm_access.roles")
+func (this Identity) Claim(path string) (interface{}, bool) {
+	var value interface{} = this.Claims
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[name]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// whether the caller has the role
+func (this Identity) HasRole(role string) bool {
+	return slices.Contains(this.Roles, role)
+}
+
+func newIdentity(claims map[string]interface{}, rolesClaim string) Identity {
+	identity := Identity{Claims: claims}
+	identity.Subject, _ = claims["sub"].(string)
+	for _, claim := range []string{"name", "preferred_username", "email"} {
+		if name, ok := claims[claim].(string); ok && name != "" {
+			identity.Name = name
+			break
+		}
+	}
+	if identity.Name == "" {
+		identity.Name = identity.Subject
+	}
+
+	// roles claim can be single role or array of roles
+	if rolesClaim != "" {
+		value, _ := identity.Claim(rolesClaim)
+		switch roles := value.(type) {
+		case string:
+			identity.Roles = strings.Fields(roles)
+		case []interface{}:
+			for _, role := range roles {
+				if role, ok := role.(string); ok {
+					identity.Roles = append(identity.Roles, role)
+				}
+			}
+		}
+	}
+	return identity
+}
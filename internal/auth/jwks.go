@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minimal interval between reloads of JWKS from URL caused by tokens with unknown key ID,
+// failed reloads count too, so that unavailable JWKS endpoint is not requested by every such token
+const jwksMinReloadInterval = time.Minute
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// RSA public keys of JSON Web Key Set (RFC 7517) loaded from local file or URL
+type keySet struct {
+	source      string
+	keys        map[string]*rsa.PublicKey
+	loadedAt    time.Time
+	attemptedAt time.Time // time of last load, also of failed one
+	lock        sync.RWMutex
+	client      *http.Client
+}
+
+func newKeySet(source string) *keySet {
+	return &keySet{
+		source: source,
+		keys:   map[string]*rsa.PublicKey{},
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (this *keySet) isRemote() bool {
+	return strings.HasPrefix(this.source, "http://") || strings.HasPrefix(this.source, "https://")
+}
+
+// loads keys from the source and replaces previously loaded keys
+func (this *keySet) Load(ctx context.Context) error {
+	this.lock.Lock()
+	this.attemptedAt = time.Now()
+	this.lock.Unlock()
+
+	var data []byte
+	var err error
+	if this.isRemote() {
+		data, err = this.fetch(ctx)
+	} else {
+		data, err = os.ReadFile(this.source)
+	}
+	if err != nil {
+		return err
+	}
+
+	var set jsonWebKeySet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return fmt.Errorf("JWKS is malformed: %w", err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, key := range set.Keys {
+		// only RSA signing keys are used
+		if key.Kty != "RSA" || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+		publicKey, err := rsaPublicKey(key)
+		if err != nil {
+			return fmt.Errorf("JWKS key %v is invalid: %w", key.Kid, err)
+		}
+		keys[key.Kid] = publicKey
+	}
+	if len(keys) == 0 {
+		return errors.New("JWKS contains no RSA signing key")
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.keys = keys
+	this.loadedAt = time.Now()
+	return nil
+}
+
+func (this *keySet) fetch(ctx context.Context) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, this.source, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := this.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS request failed with status %v", response.Status)
+	}
+	return io.ReadAll(io.LimitReader(response.Body, 1024*1024))
+}
+
+// finds key by key ID, token without key ID can be verified only when the set has single key.
+// Remote set is reloaded when the key is not known, so that rotated keys are picked up.
+func (this *keySet) Find(ctx context.Context, kid string) (*rsa.PublicKey, bool) {
+	if key, found := this.find(kid); found {
+		return key, true
+	}
+
+	// the attempt is claimed under the lock, so that concurrent tokens with unknown key cause single reload
+	this.lock.Lock()
+	reload := this.isRemote() && time.Since(this.attemptedAt) > jwksMinReloadInterval
+	if reload {
+		this.attemptedAt = time.Now()
+	}
+	this.lock.Unlock()
+	if !reload {
+		return nil, false
+	}
+
+	if err := this.Load(ctx); err != nil {
+		log.Printf("Failed to reload JWKS from %v: %v", this.source, err)
+		return nil, false
+	}
+	return this.find(kid)
+}
+
+func (this *keySet) find(kid string) (*rsa.PublicKey, bool) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	if kid == "" && len(this.keys) == 1 {
+		for _, key := range this.keys {
+			return key, true
+		}
+	}
+	key, found := this.keys[kid]
+	return key, found
+}
+
+// periodically reloads keys from URL until the context is cancelled
+func (this *keySet) StartRefresh(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := this.Load(ctx); err != nil {
+					log.Printf("Failed to refresh JWKS from %v: %v", this.source, err)
+				}
+			}
+		}
+	}()
+}
+
+func rsaPublicKey(key jsonWebKey) (*rsa.PublicKey, error) {
+	modulus, err := base64.RawURLEncoding.DecodeString(key.N)
+	if err != nil || len(modulus) == 0 {
+		return nil, errors.New("modulus is malformed")
+	}
+	exponent, err := base64.RawURLEncoding.DecodeString(key.E)
+	if err != nil || len(exponent) == 0 || len(exponent) > 4 {
+		return nil, errors.New("exponent is malformed")
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(modulus),
+		E: int(new(big.Int).SetBytes(exponent).Int64()),
+	}, nil
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// makes last load of the key set older than minimal reload interval, as if the interval elapsed
+func expireKeySet(keys *keySet) {
+	keys.lock.Lock()
+	defer keys.lock.Unlock()
+	keys.attemptedAt = time.Now().Add(-2 * jwksMinReloadInterval)
+}
+
+func TestKeySetFind(t *testing.T) {
+	ctx := context.Background()
+	k1, k2 := testRsaKey(t, 0), testRsaKey(t, 1)
+	server := newJwksServer(t, map[string]*rsa.PrivateKey{"k1": k1})
+	keys := newKeySet(server.URL)
+	if err := keys.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	expectKey := func(kid string, expected *rsa.PrivateKey, requests int) {
+		t.Helper()
+		key, found := keys.Find(ctx, kid)
+		if expected == nil && found {
+			t.Errorf("Expected key %q not to be found", kid)
+		}
+		if expected != nil && (!found || !key.Equal(&expected.PublicKey)) {
+			t.Errorf("Expected key %q to be found", kid)
+		}
+		if count := server.requestCount(); count != requests {
+			t.Errorf("Expected %v requests of JWKS, got %v", requests, count)
+		}
+	}
+
+	expectKey("k1", k1, 1)
+	// token without key ID is verified by the only key of the set
+	expectKey("", k1, 1)
+	// unknown key does not reload the set before the minimal interval elapses
+	expectKey("k2", nil, 1)
+
+	// rotated key is picked up by reload when it is not known
+	server.rotate(map[string]*rsa.PrivateKey{"k1": k1, "k2": k2})
+	expireKeySet(keys)
+	expectKey("k2", k2, 2)
+	expectKey("k1", k1, 2)
+	// token without key ID is ambiguous when the set has more keys
+	expectKey("", nil, 2)
+
+	// removed key is not found anymore after reload
+	server.rotate(map[string]*rsa.PrivateKey{"k2": k2})
+	expireKeySet(keys)
+	expectKey("k3", nil, 3)
+	expectKey("k1", nil, 3)
+}
+
+func TestKeySetReloadFailure(t *testing.T) {
+	ctx := context.Background()
+	k1, k2 := testRsaKey(t, 0), testRsaKey(t, 1)
+	server := newJwksServer(t, map[string]*rsa.PrivateKey{"k1": k1})
+	keys := newKeySet(server.URL)
+	if err := keys.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// failed reload is rate limited in the same way as successful one
+	server.fail(true)
+	expireKeySet(keys)
+	for i := 0; i < 3; i++ {
+		if _, found := keys.Find(ctx, "k2"); found {
+			t.Errorf("Expected unknown key not to be found")
+		}
+	}
+	if count := server.requestCount(); count != 2 {
+		t.Errorf("Expected single reload of failing JWKS, got %v requests", count-1)
+	}
+	if _, found := keys.Find(ctx, "k1"); !found {
+		t.Errorf("Expected keys to be kept after failed reload")
+	}
+
+	server.fail(false)
+	server.rotate(map[string]*rsa.PrivateKey{"k2": k2})
+	if _, found := keys.Find(ctx, "k2"); found {
+		t.Errorf("Expected no reload before minimal interval after failed reload")
+	}
+	expireKeySet(keys)
+	if _, found := keys.Find(ctx, "k2"); !found {
+		t.Errorf("Expected rotated key after the interval")
+	}
+}
+
+func TestKeySetLoad(t *testing.T) {
+	k1 := testRsaKey(t, 0)
+	dir := t.TempDir()
+	write := func(name string, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name   string
+		source string
+		err    string
+	}{
+		{"file", write("valid.json", string(testJwks(map[string]*rsa.PrivateKey{"k1": k1}))), ""},
+		{"missing file", filepath.Join(dir, "missing.json"), "no such file"},
+		{"malformed", write("malformed.json", `{"keys": [`), "JWKS is malformed"},
+		{"without RSA key", write("ec.json", `{"keys": [{"kty": "EC", "kid": "k1"}]}`), "no RSA signing key"},
+		{"encryption key only", write("enc.json", `{"keys": [{"kty": "RSA", "kid": "k1", "use": "enc", "n": "AQAB", "e": "AQAB"}]}`), "no RSA signing key"},
+		{"malformed modulus", write("modulus.json", `{"keys": [{"kty": "RSA", "kid": "k1", "n": "!!", "e": "AQAB"}]}`), "modulus is malformed"},
+		{"malformed exponent", write("exponent.json", `{"keys": [{"kty": "RSA", "kid": "k1", "n": "AQAB", "e": "AQABAQAB"}]}`), "exponent is malformed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expectError(t, newKeySet(test.source).Load(context.Background()), test.err)
+		})
+	}
+
+	// local file is never reloaded by unknown key
+	keys := newKeySet(tests[0].source)
+	if err := keys.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expireKeySet(keys)
+	write("valid.json", `{"keys": [`)
+	if _, found := keys.Find(context.Background(), "k2"); found {
+		t.Errorf("Expected unknown key not to be found")
+	}
+	if _, found := keys.Find(context.Background(), "k1"); !found {
+		t.Errorf("Expected keys of the file to be kept")
+	}
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// supported signature algorithms of tokens
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+)
+
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// resolves key which verifies signature of the token - []byte for HS256, *rsa.PublicKey for RS256
+type keyResolver func(header tokenHeader) (interface{}, error)
+
+// Utility function which parses token in JWS compact serialization, verifies its signature
+// and returns its claims. Claims are not validated, see validateClaims.
+func parseToken(token string, resolveKey keyResolver) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token is malformed")
+	}
+
+	var header tokenHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("token header is malformed: %w", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("token signature is malformed")
+	}
+
+	key, err := resolveKey(header)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	switch header.Alg {
+	case AlgorithmHS256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return nil, errors.New("token is signed with algorithm not matching the key")
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return nil, errors.New("token signature is invalid")
+		}
+	case AlgorithmRS256:
+		publicKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, errors.New("token is signed with algorithm not matching the key")
+		}
+		digest := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature); err != nil {
+			return nil, errors.New("token signature is invalid")
+		}
+	default:
+		return nil, fmt.Errorf("token signing algorithm %v is not supported", header.Alg)
+	}
+
+	claims := map[string]interface{}{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("token claims are malformed: %w", err)
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, target interface{}) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(decoded, target)
+}
+
+// Utility function which checks expiry (required), not before, issuer and audience of the token,
+// issuer and audience are checked only when they are configured
+func validateClaims(claims map[string]interface{}, issuer string, audience string, leeway time.Duration, now time.Time) error {
+	expiresAt, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("token has no expiry")
+	}
+	if now.After(time.Unix(int64(expiresAt), 0).Add(leeway)) {
+		return errors.New("token is expired")
+	}
+
+	if notBefore, ok := claims["nbf"].(float64); ok && now.Add(leeway).Before(time.Unix(int64(notBefore), 0)) {
+		return errors.New("token is not valid yet")
+	}
+
+	if issuer != "" {
+		if tokenIssuer, _ := claims["iss"].(string); tokenIssuer != issuer {
+			return errors.New("token has invalid issuer")
+		}
+	}
+
+	if audience != "" {
+		var audiences []string
+		switch tokenAudience := claims["aud"].(type) {
+		case string:
+			audiences = []string{tokenAudience}
+		case []interface{}:
+			for _, value := range tokenAudience {
+				if value, ok := value.(string); ok {
+					audiences = append(audiences, value)
+				}
+			}
+		}
+		if !slices.Contains(audiences, audience) {
+			return errors.New("token has invalid audience")
+		}
+	}
+
+	return nil
+}
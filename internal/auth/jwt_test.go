@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseToken(t *testing.T) {
+	rsaKey := testRsaKey(t, 0)
+	publicKeyDer, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs256 := map[string]interface{}{"alg": AlgorithmHS256, "typ": "JWT"}
+	rs256 := map[string]interface{}{"alg": AlgorithmRS256, "kid": "k1"}
+	valid := signHS256(testHmacSecret, hs256, testClaims())
+	segments := strings.Split(valid, ".")
+
+	// keys resolved by algorithm of the token in the same way as by authenticator
+	resolveKey := func(header tokenHeader) (interface{}, error) {
+		switch header.Alg {
+		case AlgorithmHS256:
+			return testHmacSecret, nil
+		case AlgorithmRS256:
+			return &rsaKey.PublicKey, nil
+		}
+		return nil, errors.New("token signing algorithm is not supported")
+	}
+	resolveHmac := func(header tokenHeader) (interface{}, error) { return testHmacSecret, nil }
+	resolveRsa := func(header tokenHeader) (interface{}, error) { return &rsaKey.PublicKey, nil }
+
+	tests := []struct {
+		name       string
+		token      string
+		resolveKey keyResolver
+		err        string
+	}{
+		{"HS256", valid, resolveKey, ""},
+		{"RS256", signRS256(t, rsaKey, rs256, testClaims()), resolveKey, ""},
+		{"RS256 token verified by HMAC key", signRS256(t, rsaKey, rs256, testClaims()), resolveHmac, "algorithm not matching the key"},
+		{"HS256 token verified by RSA key", valid, resolveRsa, "algorithm not matching the key"},
+		{"HS256 token signed by RSA public key", signHS256(publicKeyDer, hs256, testClaims()), resolveKey, "signature is invalid"},
+		{"HS256 token signed by other secret", signHS256([]byte("other"), hs256, testClaims()), resolveKey, "signature is invalid"},
+		{"alg none without signature", unsignedToken(map[string]interface{}{"alg": "none"}, testClaims()) + ".", resolveKey, "not supported"},
+		{"alg none with signature", signHS256(testHmacSecret, map[string]interface{}{"alg": "none"}, testClaims()), resolveHmac, "not supported"},
+		{"missing alg", signHS256(testHmacSecret, map[string]interface{}{}, testClaims()), resolveHmac, "not supported"},
+		{"lowercase alg", signHS256(testHmacSecret, map[string]interface{}{"alg": "hs256"}, testClaims()), resolveHmac, "not supported"},
+		{"tampered claims", segments[0] + "." + unsignedClaims(map[string]interface{}{"sub": "admin"}) + "." + segments[2], resolveKey, "signature is invalid"},
+		{"empty token", "", resolveKey, "token is malformed"},
+		{"two segments", segments[0] + "." + segments[1], resolveKey, "token is malformed"},
+		{"four segments", valid + "." + segments[2], resolveKey, "token is malformed"},
+		{"header not base64", "!!." + segments[1] + "." + segments[2], resolveKey, "header is malformed"},
+		{"header not JSON", base64.RawURLEncoding.EncodeToString([]byte("HS256")) + "." + segments[1] + "." + segments[2], resolveKey, "header is malformed"},
+		{"header with padding", segments[0] + "=." + segments[1] + "." + segments[2], resolveKey, "header is malformed"},
+		{"signature not base64", segments[0] + "." + segments[1] + ".!!", resolveKey, "signature is malformed"},
+		{"claims not JSON", signSegments(testHmacSecret, segments[0]+"."+base64.RawURLEncoding.EncodeToString([]byte("[1"))), resolveKey, "claims are malformed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims, err := parseToken(test.token, test.resolveKey)
+			expectError(t, err, test.err)
+			if err == nil && claims["sub"] != "user-1" {
+				t.Errorf("Expected claims of the token, got %v", claims)
+			}
+		})
+	}
+}
+
+// encoded claims segment of the token
+func unsignedClaims(claims map[string]interface{}) string {
+	return strings.Split(unsignedToken(nil, claims), ".")[1]
+}
+
+func TestValidateClaims(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(offset time.Duration) float64 { return float64(now.Add(offset).Unix()) }
+	leeway := 30 * time.Second
+
+	tests := []struct {
+		name     string
+		claims   map[string]interface{}
+		issuer   string
+		audience string
+		err      string
+	}{
+		{"valid", map[string]interface{}{"exp": at(time.Hour)}, "", "", ""},
+		{"missing expiry", map[string]interface{}{}, "", "", "token has no expiry"},
+		{"expiry not number", map[string]interface{}{"exp": "2100-01-01"}, "", "", "token has no expiry"},
+		{"expired", map[string]interface{}{"exp": at(-time.Minute)}, "", "", "token is expired"},
+		{"expired within leeway", map[string]interface{}{"exp": at(-20 * time.Second)}, "", "", ""},
+		{"not before in future", map[string]interface{}{"exp": at(time.Hour), "nbf": at(time.Minute)}, "", "", "token is not valid yet"},
+		{"not before within leeway", map[string]interface{}{"exp": at(time.Hour), "nbf": at(20 * time.Second)}, "", "", ""},
+		{"not before in past", map[string]interface{}{"exp": at(time.Hour), "nbf": at(-time.Hour)}, "", "", ""},
+		{"issuer", map[string]interface{}{"exp": at(time.Hour), "iss": "https://issuer.example"}, "https://issuer.example", "", ""},
+		{"wrong issuer", map[string]interface{}{"exp": at(time.Hour), "iss": "https://other.example"}, "https://issuer.example", "", "token has invalid issuer"},
+		{"missing issuer", map[string]interface{}{"exp": at(time.Hour)}, "https://issuer.example", "", "token has invalid issuer"},
+		{"any issuer", map[string]interface{}{"exp": at(time.Hour), "iss": "https://other.example"}, "", "", ""},
+		{"audience", map[string]interface{}{"exp": at(time.Hour), "aud": "pn-registry"}, "", "pn-registry", ""},
+		{"audience in array", map[string]interface{}{"exp": at(time.Hour), "aud": []interface{}{"account", "pn-registry"}}, "", "pn-registry", ""},
+		{"wrong audience", map[string]interface{}{"exp": at(time.Hour), "aud": "account"}, "", "pn-registry", "token has invalid audience"},
+		{"wrong audience in array", map[string]interface{}{"exp": at(time.Hour), "aud": []interface{}{"account", 1}}, "", "pn-registry", "token has invalid audience"},
+		{"missing audience", map[string]interface{}{"exp": at(time.Hour)}, "", "pn-registry", "token has invalid audience"},
+		{"any audience", map[string]interface{}{"exp": at(time.Hour), "aud": "account"}, "", "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expectError(t, validateClaims(test.claims, test.issuer, test.audience, leeway, now), test.err)
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Configuration of bearer token authentication, tokens signed by HS256 are accepted when HmacSecret is set,
+// tokens signed by RS256 when JwksSource (path of local file or URL) is set
+type Config struct {
+	HmacSecret   []byte
+	JwksSource   string
+	JwksRefresh  time.Duration // refresh interval of JWKS loaded from URL
+	Issuer       string        // required "iss" claim, empty means any issuer
+	Audience     string        // required value of "aud" claim, empty means any audience
+	Leeway       time.Duration // tolerated clock skew when checking "exp" and "nbf" claims
+	PublicRoutes []string      // paths accessible without token, path ending with '*' matches all paths with the prefix
+	RolesClaim   string        // claim with roles of the caller, nested claim is addressed by dot separated path
+	ApiKeys      KeyVerifier   // verifier of API keys sent in X-API-Key header, nil when API keys are not accepted
+}
+
+// Authenticator validates JWT bearer tokens of requests
+type Authenticator struct {
+	config Config
+	keys   *keySet
+}
+
+func NewAuthenticator(ctx context.Context, config Config) (*Authenticator, error) {
+	if len(config.HmacSecret) == 0 && config.JwksSource == "" {
+		return nil, errors.New("HMAC secret or JWKS must be configured")
+	}
+
+	authenticator := &Authenticator{config: config}
+	if config.JwksSource != "" {
+		authenticator.keys = newKeySet(config.JwksSource)
+		if err := authenticator.keys.Load(ctx); err != nil {
+			return nil, fmt.Errorf("failed to load JWKS from %v: %w", config.JwksSource, err)
+		}
+		if authenticator.keys.isRemote() && config.JwksRefresh > 0 {
+			authenticator.keys.StartRefresh(ctx, config.JwksRefresh)
+		}
+	}
+
+	log.Printf("Authentication of requests by JWT bearer tokens is enabled, public routes: %v", config.PublicRoutes)
+	if config.ApiKeys != nil {
+		log.Printf("Authentication of machine clients by API keys in %v header is enabled", ApiKeyHeader)
+	}
+	return authenticator, nil
+}
+
+// gin middleware which rejects requests without valid bearer token or API key with 401 and puts identity
+// of the caller into the context, requests to public routes are passed without authentication
+func (this *Authenticator) Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if this.isPublic(ctx.Request.URL.Path) {
+			ctx.Next()
+			return
+		}
+
+		if key := ctx.GetHeader(ApiKeyHeader); key != "" && this.config.ApiKeys != nil {
+			this.authenticateKey(ctx, key)
+			return
+		}
+
+		token, found := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+		if !found || strings.TrimSpace(token) == "" {
+			ctx.Header("WWW-Authenticate", `Bearer realm="pn-registry"`)
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized,
+				gin.H{
+					"status":  "Unauthorized",
+					"message": "Missing bearer token",
+					"error":   "Authorization header with bearer token is required",
+				})
+			return
+		}
+
+		identity, err := this.Authenticate(ctx, strings.TrimSpace(token))
+		if err != nil {
+			ctx.Header("WWW-Authenticate", fmt.Sprintf(`Bearer realm="pn-registry", error="invalid_token", error_description="%v"`, err))
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized,
+				gin.H{
+					"status":  "Unauthorized",
+					"message": "Invalid bearer token",
+					"error":   err.Error(),
+				})
+			return
+		}
+
+		ctx.Set(identityKey, identity)
+		ctx.Next()
+	}
+}
+
+// verifies signature and claims of the token and returns identity of the caller
+func (this *Authenticator) Authenticate(ctx context.Context, token string) (Identity, error) {
+	claims, err := parseToken(token, func(header tokenHeader) (interface{}, error) {
+		switch header.Alg {
+		case AlgorithmHS256:
+			if len(this.config.HmacSecret) == 0 {
+				return nil, errors.New("tokens signed by HS256 are not accepted")
+			}
+			return this.config.HmacSecret, nil
+		case AlgorithmRS256:
+			if this.keys == nil {
+				return nil, errors.New("tokens signed by RS256 are not accepted")
+			}
+			key, found := this.keys.Find(ctx, header.Kid)
+			if !found {
+				return nil, fmt.Errorf("token signing key %v is not known", header.Kid)
+			}
+			return key, nil
+		default:
+			return nil, fmt.Errorf("token signing algorithm %v is not supported", header.Alg)
+		}
+	})
+	if err != nil {
+		return Identity{}, err
+	}
+
+	if err := validateClaims(claims, this.config.Issuer, this.config.Audience, this.config.Leeway, time.Now()); err != nil {
+		return Identity{}, err
+	}
+
+	identity := newIdentity(claims, this.config.RolesClaim)
+	if identity.Subject == "" {
+		return Identity{}, errors.New("token has no subject")
+	}
+	return identity, nil
+}
+
+// Utility function which authenticates request by API key and records usage of the key
+func (this *Authenticator) authenticateKey(ctx *gin.Context, key string) {
+	identity, err := this.config.ApiKeys.VerifyKey(ctx, key)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized,
+			gin.H{
+				"status":  "Unauthorized",
+				"message": "Invalid API key",
+				"error":   err.Error(),
+			})
+		return
+	}
+
+	ctx.Set(identityKey, identity)
+	ctx.Next()
+	this.config.ApiKeys.RecordUsage(identity.KeyId, ctx.Writer.Status(), ctx.ClientIP())
+}
+
+func (this *Authenticator) isPublic(path string) bool {
+	for _, route := range this.config.PublicRoutes {
+		if prefix, isPrefix := strings.CutSuffix(route, "*"); isPrefix {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if path == route {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// verifier of API keys which accepts single key and records usage of it
+type testKeyVerifier struct {
+	lock  sync.Mutex
+	usage []int
+}
+
+func (this *testKeyVerifier) VerifyKey(ctx context.Context, key string) (Identity, error) {
+	if key != "pn_valid" {
+		return Identity{}, errors.New("API key is not valid")
+	}
+	return Identity{Subject: "client-1", Name: "Import job", Roles: []string{"importer"}, KeyId: "key-1"}, nil
+}
+
+func (this *testKeyVerifier) RecordUsage(keyId string, status int, clientIp string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.usage = append(this.usage, status)
+}
+
+// creates engine with authentication middleware and route which responds with identity of the caller
+func newTestAuthEngine(t *testing.T, config Config) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	authenticator, err := NewAuthenticator(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine := gin.New()
+	engine.Use(authenticator.Middleware())
+	respond := func(ctx *gin.Context) {
+		identity, _ := IdentityFromContext(ctx)
+		ctx.JSON(http.StatusOK, gin.H{"subject": identity.Subject, "roles": strings.Join(identity.Roles, ",")})
+	}
+	engine.GET("/api/records/", respond)
+	engine.GET("/api/info", respond)
+	engine.GET("/openapi/spec.yaml", respond)
+	return engine
+}
+
+func TestMiddleware(t *testing.T) {
+	k1, k2 := testRsaKey(t, 0), testRsaKey(t, 1)
+	server := newJwksServer(t, map[string]*rsa.PrivateKey{"k1": k1})
+	verifier := &testKeyVerifier{}
+	config := Config{
+		HmacSecret:   testHmacSecret,
+		JwksSource:   server.URL,
+		Issuer:       "https://issuer.example",
+		Audience:     "pn-registry",
+		Leeway:       30 * time.Second,
+		PublicRoutes: []string{"/api/info", "/openapi/*"},
+		RolesClaim:   "realm_access.roles",
+		ApiKeys:      verifier,
+	}
+	engine := newTestAuthEngine(t, config)
+	config.ApiKeys = nil
+	withoutKeys := newTestAuthEngine(t, config)
+
+	claims := func(change func(claims map[string]interface{})) map[string]interface{} {
+		claims := testClaims()
+		claims["realm_access"] = map[string]interface{}{"roles": []interface{}{"doctor", "admin"}}
+		if change != nil {
+			change(claims)
+		}
+		return claims
+	}
+	hs256 := map[string]interface{}{"alg": AlgorithmHS256}
+	rs256 := func(kid string) map[string]interface{} {
+		return map[string]interface{}{"alg": AlgorithmRS256, "kid": kid}
+	}
+	bearer := func(token string) map[string]string { return map[string]string{"Authorization": "Bearer " + token} }
+	valid := signHS256(testHmacSecret, hs256, claims(nil))
+
+	tests := []struct {
+		name    string
+		engine  *gin.Engine
+		path    string
+		headers map[string]string
+		status  int
+		message string
+	}{
+		{"HS256 token", engine, "/api/records/", bearer(valid), http.StatusOK, "doctor,admin"},
+		{"RS256 token", engine, "/api/records/", bearer(signRS256(t, k1, rs256("k1"), claims(nil))), http.StatusOK, "doctor,admin"},
+		{"token with spaces", engine, "/api/records/", map[string]string{"Authorization": "Bearer  " + valid + " "}, http.StatusOK, "user-1"},
+		{"public route", engine, "/api/info", nil, http.StatusOK, ""},
+		{"public prefix", engine, "/openapi/spec.yaml", nil, http.StatusOK, ""},
+		{"missing bearer token", engine, "/api/records/", nil, http.StatusUnauthorized, "Missing bearer token"},
+		{"empty bearer token", engine, "/api/records/", bearer(" "), http.StatusUnauthorized, "Missing bearer token"},
+		{"basic authorization", engine, "/api/records/", map[string]string{"Authorization": "Basic dXNlcjpwYXNz"}, http.StatusUnauthorized, "Missing bearer token"},
+		{"lowercase bearer", engine, "/api/records/", map[string]string{"Authorization": "bearer " + valid}, http.StatusUnauthorized, "Missing bearer token"},
+		{"alg none", engine, "/api/records/", bearer(unsignedToken(map[string]interface{}{"alg": "none"}, claims(nil)) + "."), http.StatusUnauthorized, "algorithm none is not supported"},
+		{"HS256 signed by RSA public key", engine, "/api/records/", bearer(signHS256(k1.N.Bytes(), hs256, claims(nil))), http.StatusUnauthorized, "signature is invalid"},
+		{"RS256 signed by other key", engine, "/api/records/", bearer(signRS256(t, k2, rs256("k1"), claims(nil))), http.StatusUnauthorized, "signature is invalid"},
+		{"unknown key ID", engine, "/api/records/", bearer(signRS256(t, k2, rs256("k2"), claims(nil))), http.StatusUnauthorized, "key k2 is not known"},
+		{"malformed token", engine, "/api/records/", bearer("not-a-token"), http.StatusUnauthorized, "token is malformed"},
+		{"expired", engine, "/api/records/", bearer(signHS256(testHmacSecret, hs256, claims(func(claims map[string]interface{}) {
+			claims["exp"] = float64(time.Now().Add(-time.Minute).Unix())
+		}))), http.StatusUnauthorized, "token is expired"},
+		{"expired within leeway", engine, "/api/records/", bearer(signHS256(testHmacSecret, hs256, claims(func(claims map[string]interface{}) {
+			claims["exp"] = float64(time.Now().Add(-10 * time.Second).Unix())
+		}))), http.StatusOK, "user-1"},
+		{"not valid yet", engine, "/api/records/", bearer(signHS256(testHmacSecret, hs256, claims(func(claims map[string]interface{}) {
+			claims["nbf"] = float64(time.Now().Add(time.Minute).Unix())
+		}))), http.StatusUnauthorized, "token is not valid yet"},
+		{"wrong issuer", engine, "/api/records/", bearer(signHS256(testHmacSecret, hs256, claims(func(claims map[string]interface{}) {
+			claims["iss"] = "https://other.example"
+		}))), http.StatusUnauthorized, "invalid issuer"},
+		{"wrong audience", engine, "/api/records/", bearer(signHS256(testHmacSecret, hs256, claims(func(claims map[string]interface{}) {
+			claims["aud"] = []interface{}{"account"}
+		}))), http.StatusUnauthorized, "invalid audience"},
+		{"missing subject", engine, "/api/records/", bearer(signHS256(testHmacSecret, hs256, claims(func(claims map[string]interface{}) {
+			delete(claims, "sub")
+		}))), http.StatusUnauthorized, "token has no subject"},
+		{"API key", engine, "/api/records/", map[string]string{ApiKeyHeader: "pn_valid"}, http.StatusOK, "client-1"},
+		{"invalid API key", engine, "/api/records/", map[string]string{ApiKeyHeader: "pn_invalid"}, http.StatusUnauthorized, "Invalid API key"},
+		{"invalid API key with bearer token", engine, "/api/records/", map[string]string{ApiKeyHeader: "pn_invalid", "Authorization": "Bearer " + valid}, http.StatusUnauthorized, "Invalid API key"},
+		{"API key not accepted", withoutKeys, "/api/records/", map[string]string{ApiKeyHeader: "pn_valid"}, http.StatusUnauthorized, "Missing bearer token"},
+		{"API key not accepted with bearer token", withoutKeys, "/api/records/", map[string]string{ApiKeyHeader: "pn_valid", "Authorization": "Bearer " + valid}, http.StatusOK, "user-1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, test.path, nil)
+			for name, value := range test.headers {
+				request.Header.Set(name, value)
+			}
+			recorder := httptest.NewRecorder()
+			test.engine.ServeHTTP(recorder, request)
+
+			if recorder.Code != test.status {
+				t.Fatalf("Expected status %v, got %v: %s", test.status, recorder.Code, recorder.Body.String())
+			}
+			if !strings.Contains(recorder.Body.String(), test.message) {
+				t.Errorf("Expected response containing %q, got %s", test.message, recorder.Body.String())
+			}
+			// rejected bearer token is challenged, rejected API key is not
+			if test.status == http.StatusUnauthorized && (test.engine == withoutKeys || test.headers[ApiKeyHeader] == "") {
+				if challenge := recorder.Header().Get("WWW-Authenticate"); !strings.HasPrefix(challenge, `Bearer realm="pn-registry"`) {
+					t.Errorf("Expected bearer challenge, got %q", challenge)
+				}
+			}
+		})
+	}
+
+	// only requests authenticated by API key are recorded with their status
+	if len(verifier.usage) != 1 || verifier.usage[0] != http.StatusOK {
+		t.Errorf("Expected single recorded usage of API key, got %v", verifier.usage)
+	}
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	server := newJwksServer(t, map[string]*rsa.PrivateKey{"k1": testRsaKey(t, 0)})
+	failing := newJwksServer(t, nil)
+	failing.fail(true)
+	tests := []struct {
+		name   string
+		config Config
+		err    string
+	}{
+		{"HMAC secret", Config{HmacSecret: testHmacSecret}, ""},
+		{"JWKS", Config{JwksSource: server.URL}, ""},
+		{"without keys", Config{}, "must be configured"},
+		{"unavailable JWKS", Config{JwksSource: failing.URL, HmacSecret: testHmacSecret}, "failed to load JWKS"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewAuthenticator(context.Background(), test.config)
+			expectError(t, err, test.err)
+		})
+	}
+
+	// algorithm of the token must be accepted by configuration
+	hmacOnly, _ := NewAuthenticator(context.Background(), Config{HmacSecret: testHmacSecret})
+	_, err := hmacOnly.Authenticate(context.Background(), signRS256(t, testRsaKey(t, 0), map[string]interface{}{"alg": AlgorithmRS256, "kid": "k1"}, testClaims()))
+	expectError(t, err, "RS256 are not accepted")
+	jwksOnly, _ := NewAuthenticator(context.Background(), Config{JwksSource: server.URL})
+	_, err = jwksOnly.Authenticate(context.Background(), signHS256(testHmacSecret, map[string]interface{}{"alg": AlgorithmHS256}, testClaims()))
+	expectError(t, err, "HS256 are not accepted")
+}
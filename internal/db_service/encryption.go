@@ -0,0 +1,420 @@
+package db_service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// top level field of stored document with blind indexes of encrypted fields
+const blindIndexField = "_blindIndex"
+
+// reported when encrypted field is used in filter or sort which needs its plain value,
+// encrypted fields with blind index can only be compared for equality
+var ErrEncryptedField = errors.New("encrypted field can not be queried")
+
+// Keys of field encryption, keys are base64 encoded 256-bit keys. New values are encrypted
+// by key with KeyId, other keys are kept to decrypt values encrypted before rotation of the key.
+type EncryptionKeys struct {
+	KeyId    string            `json:"keyId"`
+	Keys     map[string]string `json:"keys"`
+	IndexKey string            `json:"indexKey"` // key of blind indexes, it can not be rotated without rebuilding the indexes
+}
+
+// FieldEncryption encrypts values of sensitive fields before documents are stored and decrypts them
+// when documents are loaded (envelope encryption). Every value is encrypted by AES-GCM with its own
+// random data key, which is stored with the value encrypted by key encryption key identified by key ID.
+// Indexed fields have deterministic blind index (HMAC-SHA256 of the value), so that they can be
+// found by equality filters. Values stored before encryption was enabled are loaded as they are.
+type FieldEncryption struct {
+	fields   []string               // dotted paths of encrypted fields, paths descend into arrays of documents
+	indexed  []string               // top level encrypted fields with blind index
+	keys     map[string]cipher.AEAD // key encryption keys by key ID
+	keyId    string
+	indexKey []byte
+}
+
+// Loads keys of field encryption from JSON file
+func LoadEncryptionKeyFile(path string) (EncryptionKeys, error) {
+	keys := EncryptionKeys{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return keys, err
+	}
+	err = json.Unmarshal(data, &keys)
+	return keys, err
+}
+
+// creates encryption of fields, indexed fields must be top level fields which are encrypted
+func NewFieldEncryption(keys EncryptionKeys, fields []string, indexed []string) (*FieldEncryption, error) {
+	encryption := &FieldEncryption{
+		fields:  fields,
+		indexed: indexed,
+		keys:    map[string]cipher.AEAD{},
+		keyId:   keys.KeyId,
+	}
+
+	for keyId, encoded := range keys.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("encryption key %v must be base64 encoded 256-bit key", keyId)
+		}
+		if encryption.keys[keyId], err = newAead(key); err != nil {
+			return nil, err
+		}
+	}
+	if encryption.keyId == "" && len(keys.Keys) == 1 {
+		for keyId := range keys.Keys {
+			encryption.keyId = keyId
+		}
+	}
+	if _, exists := encryption.keys[encryption.keyId]; !exists {
+		return nil, fmt.Errorf("encryption key %v used to encrypt new values is not configured", encryption.keyId)
+	}
+
+	for _, field := range indexed {
+		if strings.Contains(field, ".") || !slices.Contains(fields, field) {
+			return nil, fmt.Errorf("blind index can be built only on top level encrypted field, not on %v", field)
+		}
+	}
+	if len(indexed) != 0 {
+		indexKey, err := base64.StdEncoding.DecodeString(keys.IndexKey)
+		if err != nil || len(indexKey) < 32 {
+			return nil, errors.New("blind index key must be base64 encoded key of at least 256 bits")
+		}
+		encryption.indexKey = indexKey
+	}
+	return encryption, nil
+}
+
+// serializes document and encrypts its fields, nil encryption only serializes the document
+func (this *FieldEncryption) marshal(document interface{}) (bson.Raw, error) {
+	raw, err := bson.Marshal(document)
+	if err != nil || this == nil {
+		return raw, err
+	}
+
+	var fields bson.D
+	if err := bson.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	fields = slices.DeleteFunc(fields, func(field bson.E) bool { return field.Key == blindIndexField })
+
+	// indexes are computed from plain values
+	index := bson.D{}
+	for _, field := range this.indexed {
+		for _, element := range fields {
+			if element.Key != field || element.Value == nil {
+				continue
+			}
+			value, err := this.blindIndex(field, element.Value)
+			if err != nil {
+				return nil, err
+			}
+			index = append(index, bson.E{Key: field, Value: value})
+		}
+	}
+
+	for _, field := range this.fields {
+		encrypted, err := transformPath(fields, strings.Split(field, "."), func(value interface{}) (interface{}, error) {
+			return this.encryptValue(field, value)
+		})
+		if err != nil {
+			return nil, err
+		}
+		fields = encrypted.(bson.D)
+	}
+
+	if len(index) != 0 {
+		fields = append(fields, bson.E{Key: blindIndexField, Value: index})
+	}
+	return bson.Marshal(fields)
+}
+
+// decrypts fields of stored document and deserializes it, nil encryption only deserializes the document
+func (this *FieldEncryption) unmarshal(raw bson.Raw, document interface{}) error {
+	if this == nil {
+		return bson.Unmarshal(raw, document)
+	}
+
+	var fields bson.D
+	if err := bson.Unmarshal(raw, &fields); err != nil {
+		return err
+	}
+	fields = slices.DeleteFunc(fields, func(field bson.E) bool { return field.Key == blindIndexField })
+
+	for _, field := range this.fields {
+		decrypted, err := transformPath(fields, strings.Split(field, "."), func(value interface{}) (interface{}, error) {
+			return this.decryptValue(field, value)
+		})
+		if err != nil {
+			return err
+		}
+		fields = decrypted.(bson.D)
+	}
+
+	raw, err := bson.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(raw, document)
+}
+
+// translates conditions on indexed fields into conditions on their blind indexes,
+// conditions which need plain value of encrypted field are rejected
+func (this *FieldEncryption) filter(f Filter) (Filter, error) {
+	if this == nil {
+		return f, nil
+	}
+	return this.translate(f, "")
+}
+
+func (this *FieldEncryption) translate(f Filter, prefix string) (Filter, error) {
+	switch f.operator {
+	case "":
+		return f, nil
+	case opAnd, opOr, opNor, opElemMatch:
+		if f.operator == opElemMatch {
+			if this.covers(prefix + f.field) {
+				return f, fmt.Errorf("%w: %v", ErrEncryptedField, prefix+f.field)
+			}
+			prefix = prefix + f.field + "."
+		}
+		translated := f
+		translated.filters = make([]Filter, len(f.filters))
+		for i, filter := range f.filters {
+			var err error
+			if translated.filters[i], err = this.translate(filter, prefix); err != nil {
+				return f, err
+			}
+		}
+		return translated, nil
+	}
+
+	path := prefix + f.field
+	switch {
+	case !this.covers(path), f.operator == opExists:
+		// presence of encrypted field is kept
+		return f, nil
+	case !slices.Contains(this.indexed, path):
+		return f, fmt.Errorf("%w: %v", ErrEncryptedField, path)
+	}
+
+	translated := Filter{operator: f.operator, field: blindIndexField + "." + path}
+	var err error
+	switch f.operator {
+	case opEq, opNe:
+		translated.value, err = this.blindIndex(path, f.value)
+	case opIn, opNin:
+		translated.values = make([]interface{}, len(f.values))
+		for i, value := range f.values {
+			if translated.values[i], err = this.blindIndex(path, value); err != nil {
+				break
+			}
+		}
+	default:
+		return f, fmt.Errorf("%w: %v can be compared only for equality", ErrEncryptedField, path)
+	}
+	return translated, err
+}
+
+// rejects sorting by encrypted fields, order of encrypted values is meaningless
+func (this *FieldEncryption) checkSort(sort []SortField) error {
+	if this == nil {
+		return nil
+	}
+	for _, field := range sort {
+		if this.covers(field.Field) {
+			return fmt.Errorf("%w: documents can not be sorted by %v", ErrEncryptedField, field.Field)
+		}
+	}
+	return nil
+}
+
+// fields of blind indexes, which should be indexed by database
+func (this *FieldEncryption) indexFields() []string {
+	if this == nil {
+		return nil
+	}
+	fields := []string{}
+	for _, field := range this.indexed {
+		fields = append(fields, blindIndexField+"."+field)
+	}
+	return fields
+}
+
+// field of blind index of the encrypted field, false when the field has no blind index
+func (this *FieldEncryption) indexField(field string) (string, bool) {
+	if this == nil || !slices.Contains(this.indexed, field) {
+		return "", false
+	}
+	return blindIndexField + "." + field, true
+}
+
+// whether the path is encrypted field or field nested in it, nil encryption encrypts no field
+func (this *FieldEncryption) Covers(path string) bool {
+	return this != nil && this.covers(path)
+}
+
+func (this *FieldEncryption) covers(path string) bool {
+	for _, field := range this.fields {
+		if path == field || strings.HasPrefix(path, field+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// encrypts value by new data key which is encrypted by current key encryption key,
+// both are bound to the field, so that encrypted value can not be moved to other field
+func (this *FieldEncryption) encryptValue(field string, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	valueType, data, err := bson.MarshalValue(value)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	dataAead, err := newAead(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return bson.D{
+		{Key: "kid", Value: this.keyId},
+		{Key: "dek", Value: primitive.Binary{Data: seal(this.keys[this.keyId], dataKey, field)}},
+		{Key: "data", Value: primitive.Binary{Data: seal(dataAead, append([]byte{byte(valueType)}, data...), field)}},
+	}, nil
+}
+
+// decrypts value encrypted by encryptValue, other values are returned as they are
+func (this *FieldEncryption) decryptValue(field string, value interface{}) (interface{}, error) {
+	envelope, ok := value.(bson.D)
+	if !ok {
+		return value, nil
+	}
+	if len(envelope) != 3 || envelope[0].Key != "kid" || envelope[1].Key != "dek" || envelope[2].Key != "data" {
+		return value, nil
+	}
+	keyId, hasKeyId := envelope[0].Value.(string)
+	dataKey, hasDataKey := envelope[1].Value.(primitive.Binary)
+	data, hasData := envelope[2].Value.(primitive.Binary)
+	if !hasKeyId || !hasDataKey || !hasData {
+		return value, nil
+	}
+
+	keyAead, exists := this.keys[keyId]
+	if !exists {
+		return nil, fmt.Errorf("field %v is encrypted by unknown key %v", field, keyId)
+	}
+	plainKey, err := open(keyAead, dataKey.Data, field)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data key of field %v: %w", field, err)
+	}
+	dataAead, err := newAead(plainKey)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := open(dataAead, data.Data, field)
+	if err != nil || len(plain) == 0 {
+		return nil, fmt.Errorf("failed to decrypt field %v", field)
+	}
+	return bson.RawValue{Type: bsontype.Type(plain[0]), Value: plain[1:]}, nil
+}
+
+// deterministic keyed hash of the value, the same value of the field has always the same index
+func (this *FieldEncryption) blindIndex(field string, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	valueType, data, err := bson.MarshalValue(value)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, this.indexKey)
+	mac.Write([]byte(field))
+	mac.Write([]byte{0, byte(valueType)})
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Utility function which replaces values at the dotted path, arrays of documents are traversed
+// in the same way as mongo does, missing fields are skipped
+func transformPath(value interface{}, path []string, transform func(value interface{}) (interface{}, error)) (interface{}, error) {
+	if len(path) == 0 {
+		return transform(value)
+	}
+
+	switch container := value.(type) {
+	case bson.D:
+		for i, element := range container {
+			if element.Key != path[0] {
+				continue
+			}
+			transformed, err := transformPath(element.Value, path[1:], transform)
+			if err != nil {
+				return nil, err
+			}
+			container[i].Value = transformed
+		}
+		return container, nil
+	case bson.A:
+		for i, element := range container {
+			if _, isDocument := element.(bson.D); !isDocument {
+				continue
+			}
+			transformed, err := transformPath(element, path, transform)
+			if err != nil {
+				return nil, err
+			}
+			container[i] = transformed
+		}
+		return container, nil
+	}
+	return value, nil
+}
+
+func newAead(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypts plain text with random nonce, nonce is prepended to cipher text
+func seal(aead cipher.AEAD, plain []byte, field string) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	return aead.Seal(nonce, nonce, plain, []byte(field))
+}
+
+func open(aead cipher.AEAD, sealed []byte, field string) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("cipher text is too short")
+	}
+	nonce, text := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, text, []byte(field))
+}
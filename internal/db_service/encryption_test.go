@@ -0,0 +1,105 @@
+package db_service
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type testPerson struct {
+	Id       string             `bson:"id"`
+	FullName string             `bson:"fullName"`
+	Contact  *testPersonContact `bson:"contact,omitempty"`
+	Version  int                `bson:"version"`
+}
+
+type testPersonContact struct {
+	Phone string `bson:"phone"`
+}
+
+func newTestEncryption(t *testing.T, fields []string, indexed []string) *FieldEncryption {
+	t.Helper()
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+	encryption, err := NewFieldEncryption(EncryptionKeys{Keys: map[string]string{"k1": key}, IndexKey: key}, fields, indexed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encryption
+}
+
+func TestEncryptedId(t *testing.T) {
+	ctx := context.Background()
+	encryption := newTestEncryption(t, []string{"id", "fullName", "contact"}, []string{"id"})
+	svc := NewMemoryService[testPerson](MemoryServiceConfig{Encryption: encryption}).(*memorySvc[testPerson])
+
+	person := testPerson{Id: "8001011234", FullName: "Jozef Mrkvicka", Contact: &testPersonContact{Phone: "+421900123456"}, Version: 1}
+	if err := svc.CreateDocument(ctx, person.Id, &person); err != nil {
+		t.Fatal(err)
+	}
+	for _, plain := range []string{person.Id, person.FullName, person.Contact.Phone} {
+		if bytes.Contains(svc.documents[person.Id], []byte(plain)) {
+			t.Errorf("Expected %q to be encrypted in stored document", plain)
+		}
+	}
+
+	found, err := svc.FindDocument(ctx, person.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Id != person.Id || found.FullName != person.FullName || found.Contact == nil || found.Contact.Phone != person.Contact.Phone {
+		t.Errorf("Expected decrypted %+v, got %+v", person, found)
+	}
+
+	// conditional writes find the document by blind index of its id
+	person.Version = 2
+	if err := svc.UpdateDocumentIf(ctx, person.Id, Eq("version", 1), &person); err != nil {
+		t.Errorf("Expected update of document with encrypted id, got %v", err)
+	}
+	if _, err := svc.FindDocuments(ctx, "id", person.Id); err != nil {
+		t.Errorf("Expected documents found by encrypted id, got %v", err)
+	}
+	if _, _, err := svc.QueryDocuments(ctx, Query{Sort: []SortField{{Field: "fullName"}}}); !errors.Is(err, ErrEncryptedField) {
+		t.Errorf("Expected sort by encrypted field to be rejected, got %v", err)
+	}
+}
+
+func TestMongoEncryptedId(t *testing.T) {
+	encryption := newTestEncryption(t, []string{"id", "fullName"}, []string{"id"})
+	svc := &mongoSvc[testPerson]{MongoServiceConfig: MongoServiceConfig{Encryption: encryption}}
+
+	// documents with encrypted id are looked up by its blind index
+	idField, indexed := encryption.indexField("id")
+	if !indexed || idField != "_blindIndex.id" {
+		t.Fatalf("Expected blind index of id, got %q", idField)
+	}
+	idFilter, err := encryption.filter(Eq("id", "d1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter := idFilter.toBson(); len(filter) != 1 || filter[0].Key != idField {
+		t.Errorf("Expected filter by blind index of id, got %v", filter)
+	}
+
+	raw, err := encryption.marshal(testPerson{Id: "d1", FullName: "Jozef Mrkvicka", Version: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	condition, err := encryption.filter(And(Eq("id", "d1"), Eq("version", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.checkCurrent([]bson.Raw{raw}, "d1", condition); err != nil {
+		t.Errorf("Expected document found by encrypted id, got %v", err)
+	}
+	if err := svc.checkCurrent([]bson.Raw{raw}, "d2", condition); err != ErrNotFound {
+		t.Errorf("Expected other id not found, got %v", err)
+	}
+
+	if _, indexed := (*FieldEncryption)(nil).indexField("id"); indexed {
+		t.Errorf("Expected no blind index without encryption")
+	}
+}
@@ -0,0 +1,35 @@
+package db_service
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// mongo service using collection in separate database of the test which is dropped after the test,
+// the test is skipped unless PN_REGISTRY_API_TEST_MONGODB_HOST is set to host of mongo server, other settings
+// of the connection are taken from the same environment variables as in the service (PN_REGISTRY_API_MONGODB_*)
+func newTestMongoService[DocType interface{}](t *testing.T, config MongoServiceConfig) *mongoSvc[DocType] {
+	t.Helper()
+	host := os.Getenv("PN_REGISTRY_API_TEST_MONGODB_HOST")
+	if host == "" {
+		t.Skip("PN_REGISTRY_API_TEST_MONGODB_HOST is not set")
+	}
+
+	config.ServerHost = host
+	config.DbName = "pn-registry-test-" + uuid.NewString()
+	config.Collection = "test"
+	svc := NewMongoService[DocType](config).(*mongoSvc[DocType])
+	t.Cleanup(func() {
+		ctx := context.Background()
+		if client, err := svc.connect(ctx); err == nil {
+			if err := client.Database(svc.DbName).Drop(ctx); err != nil {
+				t.Errorf("Failed to drop test database %v: %v", svc.DbName, err)
+			}
+		}
+		svc.Disconnect(ctx)
+	})
+	return svc
+}
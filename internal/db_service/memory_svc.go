@@ -0,0 +1,317 @@
+package db_service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type MemoryServiceConfig struct {
+	Encryption *FieldEncryption // encryption of sensitive fields, nil when documents are stored as they are
+}
+
+// in-memory implementation of DbService, documents are kept as BSON so that
+// stored values behave the same way as when they are round-tripped through MongoDB
+type memorySvc[DocType interface{}] struct {
+	MemoryServiceConfig
+	documents map[string]bson.Raw
+	order     []string // ids in insertion order, mimics natural order of mongo collection
+	lock      sync.RWMutex
+}
+
+func NewMemoryService[DocType interface{}](config MemoryServiceConfig) DbService[DocType] {
+	log.Printf("Using in-memory database service, data will not be persisted")
+	return &memorySvc[DocType]{
+		MemoryServiceConfig: config,
+		documents:           map[string]bson.Raw{},
+	}
+}
+
+func (this *memorySvc[DocType]) Disconnect(ctx context.Context) error {
+	return nil
+}
+
+// saves document in memory
+func (this *memorySvc[DocType]) CreateDocument(ctx context.Context, id string, document *DocType) error {
+	raw, err := this.Encryption.marshal(document)
+	if err != nil {
+		return err
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	return this.write(WriteCreate, id, Filter{}, raw)
+}
+
+// finds document in memory
+func (this *memorySvc[DocType]) FindDocument(ctx context.Context, id string) (*DocType, error) {
+	this.lock.RLock()
+	raw, exists := this.documents[id]
+	this.lock.RUnlock()
+
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	var document DocType
+	if err := this.Encryption.unmarshal(raw, &document); err != nil {
+		return nil, err
+	}
+
+	return &document, nil
+}
+
+// finds all documents or documents where specific field equals to value
+func (this *memorySvc[DocType]) FindDocuments(ctx context.Context, field string, value interface{}) ([]DocType, error) {
+	var filter Filter
+	if field != "" && value != nil {
+		filter = Eq(field, value)
+	}
+
+	results, _, err := this.QueryDocuments(ctx, Query{Filter: filter})
+	return results, err
+}
+
+// finds documents matching the query, returns page of documents and total count of matching documents
+func (this *memorySvc[DocType]) QueryDocuments(ctx context.Context, query Query) ([]DocType, int64, error) {
+	page, total, err := this.matchDocuments(query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var results []DocType
+	for _, raw := range page {
+		document, err := decodeDocument[DocType](raw, query.Projection, this.Encryption)
+		if err != nil {
+			return nil, 0, err
+		}
+		results = append(results, *document)
+	}
+
+	return results, total, nil
+}
+
+// passes documents matching the query one by one to consume function, iteration stops on first error of consume
+func (this *memorySvc[DocType]) StreamDocuments(ctx context.Context, query Query, consume func(document *DocType) error) error {
+	page, _, err := this.matchDocuments(query)
+	if err != nil {
+		return err
+	}
+
+	for _, raw := range page {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		document, err := decodeDocument[DocType](raw, query.Projection, this.Encryption)
+		if err != nil {
+			return err
+		}
+		if err := consume(document); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finds stored documents matching the query, returns requested page of them and total count of matching documents
+func (this *memorySvc[DocType]) matchDocuments(query Query) ([]bson.Raw, int64, error) {
+	filter, err := this.Encryption.filter(query.Filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := this.Encryption.checkSort(query.Sort); err != nil {
+		return nil, 0, err
+	}
+
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	var matched []bson.Raw
+	for _, id := range this.order {
+		raw := this.documents[id]
+		ok, err := filter.matches(raw)
+		if err != nil {
+			return nil, 0, err
+		}
+		if ok {
+			matched = append(matched, raw)
+		}
+	}
+
+	if len(query.Sort) != 0 {
+		slices.SortStableFunc(matched, func(a, b bson.Raw) int {
+			return compareBySort(a, b, query.Sort)
+		})
+	}
+
+	total := int64(len(matched))
+	if query.Offset > 0 {
+		matched = matched[min(query.Offset, total):]
+	}
+	if query.Limit > 0 && int64(len(matched)) > query.Limit {
+		matched = matched[:query.Limit]
+	}
+
+	return matched, total, nil
+}
+
+func decodeDocument[DocType interface{}](raw bson.Raw, projection []string, encryption *FieldEncryption) (*DocType, error) {
+	raw, err := projectDocument(raw, projection)
+	if err != nil {
+		return nil, err
+	}
+
+	var document DocType
+	if err := encryption.unmarshal(raw, &document); err != nil {
+		return nil, err
+	}
+	return &document, nil
+}
+
+// updates document in memory
+func (this *memorySvc[DocType]) UpdateDocument(ctx context.Context, id string, document *DocType) error {
+	raw, err := this.Encryption.marshal(document)
+	if err != nil {
+		return err
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if _, exists := this.documents[id]; !exists {
+		return ErrNotFound
+	}
+
+	this.documents[id] = raw
+	return nil
+}
+
+// updates document in memory only if it matches the condition
+func (this *memorySvc[DocType]) UpdateDocumentIf(ctx context.Context, id string, condition Filter, document *DocType) error {
+	raw, err := this.Encryption.marshal(document)
+	if err != nil {
+		return err
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	return this.write(WriteUpdate, id, condition, raw)
+}
+
+// deletes document from memory
+func (this *memorySvc[DocType]) DeleteDocument(ctx context.Context, id string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if _, exists := this.documents[id]; !exists {
+		return ErrNotFound
+	}
+
+	delete(this.documents, id)
+	this.order = slices.DeleteFunc(this.order, func(existing string) bool {
+		return existing == id
+	})
+	return nil
+}
+
+// deletes document from memory only if it matches the condition
+func (this *memorySvc[DocType]) DeleteDocumentIf(ctx context.Context, id string, condition Filter) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	return this.write(WriteDelete, id, condition, nil)
+}
+
+// applies operations in their order and returns result of each of them, operations are applied under lock
+// so other requests see all of them at once, in atomic mode stored documents are restored on first failure
+func (this *memorySvc[DocType]) BulkWrite(ctx context.Context, operations []WriteOperation[DocType], atomic bool) ([]error, error) {
+	raws := make([]bson.Raw, len(operations))
+	for i, operation := range operations {
+		if operation.Document == nil {
+			continue
+		}
+		raw, err := this.Encryption.marshal(operation.Document)
+		if err != nil {
+			return nil, err
+		}
+		raws[i] = raw
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	var documents map[string]bson.Raw
+	var order []string
+	if atomic {
+		documents = maps.Clone(this.documents)
+		order = slices.Clone(this.order)
+	}
+
+	results := make([]error, len(operations))
+	for i, operation := range operations {
+		results[i] = this.write(operation.Kind, operation.Id, operation.Condition, raws[i])
+		if results[i] != nil && atomic {
+			this.documents = documents
+			this.order = order
+			markRolledBack(results, i)
+			break
+		}
+	}
+	return results, nil
+}
+
+// applies single write operation, lock must be held by caller
+func (this *memorySvc[DocType]) write(kind string, id string, condition Filter, raw bson.Raw) error {
+	switch kind {
+	case WriteCreate:
+		if _, exists := this.documents[id]; exists {
+			return ErrConflict
+		}
+		this.documents[id] = raw
+		this.order = append(this.order, id)
+	case WriteUpdate:
+		if err := this.checkCondition(id, condition); err != nil {
+			return err
+		}
+		this.documents[id] = raw
+	case WriteDelete:
+		if err := this.checkCondition(id, condition); err != nil {
+			return err
+		}
+		delete(this.documents, id)
+		this.order = slices.DeleteFunc(this.order, func(existing string) bool {
+			return existing == id
+		})
+	default:
+		return fmt.Errorf("unknown kind of write operation: %v", kind)
+	}
+	return nil
+}
+
+// checks that document exists and matches the condition, lock must be held by caller
+func (this *memorySvc[DocType]) checkCondition(id string, condition Filter) error {
+	raw, exists := this.documents[id]
+	if !exists {
+		return ErrNotFound
+	}
+
+	condition, err := this.Encryption.filter(condition)
+	if err != nil {
+		return err
+	}
+	matched, err := condition.matches(raw)
+	if err != nil {
+		return err
+	}
+	if !matched {
+		return ErrConditionFailed
+	}
+	return nil
+}
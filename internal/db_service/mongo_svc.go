@@ -1,10 +1,14 @@
 package db_service
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
+	"slices"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -19,28 +23,51 @@ type DbService[DocType interface{}] interface {
 	CreateDocument(ctx context.Context, id string, document *DocType) error
 	FindDocument(ctx context.Context, id string) (*DocType, error)
 	FindDocuments(ctx context.Context, field string, value interface{}) ([]DocType, error)
+	QueryDocuments(ctx context.Context, query Query) ([]DocType, int64, error)
+	StreamDocuments(ctx context.Context, query Query, consume func(document *DocType) error) error
 	UpdateDocument(ctx context.Context, id string, document *DocType) error
+	UpdateDocumentIf(ctx context.Context, id string, condition Filter, document *DocType) error
 	DeleteDocument(ctx context.Context, id string) error
+	DeleteDocumentIf(ctx context.Context, id string, condition Filter) error
+	BulkWrite(ctx context.Context, operations []WriteOperation[DocType], atomic bool) ([]error, error)
 	Disconnect(ctx context.Context) error
 }
 
 var ErrNotFound = fmt.Errorf("document not found")
 var ErrConflict = fmt.Errorf("conflict: document already exists")
+var ErrConditionFailed = fmt.Errorf("condition failed: document was modified")
+
+// Connects the service to its database, so that failures of the database, e.g. unique index which cannot be
+// created, are found at startup instead of by the first request. Services are otherwise connected lazily.
+func Connect[DocType interface{}](ctx context.Context, service DbService[DocType]) error {
+	svc, ok := service.(*mongoSvc[DocType])
+	if !ok {
+		return nil
+	}
+	ctx, contextCancel := context.WithTimeout(ctx, svc.Timeout)
+	defer contextCancel()
+	_, err := svc.connect(ctx)
+	return err
+}
 
 type MongoServiceConfig struct {
 	ServerHost string
 	ServerPort int
 	UserName   string
 	Password   string
+	ReplicaSet string // name of replica set, transactions of atomic bulk writes are not supported by standalone server
 	DbName     string
 	Collection string
+	Tenant     string // tenant has separate database named with suffix of tenant ID
 	Timeout    time.Duration
+	Encryption *FieldEncryption // encryption of sensitive fields, nil when documents are stored as they are
 }
 
 type mongoSvc[DocType interface{}] struct {
 	MongoServiceConfig
-	client     atomic.Pointer[mongo.Client]
-	clientLock sync.Mutex
+	client       atomic.Pointer[mongo.Client]
+	clientLock   sync.Mutex
+	transactions atomic.Bool // set on connect when the database supports transactions
 }
 
 func NewMongoService[DocType interface{}](config MongoServiceConfig) DbService[DocType] {
@@ -76,10 +103,18 @@ func NewMongoService[DocType interface{}](config MongoServiceConfig) DbService[D
 		svc.Password = enviro("PN_REGISTRY_API_MONGODB_PASSWORD", "")
 	}
 
+	if svc.ReplicaSet == "" {
+		svc.ReplicaSet = enviro("PN_REGISTRY_API_MONGODB_REPLICA_SET", "")
+	}
+
 	if svc.DbName == "" {
 		svc.DbName = enviro("PN_REGISTRY_API_MONGODB_DATABASE", "pn-registry")
 	}
 
+	if svc.Tenant != "" {
+		svc.DbName = svc.DbName + "-" + svc.Tenant
+	}
+
 	if svc.Collection == "" {
 		svc.Collection = enviro("PN_REGISTRY_API_MONGODB_COLLECTION", "record")
 	}
@@ -130,14 +165,77 @@ func (this *mongoSvc[DocType]) connect(ctx context.Context) (*mongo.Client, erro
 		uri = fmt.Sprintf("mongodb://%v:%v@%v:%v", this.UserName, this.Password, this.ServerHost, this.ServerPort)
 	}
 
+	if len(this.ReplicaSet) != 0 {
+		uri += "/?replicaSet=" + url.QueryEscape(this.ReplicaSet)
+	}
+
 	if client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri).SetConnectTimeout(10*time.Second)); err != nil {
 		return nil, err
 	} else {
+		// without unique index conflicting documents could be created, so the service cannot be used
+		if err := this.ensureIndexes(ctx, client); err != nil {
+			client.Disconnect(ctx)
+			return nil, err
+		}
+		transactions, err := supportsTransactions(ctx, client)
+		if err != nil {
+			client.Disconnect(ctx)
+			return nil, err
+		}
+		if !transactions {
+			log.Printf("MongoDB is standalone server without transactions, atomic bulk writes are not available")
+		}
+		this.transactions.Store(transactions)
 		this.client.Store(client)
 		return client, nil
 	}
 }
 
+// unique index on id makes creation of documents atomic - conflicting inserts are rejected by database,
+// encrypted id is unique by its blind index (documents stored before encryption was enabled have none)
+func (this *mongoSvc[DocType]) ensureIndexes(ctx context.Context, client *mongo.Client) error {
+	collection := client.Database(this.DbName).Collection(this.Collection)
+	idIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	idField, idEncrypted := this.Encryption.indexField("id")
+	if idEncrypted {
+		idIndex = mongo.IndexModel{
+			Keys:    bson.D{{Key: idField, Value: 1}},
+			Options: options.Index().SetUnique(true).SetSparse(true),
+		}
+	}
+	_, err := collection.Indexes().CreateOne(ctx, idIndex)
+	if err != nil {
+		return fmt.Errorf("failed to create unique index on id in collection %v: %w", this.Collection, err)
+	}
+
+	// blind indexes replace encrypted fields in queries
+	for _, field := range this.Encryption.indexFields() {
+		if idEncrypted && field == idField {
+			continue
+		}
+		_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: field, Value: 1}}})
+		if err != nil {
+			log.Printf("Failed to create index on %v in collection %v: %v", field, this.Collection, err)
+		}
+	}
+	return nil
+}
+
+// transactions are supported by replica sets and sharded clusters, not by standalone server
+func supportsTransactions(ctx context.Context, client *mongo.Client) (bool, error) {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return false, err
+	}
+	return hello.SetName != "" || hello.Msg == "isdbgrid", nil
+}
+
 func (this *mongoSvc[DocType]) Disconnect(ctx context.Context) error {
 	client := this.client.Load()
 
@@ -168,18 +266,15 @@ func (this *mongoSvc[DocType]) CreateDocument(ctx context.Context, id string, do
 	db := client.Database(this.DbName)
 	collection := db.Collection(this.Collection)
 
-	result := collection.FindOne(ctx, bson.D{{Key: "id", Value: id}})
+	raw, err := this.Encryption.marshal(document)
+	if err != nil {
+		return err
+	}
 
-	switch result.Err() {
-	case nil: // no error means there is conflicting document
+	_, err = collection.InsertOne(ctx, raw)
+	if mongo.IsDuplicateKeyError(err) { // document with the same id already exists
 		return ErrConflict
-	case mongo.ErrNoDocuments:
-		// do nothing, this is expected
-	default: // other errors - return them
-		return result.Err()
 	}
-
-	_, err = collection.InsertOne(ctx, document)
 	return err
 }
 
@@ -196,7 +291,12 @@ func (this *mongoSvc[DocType]) FindDocument(ctx context.Context, id string) (*Do
 	db := client.Database(this.DbName)
 	collection := db.Collection(this.Collection)
 
-	result := collection.FindOne(ctx, bson.D{{Key: "id", Value: id}})
+	idFilter, err := this.Encryption.filter(Eq("id", id))
+	if err != nil {
+		return nil, err
+	}
+
+	result := collection.FindOne(ctx, idFilter.toBson())
 
 	switch result.Err() {
 	case nil:
@@ -206,12 +306,17 @@ func (this *mongoSvc[DocType]) FindDocument(ctx context.Context, id string) (*Do
 		return nil, result.Err()
 	}
 
-	var document *DocType
-	if err := result.Decode(&document); err != nil {
+	raw, err := result.Raw()
+	if err != nil {
+		return nil, err
+	}
+
+	var document DocType
+	if err := this.Encryption.unmarshal(raw, &document); err != nil {
 		return nil, err
 	}
 
-	return document, nil
+	return &document, nil
 }
 
 // finds all documents from collention or by specific field
@@ -228,25 +333,131 @@ func (this *mongoSvc[DocType]) FindDocuments(ctx context.Context, field string,
 	collection := db.Collection(this.Collection)
 
 	// Construct query
-	var filter bson.D
+	var filter Filter // No filter, fetch all documents in collection
 	if field != "" && value != nil {
-		filter = bson.D{{Key: field, Value: value}}
-	} else {
-		filter = bson.D{} // No filter, fetch all documents in collection
+		filter = Eq(field, value)
+	}
+	if filter, err = this.Encryption.filter(filter); err != nil {
+		return nil, err
 	}
 
-	cursor, err := collection.Find(ctx, filter)
+	cursor, err := collection.Find(ctx, filter.toBson())
 	if err != nil {
 		return nil, err
 	}
 	defer cursor.Close(ctx)
 
-	var results []DocType
-	if err = cursor.All(ctx, &results); err != nil {
-		return nil, err
+	return this.decodeAll(ctx, cursor)
+}
+
+// finds documents matching the query, returns page of documents and total count of matching documents
+func (this *mongoSvc[DocType]) QueryDocuments(ctx context.Context, query Query) ([]DocType, int64, error) {
+	ctx, contextCancel := context.WithTimeout(ctx, this.Timeout)
+	defer contextCancel()
+
+	client, err := this.connect(ctx)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	return results, nil
+	db := client.Database(this.DbName)
+	collection := db.Collection(this.Collection)
+
+	condition, err := this.Encryption.filter(query.Filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := this.Encryption.checkSort(query.Sort); err != nil {
+		return nil, 0, err
+	}
+	filter := condition.toBson()
+
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	findOptions := options.Find()
+	if len(query.Sort) != 0 {
+		findOptions.SetSort(sortToBson(query.Sort))
+	}
+	if len(query.Projection) != 0 {
+		findOptions.SetProjection(projectionToBson(query.Projection))
+	}
+	if query.Offset > 0 {
+		findOptions.SetSkip(query.Offset)
+	}
+	if query.Limit > 0 {
+		findOptions.SetLimit(query.Limit)
+	}
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	results, err := this.decodeAll(ctx, cursor)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return results, total, nil
+}
+
+// passes documents matching the query one by one to consume function, documents are read from cursor
+// so that memory does not grow with size of the result, iteration stops on first error of consume
+func (this *mongoSvc[DocType]) StreamDocuments(ctx context.Context, query Query, consume func(document *DocType) error) error {
+	// timeout applies to the query, reading of the cursor takes as long as consumer needs
+	findCtx, contextCancel := context.WithTimeout(ctx, this.Timeout)
+	defer contextCancel()
+
+	client, err := this.connect(findCtx)
+	if err != nil {
+		return err
+	}
+
+	db := client.Database(this.DbName)
+	collection := db.Collection(this.Collection)
+
+	filter, err := this.Encryption.filter(query.Filter)
+	if err != nil {
+		return err
+	}
+	if err := this.Encryption.checkSort(query.Sort); err != nil {
+		return err
+	}
+
+	findOptions := options.Find()
+	if len(query.Sort) != 0 {
+		findOptions.SetSort(sortToBson(query.Sort))
+	}
+	if len(query.Projection) != 0 {
+		findOptions.SetProjection(projectionToBson(query.Projection))
+	}
+	if query.Offset > 0 {
+		findOptions.SetSkip(query.Offset)
+	}
+	if query.Limit > 0 {
+		findOptions.SetLimit(query.Limit)
+	}
+
+	cursor, err := collection.Find(findCtx, filter.toBson(), findOptions)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var document DocType
+		if err := this.Encryption.unmarshal(cursor.Current, &document); err != nil {
+			return err
+		}
+		if err := consume(&document); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
 }
 
 // updates document in colletion
@@ -261,17 +472,56 @@ func (this *mongoSvc[DocType]) UpdateDocument(ctx context.Context, id string, do
 
 	db := client.Database(this.DbName)
 	collection := db.Collection(this.Collection)
-	result := collection.FindOne(ctx, bson.D{{Key: "id", Value: id}})
 
-	switch result.Err() {
-	case nil:
-	case mongo.ErrNoDocuments:
+	raw, err := this.Encryption.marshal(document)
+	if err != nil {
+		return err
+	}
+	idFilter, err := this.Encryption.filter(Eq("id", id))
+	if err != nil {
+		return err
+	}
+
+	result, err := collection.ReplaceOne(ctx, idFilter.toBson(), raw)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
 		return ErrNotFound
-	default: // other errors - return them
-		return result.Err()
 	}
-	_, err = collection.ReplaceOne(ctx, bson.D{{Key: "id", Value: id}}, document)
-	return err
+	return nil
+}
+
+// updates document in collection only if it matches the condition
+func (this *mongoSvc[DocType]) UpdateDocumentIf(ctx context.Context, id string, condition Filter, document *DocType) error {
+	ctx, contextCancel := context.WithTimeout(ctx, this.Timeout)
+	defer contextCancel()
+
+	client, err := this.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	db := client.Database(this.DbName)
+	collection := db.Collection(this.Collection)
+
+	raw, err := this.Encryption.marshal(document)
+	if err != nil {
+		return err
+	}
+	filter, err := this.Encryption.filter(And(Eq("id", id), condition))
+	if err != nil {
+		return err
+	}
+
+	result, err := collection.ReplaceOne(ctx, filter.toBson(), raw)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return this.conditionError(ctx, collection, id)
+	}
+	return nil
 }
 
 // deletes document from collection
@@ -287,16 +537,331 @@ func (this *mongoSvc[DocType]) DeleteDocument(ctx context.Context, id string) er
 	db := client.Database(this.DbName)
 	collection := db.Collection(this.Collection)
 
-	result := collection.FindOne(ctx, bson.D{{Key: "id", Value: id}})
+	idFilter, err := this.Encryption.filter(Eq("id", id))
+	if err != nil {
+		return err
+	}
 
-	switch result.Err() {
-	case nil:
-	case mongo.ErrNoDocuments:
+	result, err := collection.DeleteOne(ctx, idFilter.toBson())
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
 		return ErrNotFound
-	default: // other errors - return them
-		return result.Err()
 	}
+	return nil
+}
 
-	_, err = collection.DeleteOne(ctx, bson.D{{Key: "id", Value: id}})
-	return err
+// deletes document from collection only if it matches the condition
+func (this *mongoSvc[DocType]) DeleteDocumentIf(ctx context.Context, id string, condition Filter) error {
+	ctx, contextCancel := context.WithTimeout(ctx, this.Timeout)
+	defer contextCancel()
+
+	client, err := this.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	db := client.Database(this.DbName)
+	collection := db.Collection(this.Collection)
+
+	filter, err := this.Encryption.filter(And(Eq("id", id), condition))
+	if err != nil {
+		return err
+	}
+
+	result, err := collection.DeleteOne(ctx, filter.toBson())
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return this.conditionError(ctx, collection, id)
+	}
+	return nil
+}
+
+// applies operations and returns result of each of them, operations are sent to database in one unordered
+// bulk write, in atomic mode operations run in their order in transaction (requires MongoDB replica set)
+// which is aborted on first failure of operation
+func (this *mongoSvc[DocType]) BulkWrite(ctx context.Context, operations []WriteOperation[DocType], atomic bool) ([]error, error) {
+	if !atomic {
+		return this.bulkWriteUnordered(ctx, operations)
+	}
+
+	connectCtx, contextCancel := context.WithTimeout(ctx, this.Timeout)
+	defer contextCancel()
+
+	client, err := this.connect(connectCtx)
+	if err != nil {
+		return nil, err
+	}
+	if !this.transactions.Load() {
+		return nil, ErrTransactionsUnsupported
+	}
+
+	session, err := client.StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	var results []error
+	operationFailed := false
+	_, err = session.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) (interface{}, error) {
+		results = applyWrites[DocType](sessionCtx, this, operations, true)
+		for _, result := range results {
+			if result != nil && result != ErrRolledBack {
+				operationFailed = true
+				return nil, result
+			}
+		}
+		operationFailed = false
+		return nil, nil
+	})
+
+	if operationFailed {
+		return results, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// applies operations independently of each other in single unordered bulk write of the database. Conditions
+// are checked against current documents before the write, so that result of each operation can be reported,
+// they are still part of filters of the write, so documents changed meanwhile are not overwritten.
+func (this *mongoSvc[DocType]) bulkWriteUnordered(ctx context.Context, operations []WriteOperation[DocType]) ([]error, error) {
+	ctx, contextCancel := context.WithTimeout(ctx, this.Timeout)
+	defer contextCancel()
+
+	client, err := this.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	db := client.Database(this.DbName)
+	collection := db.Collection(this.Collection)
+
+	current, err := this.currentDocuments(ctx, collection, operations)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]error, len(operations))
+	raws := make([]bson.Raw, len(operations))
+	filters := make([]Filter, len(operations))
+	models := []mongo.WriteModel{}
+	modelOperations := []int{} // index of operation of each model
+	for i, operation := range operations {
+		if operation.Kind == WriteCreate || operation.Kind == WriteUpdate {
+			if raws[i], results[i] = this.Encryption.marshal(operation.Document); results[i] != nil {
+				continue
+			}
+		}
+		if operation.Kind == WriteUpdate || operation.Kind == WriteDelete {
+			if filters[i], results[i] = this.Encryption.filter(And(Eq("id", operation.Id), operation.Condition)); results[i] != nil {
+				continue
+			}
+			if results[i] = this.checkCurrent(current, operation.Id, filters[i]); results[i] != nil {
+				continue
+			}
+		}
+
+		switch operation.Kind {
+		case WriteCreate:
+			models = append(models, mongo.NewInsertOneModel().SetDocument(raws[i]))
+		case WriteUpdate:
+			models = append(models, mongo.NewReplaceOneModel().SetFilter(filters[i].toBson()).SetReplacement(raws[i]))
+		case WriteDelete:
+			models = append(models, mongo.NewDeleteOneModel().SetFilter(filters[i].toBson()))
+		default:
+			results[i] = fmt.Errorf("unknown kind of write operation: %v", operation.Kind)
+			continue
+		}
+		modelOperations = append(modelOperations, i)
+	}
+	if len(models) == 0 {
+		return results, nil
+	}
+
+	result, err := collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	var bulkErr mongo.BulkWriteException
+	switch {
+	case errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil:
+		for _, writeErr := range bulkErr.WriteErrors {
+			i := modelOperations[writeErr.Index]
+			if writeErr.Code == duplicateKeyCode { // document with the same id already exists
+				results[i] = ErrConflict
+			} else {
+				results[i] = writeErr
+			}
+		}
+	case err != nil:
+		return nil, err
+	}
+
+	// documents changed between check of conditions and the write were not matched by their operations
+	expected := int64(0)
+	for _, i := range modelOperations {
+		if operations[i].Kind != WriteCreate && results[i] == nil {
+			expected++
+		}
+	}
+	if result != nil && result.MatchedCount+result.DeletedCount < expected {
+		if err := this.verifyWrites(ctx, collection, operations, raws, filters, modelOperations, results); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// code of write error of duplicate key
+const duplicateKeyCode = 11000
+
+// loads current documents of updated and deleted ids
+func (this *mongoSvc[DocType]) currentDocuments(ctx context.Context, collection *mongo.Collection, operations []WriteOperation[DocType]) ([]bson.Raw, error) {
+	ids := []interface{}{}
+	for _, operation := range operations {
+		if operation.Kind == WriteUpdate || operation.Kind == WriteDelete {
+			ids = append(ids, operation.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	filter, err := this.Encryption.filter(In("id", ids...))
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := collection.Find(ctx, filter.toBson())
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var raws []bson.Raw
+	if err := cursor.All(ctx, &raws); err != nil {
+		return nil, err
+	}
+	return raws, nil
+}
+
+// checks that document with the id exists and matches the filter of the operation
+func (this *mongoSvc[DocType]) checkCurrent(current []bson.Raw, id string, filter Filter) error {
+	idFilter, err := this.Encryption.filter(Eq("id", id))
+	if err != nil {
+		return err
+	}
+	for _, raw := range current {
+		if found, err := idFilter.matches(raw); err != nil || !found {
+			continue
+		}
+		matched, err := filter.matches(raw)
+		switch {
+		case err != nil:
+			return err
+		case !matched:
+			return ErrConditionFailed
+		default:
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// finds updates and deletes which were not applied, because their document was changed or deleted
+// after its condition was checked, updated documents are compared with their replacements
+func (this *mongoSvc[DocType]) verifyWrites(ctx context.Context, collection *mongo.Collection, operations []WriteOperation[DocType], raws []bson.Raw, filters []Filter, modelOperations []int, results []error) error {
+	current, err := this.currentDocuments(ctx, collection, operations)
+	if err != nil {
+		return err
+	}
+
+	for _, i := range modelOperations {
+		operation := operations[i]
+		if operation.Kind == WriteCreate || results[i] != nil {
+			continue
+		}
+
+		err := this.checkCurrent(current, operation.Id, filters[i])
+		switch {
+		case operation.Kind == WriteDelete && err == nil:
+			// document still matches, so it was not deleted
+			results[i] = ErrConditionFailed
+		case operation.Kind == WriteUpdate && err == ErrNotFound:
+			results[i] = ErrNotFound
+		case operation.Kind == WriteUpdate && !this.isReplacedBy(current, operation.Id, raws[i]):
+			results[i] = ErrConditionFailed
+		}
+	}
+	return nil
+}
+
+// checks that document with the id has the same fields as replacement written by update
+func (this *mongoSvc[DocType]) isReplacedBy(current []bson.Raw, id string, replacement bson.Raw) bool {
+	idFilter, err := this.Encryption.filter(Eq("id", id))
+	if err != nil {
+		return false
+	}
+	for _, raw := range current {
+		if found, err := idFilter.matches(raw); err != nil || !found {
+			continue
+		}
+		stored, err := raw.Elements()
+		if err != nil {
+			return false
+		}
+		stored = slices.DeleteFunc(stored, func(element bson.RawElement) bool {
+			return element.Key() == "_id"
+		})
+		written, err := replacement.Elements()
+		if err != nil || len(written) != len(stored) {
+			return false
+		}
+		for j := range written {
+			if !bytes.Equal(written[j], stored[j]) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// decodes all documents of the cursor, encrypted fields are decrypted
+func (this *mongoSvc[DocType]) decodeAll(ctx context.Context, cursor *mongo.Cursor) ([]DocType, error) {
+	var raws []bson.Raw
+	if err := cursor.All(ctx, &raws); err != nil {
+		return nil, err
+	}
+
+	var results []DocType
+	for _, raw := range raws {
+		var document DocType
+		if err := this.Encryption.unmarshal(raw, &document); err != nil {
+			return nil, err
+		}
+		results = append(results, document)
+	}
+	return results, nil
+}
+
+// distinguishes missing document from document not matching the condition
+func (this *mongoSvc[DocType]) conditionError(ctx context.Context, collection *mongo.Collection, id string) error {
+	idFilter, err := this.Encryption.filter(Eq("id", id))
+	if err != nil {
+		return err
+	}
+
+	count, err := collection.CountDocuments(ctx, idFilter.toBson())
+	switch {
+	case err != nil:
+		return err
+	case count == 0:
+		return ErrNotFound
+	default:
+		return ErrConditionFailed
+	}
 }
@@ -0,0 +1,472 @@
+package db_service
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// Query describes filtering, sorting, projection and paging of documents
+type Query struct {
+	Filter     Filter
+	Sort       []SortField
+	Projection []string // fields to load, empty means all fields
+	Limit      int64    // zero means no limit
+	Offset     int64
+}
+
+// SortField describes ordering of documents by single field
+type SortField struct {
+	Field      string
+	Descending bool
+}
+
+// Filter is condition on documents, zero value matches all documents
+type Filter struct {
+	operator string
+	field    string
+	value    interface{}
+	values   []interface{}
+	filters  []Filter
+}
+
+const (
+	opEq        = "$eq"
+	opNe        = "$ne"
+	opGt        = "$gt"
+	opGte       = "$gte"
+	opLt        = "$lt"
+	opLte       = "$lte"
+	opIn        = "$in"
+	opNin       = "$nin"
+	opExists    = "$exists"
+	opAnd       = "$and"
+	opOr        = "$or"
+	opNor       = "$nor"
+	opElemMatch = "$elemMatch"
+)
+
+// matches documents where field equals to value
+func Eq(field string, value interface{}) Filter {
+	return Filter{operator: opEq, field: field, value: value}
+}
+
+// matches documents where field does not equal to value
+func Ne(field string, value interface{}) Filter {
+	return Filter{operator: opNe, field: field, value: value}
+}
+
+// matches documents where field is greater than value
+func Gt(field string, value interface{}) Filter {
+	return Filter{operator: opGt, field: field, value: value}
+}
+
+// matches documents where field is greater than or equal to value
+func Gte(field string, value interface{}) Filter {
+	return Filter{operator: opGte, field: field, value: value}
+}
+
+// matches documents where field is less than value
+func Lt(field string, value interface{}) Filter {
+	return Filter{operator: opLt, field: field, value: value}
+}
+
+// matches documents where field is less than or equal to value
+func Lte(field string, value interface{}) Filter {
+	return Filter{operator: opLte, field: field, value: value}
+}
+
+// matches documents where field is in the range <from, to>
+func Between(field string, from interface{}, to interface{}) Filter {
+	return And(Gte(field, from), Lte(field, to))
+}
+
+// matches documents where field equals to any of the values
+func In(field string, values ...interface{}) Filter {
+	return Filter{operator: opIn, field: field, values: values}
+}
+
+// matches documents where field equals to none of the values
+func Nin(field string, values ...interface{}) Filter {
+	return Filter{operator: opNin, field: field, values: values}
+}
+
+// matches documents which have (exists == true) or do not have the field
+func Exists(field string, exists bool) Filter {
+	return Filter{operator: opExists, field: field, value: exists}
+}
+
+// matches documents satisfying all of the filters
+func And(filters ...Filter) Filter {
+	return Filter{operator: opAnd, filters: filters}
+}
+
+// matches documents satisfying at least one of the filters
+func Or(filters ...Filter) Filter {
+	return Filter{operator: opOr, filters: filters}
+}
+
+// matches documents not satisfying the filter
+func Not(filter Filter) Filter {
+	return Filter{operator: opNor, filters: []Filter{filter}}
+}
+
+// matches documents where field is array with at least one element (document) satisfying the filter,
+// fields of the filter are relative to the element
+func ElemMatch(field string, filter Filter) Filter {
+	return Filter{operator: opElemMatch, field: field, filters: []Filter{filter}}
+}
+
+// translates filter into mongo query document
+func (f Filter) toBson() bson.D {
+	switch f.operator {
+	case "":
+		return bson.D{}
+	case opAnd, opOr, opNor:
+		conditions := bson.A{}
+		for _, filter := range f.filters {
+			if filter.operator != "" {
+				conditions = append(conditions, filter.toBson())
+			} else if f.operator == opOr {
+				// empty filter matches everything
+				return bson.D{}
+			}
+		}
+		if len(conditions) == 0 {
+			if f.operator == opAnd || f.operator == opNor {
+				return bson.D{}
+			}
+			// empty $or is not allowed in mongo, use condition which never matches
+			return bson.D{{Key: "_id", Value: bson.D{{Key: opExists, Value: false}}}}
+		}
+		return bson.D{{Key: f.operator, Value: conditions}}
+	case opElemMatch:
+		return bson.D{{Key: f.field, Value: bson.D{{Key: opElemMatch, Value: f.filters[0].toBson()}}}}
+	case opIn, opNin:
+		values := bson.A{}
+		values = append(values, f.values...)
+		return bson.D{{Key: f.field, Value: bson.D{{Key: f.operator, Value: values}}}}
+	default:
+		return bson.D{{Key: f.field, Value: bson.D{{Key: f.operator, Value: f.value}}}}
+	}
+}
+
+// evaluates filter against document, used by in-memory service
+func (f Filter) matches(document bson.Raw) (bool, error) {
+	switch f.operator {
+	case "":
+		return true, nil
+	case opAnd:
+		for _, filter := range f.filters {
+			if ok, err := filter.matches(document); err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	case opOr, opNor:
+		matched := false
+		for _, filter := range f.filters {
+			ok, err := filter.matches(document)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				matched = true
+				break
+			}
+		}
+		return matched == (f.operator == opOr), nil
+	}
+
+	actualValues := lookupValues(document, f.field)
+
+	switch f.operator {
+	case opExists:
+		return (len(actualValues) != 0) == f.value.(bool), nil
+	case opElemMatch:
+		// only elements of arrays are matched, embedded document alone is not
+		for _, actual := range lookupPath(rootValue(document), strings.Split(f.field, ".")) {
+			if actual.Type != bson.TypeArray {
+				continue
+			}
+			elements, _ := actual.Array().Values()
+			for _, element := range elements {
+				if element.Type != bson.TypeEmbeddedDocument {
+					continue
+				}
+				if ok, err := f.filters[0].matches(element.Document()); err != nil || ok {
+					return ok, err
+				}
+			}
+		}
+		return false, nil
+	case opIn, opNin:
+		matched := false
+		for _, value := range f.values {
+			expected, err := toRawValue(value)
+			if err != nil {
+				return false, err
+			}
+			if anyValueMatches(actualValues, opEq, expected) {
+				matched = true
+				break
+			}
+		}
+		return matched == (f.operator == opIn), nil
+	}
+
+	expected, err := toRawValue(f.value)
+	if err != nil {
+		return false, err
+	}
+	if f.operator == opNe {
+		return !anyValueMatches(actualValues, opEq, expected), nil
+	}
+	return anyValueMatches(actualValues, f.operator, expected), nil
+}
+
+// mongo matches field holding an array when any of its elements matches,
+// missing field is treated as null
+func anyValueMatches(actualValues []bson.RawValue, operator string, expected bson.RawValue) bool {
+	if len(actualValues) == 0 {
+		actualValues = []bson.RawValue{{Type: bson.TypeNull}}
+	}
+
+	for _, actual := range actualValues {
+		var matched bool
+		switch operator {
+		case opEq:
+			matched = compareRawValues(actual, expected) == 0
+		case opGt:
+			matched = sameTypeBracket(actual, expected) && compareRawValues(actual, expected) > 0
+		case opGte:
+			matched = sameTypeBracket(actual, expected) && compareRawValues(actual, expected) >= 0
+		case opLt:
+			matched = sameTypeBracket(actual, expected) && compareRawValues(actual, expected) < 0
+		case opLte:
+			matched = sameTypeBracket(actual, expected) && compareRawValues(actual, expected) <= 0
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// resolves dotted field path in document, descending into arrays in the same way as mongo does
+func lookupValues(document bson.Raw, field string) []bson.RawValue {
+	var result []bson.RawValue
+	for _, value := range lookupPath(rootValue(document), strings.Split(field, ".")) {
+		result = append(result, value)
+		if array, ok := value.ArrayOK(); ok {
+			// array field matches both as whole and by its elements
+			elements, _ := array.Values()
+			result = append(result, elements...)
+		}
+	}
+	return result
+}
+
+func rootValue(document bson.Raw) bson.RawValue {
+	return bson.RawValue{Type: bson.TypeEmbeddedDocument, Value: document}
+}
+
+// values of the field without expanding arrays held by the field itself
+func lookupPath(value bson.RawValue, path []string) []bson.RawValue {
+	if len(path) == 0 {
+		return []bson.RawValue{value}
+	}
+
+	switch value.Type {
+	case bson.TypeEmbeddedDocument:
+		child, err := value.Document().LookupErr(path[0])
+		if err != nil {
+			return nil
+		}
+		return lookupPath(child, path[1:])
+	case bson.TypeArray:
+		var result []bson.RawValue
+		elements, _ := value.Array().Values()
+		for _, element := range elements {
+			if element.Type == bson.TypeEmbeddedDocument {
+				result = append(result, lookupPath(element, path)...)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+func toRawValue(value interface{}) (bson.RawValue, error) {
+	if value == nil {
+		return bson.RawValue{Type: bson.TypeNull}, nil
+	}
+	valueType, data, err := bson.MarshalValue(value)
+	if err != nil {
+		return bson.RawValue{}, err
+	}
+	return bson.RawValue{Type: valueType, Value: data}, nil
+}
+
+// first value of the field used for sorting, missing fields are treated as null
+func lookupField(document bson.Raw, field string) bson.RawValue {
+	if values := lookupValues(document, field); len(values) != 0 {
+		return values[0]
+	}
+	return bson.RawValue{Type: bson.TypeNull}
+}
+
+// orders types in the same way as mongo does when comparing values of different types
+func typeOrder(t bsontype.Type) int {
+	switch t {
+	case bson.TypeNull, bson.TypeUndefined:
+		return 1
+	case bson.TypeInt32, bson.TypeInt64, bson.TypeDouble, bson.TypeDecimal128:
+		return 2
+	case bson.TypeString, bson.TypeSymbol:
+		return 3
+	case bson.TypeEmbeddedDocument:
+		return 4
+	case bson.TypeArray:
+		return 5
+	case bson.TypeBinary:
+		return 6
+	case bson.TypeObjectID:
+		return 7
+	case bson.TypeBoolean:
+		return 8
+	case bson.TypeDateTime:
+		return 9
+	case bson.TypeTimestamp:
+		return 10
+	default:
+		return 11
+	}
+}
+
+// range operators in mongo only match values of the same type bracket
+func sameTypeBracket(a, b bson.RawValue) bool {
+	return typeOrder(a.Type) == typeOrder(b.Type)
+}
+
+// compares two bson values, returns negative, zero or positive number
+func compareRawValues(a, b bson.RawValue) int {
+	if orderA, orderB := typeOrder(a.Type), typeOrder(b.Type); orderA != orderB {
+		return orderA - orderB
+	}
+
+	switch a.Type {
+	case bson.TypeNull, bson.TypeUndefined:
+		return 0
+	case bson.TypeInt32, bson.TypeInt64, bson.TypeDouble, bson.TypeDecimal128:
+		x, y := numericValue(a), numericValue(b)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case bson.TypeString:
+		return strings.Compare(a.StringValue(), b.StringValue())
+	case bson.TypeBoolean:
+		x, y := a.Boolean(), b.Boolean()
+		switch {
+		case x == y:
+			return 0
+		case !x:
+			return -1
+		}
+		return 1
+	case bson.TypeDateTime:
+		x, y := a.DateTime(), b.DateTime()
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	return bytes.Compare(a.Value, b.Value)
+}
+
+func numericValue(value bson.RawValue) float64 {
+	switch value.Type {
+	case bson.TypeInt32:
+		return float64(value.Int32())
+	case bson.TypeInt64:
+		return float64(value.Int64())
+	case bson.TypeDouble:
+		return value.Double()
+	case bson.TypeDecimal128:
+		// precision of decimals beyond double is not needed by any of the queries
+		number, _ := strconv.ParseFloat(value.Decimal128().String(), 64)
+		return number
+	}
+	return 0
+}
+
+// translates sort fields into mongo sort document
+func sortToBson(sort []SortField) bson.D {
+	result := bson.D{}
+	for _, field := range sort {
+		direction := 1
+		if field.Descending {
+			direction = -1
+		}
+		result = append(result, bson.E{Key: field.Field, Value: direction})
+	}
+	return result
+}
+
+// compares two documents by sort fields, used by in-memory service
+func compareBySort(a, b bson.Raw, sort []SortField) int {
+	for _, field := range sort {
+		result := compareRawValues(lookupField(a, field.Field), lookupField(b, field.Field))
+		if field.Descending {
+			result = -result
+		}
+		if result != 0 {
+			return result
+		}
+	}
+	return 0
+}
+
+// translates projection into mongo projection document
+func projectionToBson(projection []string) bson.D {
+	result := bson.D{}
+	for _, field := range projection {
+		result = append(result, bson.E{Key: field, Value: 1})
+	}
+	return result
+}
+
+// keeps only projected top level fields of document, used by in-memory service
+func projectDocument(document bson.Raw, projection []string) (bson.Raw, error) {
+	if len(projection) == 0 {
+		return document, nil
+	}
+
+	fields := map[string]struct{}{}
+	for _, field := range projection {
+		fields[strings.Split(field, ".")[0]] = struct{}{}
+	}
+
+	elements, err := document.Elements()
+	if err != nil {
+		return nil, err
+	}
+
+	projected := bson.D{}
+	for _, element := range elements {
+		if _, ok := fields[element.Key()]; ok {
+			projected = append(projected, bson.E{Key: element.Key(), Value: element.Value()})
+		}
+	}
+	return bson.Marshal(projected)
+}
@@ -0,0 +1,166 @@
+package db_service
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// documents with values of different types, keyed by their id
+func testFilterDocuments(t *testing.T) map[string]bson.Raw {
+	t.Helper()
+	decimal, err := primitive.ParseDecimal128("2.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	documents := map[string]bson.Raw{}
+	for _, document := range []bson.D{
+		{{Key: "id", Value: "missing"}},
+		{{Key: "id", Value: "null"}, {Key: "value", Value: nil}},
+		{{Key: "id", Value: "int"}, {Key: "value", Value: int32(1)}},
+		{{Key: "id", Value: "long"}, {Key: "value", Value: int64(2)}},
+		{{Key: "id", Value: "double"}, {Key: "value", Value: 1.5}},
+		{{Key: "id", Value: "decimal"}, {Key: "value", Value: decimal}},
+		{{Key: "id", Value: "string"}, {Key: "value", Value: "1"}},
+		{{Key: "id", Value: "array"}, {Key: "value", Value: bson.A{int32(1), "a"}}, {Key: "items", Value: bson.A{
+			bson.D{{Key: "code", Value: "a"}, {Key: "count", Value: 1}},
+			bson.D{{Key: "code", Value: "b"}, {Key: "count", Value: 3}},
+		}}},
+		{{Key: "id", Value: "subdocument"}, {Key: "items", Value: bson.D{{Key: "code", Value: "a"}, {Key: "count", Value: 1}}}},
+		{{Key: "id", Value: "empty"}, {Key: "items", Value: bson.A{}}},
+	} {
+		raw, err := bson.Marshal(document)
+		if err != nil {
+			t.Fatal(err)
+		}
+		documents[document[0].Value.(string)] = raw
+	}
+	return documents
+}
+
+// filters with mongo query they are translated to and ids of test documents they match
+func testFilters() []struct {
+	name    string
+	filter  Filter
+	query   string
+	matches []string
+} {
+	decimal, _ := primitive.ParseDecimal128("2.5")
+	return []struct {
+		name    string
+		filter  Filter
+		query   string
+		matches []string
+	}{
+		{"empty", Filter{}, `{}`,
+			[]string{"array", "decimal", "double", "empty", "int", "long", "missing", "null", "string", "subdocument"}},
+		{"eq number", Eq("value", 1), `{"value":{"$eq":1}}`, []string{"array", "int"}},
+		{"eq double matches long", Eq("value", 2.0), `{"value":{"$eq":2.0}}`, []string{"long"}},
+		{"eq double matches decimal", Eq("value", 2.5), `{"value":{"$eq":2.5}}`, []string{"decimal"}},
+		{"eq decimal", Eq("value", decimal), `{"value":{"$eq":{"$numberDecimal":"2.5"}}}`, []string{"decimal"}},
+		{"eq null matches missing", Eq("value", nil), `{"value":{"$eq":null}}`, []string{"empty", "missing", "null", "subdocument"}},
+		{"gt across numeric types", Gt("value", 1), `{"value":{"$gt":1}}`, []string{"decimal", "double", "long"}},
+		{"lte long", Lte("value", int64(2)), `{"value":{"$lte":2}}`, []string{"array", "double", "int", "long"}},
+		{"lt string", Lt("value", "2"), `{"value":{"$lt":"2"}}`, []string{"string"}},
+		{"gte null", Gte("value", nil), `{"value":{"$gte":null}}`, []string{"empty", "missing", "null", "subdocument"}},
+		{"ne matches missing", Ne("value", 1), `{"value":{"$ne":1}}`,
+			[]string{"decimal", "double", "empty", "long", "missing", "null", "string", "subdocument"}},
+		{"ne null", Ne("value", nil), `{"value":{"$ne":null}}`, []string{"array", "decimal", "double", "int", "long", "string"}},
+		{"in", In("value", 2, "a"), `{"value":{"$in":[2,"a"]}}`, []string{"array", "long"}},
+		{"nin matches missing", Nin("value", 1, "1"), `{"value":{"$nin":[1,"1"]}}`,
+			[]string{"decimal", "double", "empty", "long", "missing", "null", "subdocument"}},
+		{"nin null", Nin("value", nil, 2), `{"value":{"$nin":[null,2]}}`, []string{"array", "decimal", "double", "int", "string"}},
+		{"exists with null value", Exists("value", true), `{"value":{"$exists":true}}`,
+			[]string{"array", "decimal", "double", "int", "long", "null", "string"}},
+		{"not exists", Exists("value", false), `{"value":{"$exists":false}}`, []string{"empty", "missing", "subdocument"}},
+		{"exists in array and subdocument", Exists("items.code", true), `{"items.code":{"$exists":true}}`, []string{"array", "subdocument"}},
+		{"elem match", ElemMatch("items", And(Eq("code", "a"), Gte("count", 1))),
+			`{"items":{"$elemMatch":{"$and":[{"code":{"$eq":"a"}},{"count":{"$gte":1}}]}}}`, []string{"array"}},
+		{"elem match single element", ElemMatch("items", And(Eq("code", "a"), Gt("count", 1))),
+			`{"items":{"$elemMatch":{"$and":[{"code":{"$eq":"a"}},{"count":{"$gt":1}}]}}}`, []string{}},
+		{"and across elements", And(Eq("items.code", "a"), Gt("items.count", 1)),
+			`{"$and":[{"items.code":{"$eq":"a"}},{"items.count":{"$gt":1}}]}`, []string{"array"}},
+		{"between", Between("value", 1, 2), `{"$and":[{"value":{"$gte":1}},{"value":{"$lte":2}}]}`,
+			[]string{"array", "double", "int", "long"}},
+		{"not", Not(Gt("value", 1)), `{"$nor":[{"value":{"$gt":1}}]}`,
+			[]string{"array", "empty", "int", "missing", "null", "string", "subdocument"}},
+		{"or", Or(Eq("value", "1"), Eq("items.code", "b")), `{"$or":[{"value":{"$eq":"1"}},{"items.code":{"$eq":"b"}}]}`,
+			[]string{"array", "string"}},
+		{"empty or", Or(), `{"_id":{"$exists":false}}`, []string{}},
+		{"or with empty filter", Or(Eq("value", "1"), Filter{}), `{}`,
+			[]string{"array", "decimal", "double", "empty", "int", "long", "missing", "null", "string", "subdocument"}},
+	}
+}
+
+func TestFilterMatches(t *testing.T) {
+	documents := testFilterDocuments(t)
+	for _, test := range testFilters() {
+		t.Run(test.name, func(t *testing.T) {
+			query, err := bson.MarshalExtJSON(test.filter.toBson(), false, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(query) != test.query {
+				t.Errorf("Expected query %v, got %v", test.query, string(query))
+			}
+
+			matches := []string{}
+			for id, document := range documents {
+				ok, err := test.filter.matches(document)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ok {
+					matches = append(matches, id)
+				}
+			}
+			slices.Sort(matches)
+			if !slices.Equal(matches, test.matches) {
+				t.Errorf("Expected matched documents %v, got %v", test.matches, matches)
+			}
+		})
+	}
+}
+
+// the same filters evaluated by mongo server match the same documents as in memory
+func TestMongoFilterMatches(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestMongoService[testDocument](t, MongoServiceConfig{})
+	client, err := svc.connect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collection := client.Database(svc.DbName).Collection(svc.Collection)
+	for _, document := range testFilterDocuments(t) {
+		if _, err := collection.InsertOne(ctx, document); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range testFilters() {
+		t.Run(test.name, func(t *testing.T) {
+			cursor, err := collection.Find(ctx, test.filter.toBson())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var found []struct {
+				Id string `bson:"id"`
+			}
+			if err := cursor.All(ctx, &found); err != nil {
+				t.Fatal(err)
+			}
+			matches := []string{}
+			for _, document := range found {
+				matches = append(matches, document.Id)
+			}
+			slices.Sort(matches)
+			if !slices.Equal(matches, test.matches) {
+				t.Errorf("Expected matched documents %v, got %v", test.matches, matches)
+			}
+		})
+	}
+}
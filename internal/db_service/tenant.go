@@ -0,0 +1,46 @@
+package db_service
+
+import (
+	"context"
+)
+
+// TenantServices holds separate DbService of every tenant, documents of tenants are stored in separate
+// databases (see MongoServiceConfig.Tenant), so service of one tenant can never read documents of another one
+type TenantServices[DocType interface{}] struct {
+	services map[string]DbService[DocType]
+}
+
+// creates service of every tenant by the factory, set of tenants is fixed for the lifetime of the services
+func NewTenantServices[DocType interface{}](tenants []string, factory func(tenant string) DbService[DocType]) *TenantServices[DocType] {
+	services := map[string]DbService[DocType]{}
+	for _, tenant := range tenants {
+		services[tenant] = factory(tenant)
+	}
+	return &TenantServices[DocType]{services: services}
+}
+
+// returns service of the tenant, false when tenant is not known
+func (this *TenantServices[DocType]) For(tenant string) (DbService[DocType], bool) {
+	service, exists := this.services[tenant]
+	return service, exists
+}
+
+// connects services of all tenants, see Connect
+func (this *TenantServices[DocType]) Connect(ctx context.Context) error {
+	for _, service := range this.services {
+		if err := Connect(ctx, service); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *TenantServices[DocType]) Disconnect(ctx context.Context) error {
+	var firstErr error
+	for _, service := range this.services {
+		if err := service.Disconnect(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
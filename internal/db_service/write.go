@@ -0,0 +1,61 @@
+package db_service
+
+import (
+	"context"
+	"fmt"
+)
+
+// kinds of write operations of bulk write
+const (
+	WriteCreate = "create"
+	WriteUpdate = "update"
+	WriteDelete = "delete"
+)
+
+// WriteOperation describes single write of bulk write. Document is used by create and update,
+// Condition is checked by update and delete, zero value means the operation is unconditional.
+type WriteOperation[DocType interface{}] struct {
+	Kind      string
+	Id        string
+	Document  *DocType
+	Condition Filter
+}
+
+// reported for operations of atomic bulk write which were not applied because other operation failed
+var ErrRolledBack = fmt.Errorf("rolled back: other operation of the bulk write failed")
+
+// returned by atomic bulk write when the database does not support transactions, i.e. it is not replica set
+var ErrTransactionsUnsupported = fmt.Errorf("transactions are not supported: database is not replica set nor sharded cluster")
+
+// applies operations in their order one by one with semantics of single document methods,
+// when stopOnError is set remaining operations are not applied after first failure
+func applyWrites[DocType interface{}](ctx context.Context, svc DbService[DocType], operations []WriteOperation[DocType], stopOnError bool) []error {
+	results := make([]error, len(operations))
+	for i, operation := range operations {
+		switch operation.Kind {
+		case WriteCreate:
+			results[i] = svc.CreateDocument(ctx, operation.Id, operation.Document)
+		case WriteUpdate:
+			results[i] = svc.UpdateDocumentIf(ctx, operation.Id, operation.Condition, operation.Document)
+		case WriteDelete:
+			results[i] = svc.DeleteDocumentIf(ctx, operation.Id, operation.Condition)
+		default:
+			results[i] = fmt.Errorf("unknown kind of write operation: %v", operation.Kind)
+		}
+
+		if results[i] != nil && stopOnError {
+			markRolledBack(results, i)
+			break
+		}
+	}
+	return results
+}
+
+// marks all operations except the failed one as rolled back
+func markRolledBack(results []error, failed int) {
+	for i := range results {
+		if i != failed {
+			results[i] = ErrRolledBack
+		}
+	}
+}
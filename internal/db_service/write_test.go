@@ -0,0 +1,205 @@
+package db_service
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type testDocument struct {
+	Id      string `bson:"id"`
+	Version int    `bson:"version"`
+}
+
+func newTestMemoryService(t *testing.T, documents ...testDocument) DbService[testDocument] {
+	t.Helper()
+	svc := NewMemoryService[testDocument](MemoryServiceConfig{})
+	for i := range documents {
+		if err := svc.CreateDocument(context.Background(), documents[i].Id, &documents[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return svc
+}
+
+// operations covering every result of single operation
+func testWriteOperations() []WriteOperation[testDocument] {
+	return []WriteOperation[testDocument]{
+		{Kind: WriteCreate, Id: "d3", Document: &testDocument{Id: "d3", Version: 1}},
+		{Kind: WriteCreate, Id: "d1", Document: &testDocument{Id: "d1", Version: 1}},
+		{Kind: WriteUpdate, Id: "d1", Document: &testDocument{Id: "d1", Version: 2}, Condition: Eq("version", 1)},
+		{Kind: WriteUpdate, Id: "d2", Document: &testDocument{Id: "d2", Version: 3}, Condition: Eq("version", 2)},
+		{Kind: WriteDelete, Id: "missing"},
+	}
+}
+
+func TestBulkWriteIndependent(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestMemoryService(t, testDocument{"d1", 1}, testDocument{"d2", 1})
+
+	results, err := svc.BulkWrite(ctx, testWriteOperations(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []error{nil, ErrConflict, nil, ErrConditionFailed, ErrNotFound}
+	for i := range expected {
+		if results[i] != expected[i] {
+			t.Errorf("Expected result %v of operation %v, got %v", expected[i], i, results[i])
+		}
+	}
+
+	if document, err := svc.FindDocument(ctx, "d1"); err != nil || document.Version != 2 {
+		t.Errorf("Expected updated document d1, got %+v (%v)", document, err)
+	}
+	if _, err := svc.FindDocument(ctx, "d3"); err != nil {
+		t.Errorf("Expected created document d3, got %v", err)
+	}
+}
+
+func TestBulkWriteAtomic(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestMemoryService(t, testDocument{"d1", 1}, testDocument{"d2", 1})
+
+	results, err := svc.BulkWrite(ctx, testWriteOperations(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, result := range results {
+		if i == 1 && result != ErrConflict {
+			t.Errorf("Expected conflict of operation 1, got %v", result)
+		}
+		if i != 1 && result != ErrRolledBack {
+			t.Errorf("Expected operation %v to be rolled back, got %v", i, result)
+		}
+	}
+
+	if _, err := svc.FindDocument(ctx, "d3"); err != ErrNotFound {
+		t.Errorf("Expected creation of d3 to be rolled back, got %v", err)
+	}
+}
+
+func TestMongoCheckCurrent(t *testing.T) {
+	svc := &mongoSvc[testDocument]{}
+	current := []bson.Raw{}
+	for _, document := range []testDocument{{"d1", 1}, {"d2", 2}} {
+		raw, err := bson.Marshal(document)
+		if err != nil {
+			t.Fatal(err)
+		}
+		current = append(current, raw)
+	}
+
+	tests := []struct {
+		id       string
+		filter   Filter
+		expected error
+	}{
+		{"d1", And(Eq("id", "d1"), Eq("version", 1)), nil},
+		{"d2", And(Eq("id", "d2"), Eq("version", 1)), ErrConditionFailed},
+		{"d3", Eq("id", "d3"), ErrNotFound},
+	}
+	for _, test := range tests {
+		if err := svc.checkCurrent(current, test.id, test.filter); err != test.expected {
+			t.Errorf("Expected result %v for %v, got %v", test.expected, test.id, err)
+		}
+	}
+
+	// stored document has _id added by database
+	replacement, _ := bson.Marshal(testDocument{"d1", 1})
+	stored, _ := bson.Marshal(bson.D{{Key: "_id", Value: "x"}, {Key: "id", Value: "d1"}, {Key: "version", Value: 1}})
+	if !svc.isReplacedBy([]bson.Raw{stored}, "d1", replacement) {
+		t.Errorf("Expected stored document to be recognized as replacement")
+	}
+	other, _ := bson.Marshal(testDocument{"d1", 2})
+	if svc.isReplacedBy([]bson.Raw{stored}, "d1", other) {
+		t.Errorf("Expected changed document not to be recognized as replacement")
+	}
+}
+
+func TestMongoUniqueIndex(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestMongoService[testDocument](t, MongoServiceConfig{})
+
+	// creation of documents with the same id is rejected by unique index
+	if err := svc.CreateDocument(ctx, "d1", &testDocument{"d1", 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.CreateDocument(ctx, "d1", &testDocument{"d1", 2}); err != ErrConflict {
+		t.Errorf("Expected conflict of document with the same id, got %v", err)
+	}
+
+	// collection with duplicate ids cannot get unique index, service of such collection fails to connect
+	client, err := svc.connect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, document := range []testDocument{{"d1", 1}, {"d1", 2}} {
+		if _, err := client.Database(svc.DbName).Collection("duplicates").InsertOne(ctx, document); err != nil {
+			t.Fatal(err)
+		}
+	}
+	duplicates := NewMongoService[testDocument](MongoServiceConfig{ServerHost: svc.ServerHost, DbName: svc.DbName, Collection: "duplicates"})
+	defer duplicates.Disconnect(ctx)
+	if err := Connect(ctx, duplicates); err == nil {
+		t.Errorf("Expected failure of unique index to fail connection")
+	}
+}
+
+func TestMongoBulkWriteAtomic(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestMongoService[testDocument](t, MongoServiceConfig{})
+	if err := Connect(ctx, svc); err != nil {
+		t.Fatal(err)
+	}
+	if !svc.transactions.Load() {
+		// standalone server rejects atomic writes up front, nothing is written
+		if _, err := svc.BulkWrite(ctx, testWriteOperations(), true); err != ErrTransactionsUnsupported {
+			t.Fatalf("Expected transactions of standalone server to be unsupported, got %v", err)
+		}
+		t.Skip("PN_REGISTRY_API_MONGODB_REPLICA_SET is not set to replica set of the test server")
+	}
+	for _, document := range []testDocument{{"d1", 1}, {"d2", 1}} {
+		if err := svc.CreateDocument(ctx, document.Id, &document); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// conflict of one operation rolls back all of them
+	results, err := svc.BulkWrite(ctx, testWriteOperations(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, result := range results {
+		if i == 1 && result != ErrConflict {
+			t.Errorf("Expected conflict of operation 1, got %v", result)
+		}
+		if i != 1 && result != ErrRolledBack {
+			t.Errorf("Expected operation %v to be rolled back, got %v", i, result)
+		}
+	}
+	if _, err := svc.FindDocument(ctx, "d3"); err != ErrNotFound {
+		t.Errorf("Expected creation of d3 to be rolled back, got %v", err)
+	}
+
+	// operations without failure are all committed
+	results, err = svc.BulkWrite(ctx, []WriteOperation[testDocument]{
+		{Kind: WriteCreate, Id: "d3", Document: &testDocument{Id: "d3", Version: 1}},
+		{Kind: WriteUpdate, Id: "d1", Document: &testDocument{Id: "d1", Version: 2}, Condition: Eq("version", 1)},
+		{Kind: WriteDelete, Id: "d2", Condition: Eq("version", 1)},
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, result := range results {
+		if result != nil {
+			t.Errorf("Expected operation %v to be committed, got %v", i, result)
+		}
+	}
+	if document, err := svc.FindDocument(ctx, "d1"); err != nil || document.Version != 2 {
+		t.Errorf("Expected updated document d1, got %+v (%v)", document, err)
+	}
+	if _, err := svc.FindDocument(ctx, "d2"); err != ErrNotFound {
+		t.Errorf("Expected deleted document d2, got %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+/*
+ * PN registry API
+ *
+ * Evidence and tracking system of sick-leave (PN) records for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: xbojko@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package pn_registry
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ApiKeysAPI interface {
+
+	// internal registration of api routes
+	addRoutes(routerGroup *gin.RouterGroup)
+
+	// CreateApiKey - Issues new API key for machine client
+	CreateApiKey(ctx *gin.Context)
+
+	// GetApiKey - Provides API key with its usage statistics
+	GetApiKey(ctx *gin.Context)
+
+	// GetApiKeys - Provides list of API keys of the tenant
+	GetApiKeys(ctx *gin.Context)
+
+	// RevokeApiKey - Revokes API key
+	RevokeApiKey(ctx *gin.Context)
+
+	// RotateApiKey - Replaces secret of API key
+	RotateApiKey(ctx *gin.Context)
+}
+
+// partial implementation of ApiKeysAPI - all functions must be implemented in add on files
+type implApiKeysAPI struct {
+}
+
+func newApiKeysAPI() ApiKeysAPI {
+	return &implApiKeysAPI{}
+}
+
+func (this *implApiKeysAPI) addRoutes(routerGroup *gin.RouterGroup) {
+	routerGroup.Handle(http.MethodPost, "/api-keys/", authorizeKeyAdmin, this.CreateApiKey)
+	routerGroup.Handle(http.MethodGet, "/api-keys/:keyId/", authorizeKeyAdmin, this.GetApiKey)
+	routerGroup.Handle(http.MethodGet, "/api-keys/", authorizeKeyAdmin, this.GetApiKeys)
+	routerGroup.Handle(http.MethodDelete, "/api-keys/:keyId/", authorizeKeyAdmin, this.RevokeApiKey)
+	routerGroup.Handle(http.MethodPost, "/api-keys/:keyId/rotate", authorizeKeyAdmin, this.RotateApiKey)
+}
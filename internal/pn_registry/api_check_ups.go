@@ -0,0 +1,50 @@
+/*
+ * PN registry API
+ *
+ * Evidence and tracking system of sick-leave (PN) records for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: xbojko@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package pn_registry
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CheckUpsAPI interface {
+
+	// internal registration of api routes
+	addRoutes(routerGroup *gin.RouterGroup)
+
+	// GetCheckUpsCalendar - Provides check ups planned in the period in iCalendar format
+	GetCheckUpsCalendar(ctx *gin.Context)
+
+	// GetCheckUpsDueThisWeek - Provides PN records with check ups due this week
+	GetCheckUpsDueThisWeek(ctx *gin.Context)
+
+	// GetCheckUpsDueToday - Provides PN records with check ups due today
+	GetCheckUpsDueToday(ctx *gin.Context)
+
+	// GetCheckUpsOverdue - Provides PN records with overdue check ups
+	GetCheckUpsOverdue(ctx *gin.Context)
+}
+
+// partial implementation of CheckUpsAPI - all functions must be implemented in add on files
+type implCheckUpsAPI struct {
+}
+
+func newCheckUpsAPI() CheckUpsAPI {
+	return &implCheckUpsAPI{}
+}
+
+func (this *implCheckUpsAPI) addRoutes(routerGroup *gin.RouterGroup) {
+	routerGroup.Handle(http.MethodGet, "/checkups.ics", authorize("GetRecordCalendar"), this.GetCheckUpsCalendar)
+	routerGroup.Handle(http.MethodGet, "/checkups/week", authorize("GetRecordAll"), this.GetCheckUpsDueThisWeek)
+	routerGroup.Handle(http.MethodGet, "/checkups/today", authorize("GetRecordAll"), this.GetCheckUpsDueToday)
+	routerGroup.Handle(http.MethodGet, "/checkups/overdue", authorize("GetRecordAll"), this.GetCheckUpsOverdue)
+}
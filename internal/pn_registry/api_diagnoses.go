@@ -0,0 +1,38 @@
+/*
+ * PN registry API
+ *
+ * Evidence and tracking system of sick-leave (PN) records for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: xbojko@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package pn_registry
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DiagnosesAPI interface {
+
+	// internal registration of api routes
+	addRoutes(routerGroup *gin.RouterGroup)
+
+	// GetDiagnoses - Searches ICD-10 diagnoses
+	GetDiagnoses(ctx *gin.Context)
+}
+
+// partial implementation of DiagnosesAPI - all functions must be implemented in add on files
+type implDiagnosesAPI struct {
+}
+
+func newDiagnosesAPI() DiagnosesAPI {
+	return &implDiagnosesAPI{}
+}
+
+func (this *implDiagnosesAPI) addRoutes(routerGroup *gin.RouterGroup) {
+	routerGroup.Handle(http.MethodGet, "/diagnoses/", this.GetDiagnoses)
+}
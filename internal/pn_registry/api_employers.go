@@ -0,0 +1,58 @@
+/*
+ * PN registry API
+ *
+ * Evidence and tracking system of sick-leave (PN) records for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: xbojko@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package pn_registry
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type EmployersAPI interface {
+
+	// internal registration of api routes
+	addRoutes(routerGroup *gin.RouterGroup)
+
+	// CreateEmployer - Saves new employer
+	CreateEmployer(ctx *gin.Context)
+
+	// DeleteEmployer - Deletes specific employer
+	DeleteEmployer(ctx *gin.Context)
+
+	// GetEmployer - Provides details about specific employer
+	GetEmployer(ctx *gin.Context)
+
+	// GetEmployerAll - Provides list of all employers
+	GetEmployerAll(ctx *gin.Context)
+
+	// GetEmployerRecords - Provides currently active PN records of employer's employees
+	GetEmployerRecords(ctx *gin.Context)
+
+	// UpdateEmployer - Updates specific employer
+	UpdateEmployer(ctx *gin.Context)
+}
+
+// partial implementation of EmployersAPI - all functions must be implemented in add on files
+type implEmployersAPI struct {
+}
+
+func newEmployersAPI() EmployersAPI {
+	return &implEmployersAPI{}
+}
+
+func (this *implEmployersAPI) addRoutes(routerGroup *gin.RouterGroup) {
+	routerGroup.Handle(http.MethodPost, "/employers/", authorize("CreateEmployer"), this.CreateEmployer)
+	routerGroup.Handle(http.MethodDelete, "/employers/:employerId/", authorize("DeleteEmployer"), this.DeleteEmployer)
+	routerGroup.Handle(http.MethodGet, "/employers/:employerId/", authorize("GetEmployer"), this.GetEmployer)
+	routerGroup.Handle(http.MethodGet, "/employers/", authorize("GetEmployerAll"), this.GetEmployerAll)
+	routerGroup.Handle(http.MethodGet, "/employers/:employerId/records", authorize("GetEmployerRecords"), this.GetEmployerRecords)
+	routerGroup.Handle(http.MethodPut, "/employers/:employerId/", authorize("UpdateEmployer"), this.UpdateEmployer)
+}
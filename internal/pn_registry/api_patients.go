@@ -0,0 +1,58 @@
+/*
+ * PN registry API
+ *
+ * Evidence and tracking system of sick-leave (PN) records for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: xbojko@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package pn_registry
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PatientsAPI interface {
+
+	// internal registration of api routes
+	addRoutes(routerGroup *gin.RouterGroup)
+
+	// CreatePatient - Saves new patient
+	CreatePatient(ctx *gin.Context)
+
+	// DeletePatient - Deletes specific patient
+	DeletePatient(ctx *gin.Context)
+
+	// GetPatient - Provides details about specific patient
+	GetPatient(ctx *gin.Context)
+
+	// GetPatientAll - Provides list of all patients
+	GetPatientAll(ctx *gin.Context)
+
+	// GetPatientRecords - Provides PN records of specific patient
+	GetPatientRecords(ctx *gin.Context)
+
+	// UpdatePatient - Updates specific patient
+	UpdatePatient(ctx *gin.Context)
+}
+
+// partial implementation of PatientsAPI - all functions must be implemented in add on files
+type implPatientsAPI struct {
+}
+
+func newPatientsAPI() PatientsAPI {
+	return &implPatientsAPI{}
+}
+
+func (this *implPatientsAPI) addRoutes(routerGroup *gin.RouterGroup) {
+	routerGroup.Handle(http.MethodPost, "/patients/", authorize("CreatePatient"), this.CreatePatient)
+	routerGroup.Handle(http.MethodDelete, "/patients/:patientId/", authorize("DeletePatient"), this.DeletePatient)
+	routerGroup.Handle(http.MethodGet, "/patients/:patientId/", authorize("GetPatient"), this.GetPatient)
+	routerGroup.Handle(http.MethodGet, "/patients/", authorize("GetPatientAll"), this.GetPatientAll)
+	routerGroup.Handle(http.MethodGet, "/patients/:patientId/records", authorize("GetPatientRecords"), this.GetPatientRecords)
+	routerGroup.Handle(http.MethodPut, "/patients/:patientId/", authorize("UpdatePatient"), this.UpdatePatient)
+}
@@ -21,18 +21,63 @@ type PnRegistryRecordsAPI interface {
 	// internal registration of api routes
 	addRoutes(routerGroup *gin.RouterGroup)
 
+	// ActivateRecord - Activates issued PN record
+	ActivateRecord(ctx *gin.Context)
+
+	// BatchRecords - Creates, updates and deletes multiple PN records at once
+	BatchRecords(ctx *gin.Context)
+
+	// CancelRecord - Cancels PN record
+	CancelRecord(ctx *gin.Context)
+
+	// CloseRecord - Closes active PN record with actual end date
+	CloseRecord(ctx *gin.Context)
+
+	// CompleteCheckUp - Marks check up of PN record as done
+	CompleteCheckUp(ctx *gin.Context)
+
+	// ContinueRecord - Saves follow-up PN record continuing specific PN record
+	ContinueRecord(ctx *gin.Context)
+
 	// CreateRecord - Saves new PN record into list of all PN records
 	CreateRecord(ctx *gin.Context)
 
 	// DeleteRecord - Deletes specific PN record
 	DeleteRecord(ctx *gin.Context)
 
+	// ExportRecords - Exports PN records matching the filters as CSV or NDJSON
+	ExportRecords(ctx *gin.Context)
+
+	// ExtendRecord - Extends validity of active PN record
+	ExtendRecord(ctx *gin.Context)
+
 	// GetRecord - Provides details about specific PN record
 	GetRecord(ctx *gin.Context)
 
 	// GetRecordAll - Provides list of all PN records
 	GetRecordAll(ctx *gin.Context)
 
+	// GetRecordCalendar - Provides validity period and check ups of specific PN record in iCalendar format
+	GetRecordCalendar(ctx *gin.Context)
+
+	// GetRecordEpisode - Provides whole sick leave episode of specific PN record
+	GetRecordEpisode(ctx *gin.Context)
+
+	// GetRecordHistory - Provides history of changes of specific PN record
+	GetRecordHistory(ctx *gin.Context)
+
+	// ImportRecords - Imports PN records from CSV or NDJSON file
+	ImportRecords(ctx *gin.Context)
+
+	// PatchRecord - Partially updates specific PN record
+	PatchRecord(ctx *gin.Context)
+
+	// RestoreRecord - Restores deleted PN record
+	RestoreRecord(ctx *gin.Context)
+
+	// ScheduleCheckUp - Plans new check up of PN record
+	ScheduleCheckUp(ctx *gin.Context)
+
 	// UpdateRecord - Updates fields of specific PN record
 	UpdateRecord(ctx *gin.Context)
 }
@@ -46,9 +91,24 @@ func newPnRegistryRecordsAPI() PnRegistryRecordsAPI {
 }
 
 func (this *implPnRegistryRecordsAPI) addRoutes(routerGroup *gin.RouterGroup) {
-	routerGroup.Handle(http.MethodPost, "/records/", this.CreateRecord)
-	routerGroup.Handle(http.MethodDelete, "/records/:recordId/", this.DeleteRecord)
-	routerGroup.Handle(http.MethodGet, "/records/:recordId/", this.GetRecord)
-	routerGroup.Handle(http.MethodGet, "/records/", this.GetRecordAll)
-	routerGroup.Handle(http.MethodPut, "/records/:recordId/", this.UpdateRecord)
+	routerGroup.Handle(http.MethodPost, "/records/:recordId/activate", authorize("ActivateRecord"), this.ActivateRecord)
+	routerGroup.Handle(http.MethodPost, "/records/batch", authorize("BatchRecords"), this.BatchRecords)
+	routerGroup.Handle(http.MethodPost, "/records/:recordId/cancel", authorize("CancelRecord"), this.CancelRecord)
+	routerGroup.Handle(http.MethodPost, "/records/:recordId/close", authorize("CloseRecord"), this.CloseRecord)
+	routerGroup.Handle(http.MethodPost, "/records/:recordId/checkups/:date/done", authorize("CompleteCheckUp"), this.CompleteCheckUp)
+	routerGroup.Handle(http.MethodPost, "/records/:recordId/continuation", authorize("ContinueRecord"), this.ContinueRecord)
+	routerGroup.Handle(http.MethodPost, "/records/", authorize("CreateRecord"), this.CreateRecord)
+	routerGroup.Handle(http.MethodDelete, "/records/:recordId/", authorize("DeleteRecord"), this.DeleteRecord)
+	routerGroup.Handle(http.MethodGet, "/records/export", authorize("ExportRecords"), this.ExportRecords)
+	routerGroup.Handle(http.MethodPost, "/records/:recordId/extend", authorize("ExtendRecord"), this.ExtendRecord)
+	routerGroup.Handle(http.MethodGet, "/records/:recordId/", authorize("GetRecord"), this.GetRecord)
+	routerGroup.Handle(http.MethodGet, "/records/", authorize("GetRecordAll"), this.GetRecordAll)
+	routerGroup.Handle(http.MethodGet, "/records/:recordId", recordCalendarPath, authorize("GetRecordCalendar"), this.GetRecordCalendar)
+	routerGroup.Handle(http.MethodGet, "/records/:recordId/episode", authorize("GetRecordEpisode"), this.GetRecordEpisode)
+	routerGroup.Handle(http.MethodGet, "/records/:recordId/history", authorize("GetRecordHistory"), this.GetRecordHistory)
+	routerGroup.Handle(http.MethodPost, "/records/import", authorize("ImportRecords"), this.ImportRecords)
+	routerGroup.Handle(http.MethodPatch, "/records/:recordId/", authorize("PatchRecord"), this.PatchRecord)
+	routerGroup.Handle(http.MethodPost, "/records/:recordId/restore", authorize("RestoreRecord"), this.RestoreRecord)
+	routerGroup.Handle(http.MethodPost, "/records/:recordId/checkups", authorize("ScheduleCheckUp"), this.ScheduleCheckUp)
+	routerGroup.Handle(http.MethodPut, "/records/:recordId/", authorize("UpdateRecord"), this.UpdateRecord)
 }
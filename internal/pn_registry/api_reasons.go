@@ -0,0 +1,38 @@
+/*
+ * PN registry API
+ *
+ * Evidence and tracking system of sick-leave (PN) records for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: xbojko@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package pn_registry
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ReasonsAPI interface {
+
+	// internal registration of api routes
+	addRoutes(routerGroup *gin.RouterGroup)
+
+	// GetReasons - Provides catalog of reasons of PN records
+	GetReasons(ctx *gin.Context)
+}
+
+// partial implementation of ReasonsAPI - all functions must be implemented in add on files
+type implReasonsAPI struct {
+}
+
+func newReasonsAPI() ReasonsAPI {
+	return &implReasonsAPI{}
+}
+
+func (this *implReasonsAPI) addRoutes(routerGroup *gin.RouterGroup) {
+	routerGroup.Handle(http.MethodGet, "/reasons/", this.GetReasons)
+}
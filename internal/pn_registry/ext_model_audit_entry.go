@@ -0,0 +1,58 @@
+package pn_registry
+
+import (
+	"encoding/json"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// custom bson unmarshaling of field changes, values are stored as JSON, but changes stored
+// before that have values stored as generic documents, which are converted to JSON when read
+func (c *FieldChange) UnmarshalBSON(data []byte) error {
+	var stored struct {
+		Field  string        `bson:"field"`
+		Before bson.RawValue `bson:"before"`
+		After  bson.RawValue `bson:"after"`
+	}
+	if err := bson.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+
+	before, err := fieldChangeValue(stored.Before)
+	if err != nil {
+		return err
+	}
+	after, err := fieldChangeValue(stored.After)
+	if err != nil {
+		return err
+	}
+
+	*c = FieldChange{Field: stored.Field, Before: before, After: after}
+	return nil
+}
+
+func fieldChangeValue(value bson.RawValue) (json.RawMessage, error) {
+	switch value.Type {
+	case 0, bsontype.Null, bsontype.Undefined:
+		return nil, nil
+	case bsontype.Binary:
+		_, data, ok := value.BinaryOK()
+		if ok {
+			return data, nil
+		}
+	}
+
+	// value stored as generic document, array or scalar
+	data, err := bson.MarshalExtJSON(bson.D{{Key: "value", Value: value}}, false, false)
+	if err != nil {
+		return nil, err
+	}
+	var wrapper struct {
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(data, &wrapper); err != nil {
+		return nil, err
+	}
+	return wrapper.Value, nil
+}
@@ -0,0 +1,32 @@
+package pn_registry
+
+import (
+	"github.com/go-playground/validator/v10"
+)
+
+// fills data derived from patient's ID when it is valid birth number, birth date is derived only when not provided
+func (p *Patient) deriveFromBirthNumber() {
+	info, err := ParseBirthNumber(p.Id)
+	if err != nil {
+		p.Sex = ""
+		return
+	}
+
+	p.Sex = info.Sex
+	if p.BirthDate == nil {
+		p.BirthDate = &info.BirthDate
+	}
+}
+
+// custom struct validator for patients in birth number validation mode - birth date must correspond to birth number
+func PatientBirthNumberValidator(sl validator.StructLevel) {
+	patient := sl.Current().Interface().(Patient)
+	if patient.BirthDate == nil {
+		return
+	}
+
+	info, err := ParseBirthNumber(patient.Id)
+	if err == nil && info.BirthDate != *patient.BirthDate {
+		sl.ReportError(patient.BirthDate, "BirthDate", "BirthDate", "birth-date-of-birth-number", "")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/bmathus/pnregistry-webapi/internal/db_service"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/bsontype"
 )
@@ -34,17 +35,27 @@ func (d *DateType) UnmarshalJSON(b []byte) error {
 		return errors.New("Date string is empty")
 	}
 
+	t, err := ParseDate(s)
+	if err != nil {
+		return err
+	}
+
+	*d = t
+	return nil
+}
+
+// parses and validates date in YYYY-MM-DD format
+func ParseDate(s string) (DateType, error) {
 	t, err := time.Parse(dateFormat, s)
 	if err != nil {
-		return fmt.Errorf("Invalid date format, must be YYYY-MM-DD: %w", err)
+		return DateType{}, fmt.Errorf("Invalid date format, must be YYYY-MM-DD: %w", err)
 	}
 
 	if t.Before(time.Date(0001, 1, 2, 0, 0, 0, 0, time.UTC)) || t.After(time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC)) {
-		return errors.New("Date is out of range, must be between 0001-01-02 and 9999-12-31")
+		return DateType{}, errors.New("Date is out of range, must be between 0001-01-02 and 9999-12-31")
 	}
 
-	*d = DateType(t)
-	return nil
+	return DateType(t), nil
 }
 
 // custom bson marshaling and unmarshaling
@@ -61,6 +72,16 @@ func (d *DateType) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
 	return nil
 }
 
+// custom bson unmarshaling of records, records stored with single check-up are read with list of check-ups
+func (r *Record) UnmarshalBSON(data []byte) error {
+	type storedRecord Record
+	if err := bson.Unmarshal(data, (*storedRecord)(r)); err != nil {
+		return err
+	}
+	r.normalizeCheckUps()
+	return nil
+}
+
 // helper functions with dates
 func (d DateType) String() string {
 	return time.Time(d).Format(dateFormat)
@@ -69,3 +90,23 @@ func (d DateType) String() string {
 func (d DateType) After(u DateType) bool {
 	return time.Time(d).After(time.Time(u))
 }
+
+func (d DateType) AddDays(days int) DateType {
+	return DateType(time.Time(d).AddDate(0, 0, days))
+}
+
+// number of days from the date to date u
+func (d DateType) DaysTo(u DateType) int {
+	return int(time.Time(u).Sub(time.Time(d)).Hours() / 24)
+}
+
+// current date (in UTC) without time part
+func Today() DateType {
+	year, month, day := time.Now().UTC().Date()
+	return DateType(time.Date(year, month, day, 0, 0, 0, 0, time.UTC))
+}
+
+// condition matching records which were not (soft) deleted
+func notDeleted() db_service.Filter {
+	return db_service.Exists("deleted", false)
+}
@@ -0,0 +1,233 @@
+package pn_registry
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bmathus/pnregistry-webapi/internal/auth"
+	"github.com/bmathus/pnregistry-webapi/internal/db_service"
+	"github.com/bmathus/pnregistry-webapi/internal/tenant"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var registerValidatorsOnce sync.Once
+
+// registers custom validators in the same way as main of the service
+func registerTestValidators() {
+	registerValidatorsOnce.Do(func() {
+		v := binding.Validator.Engine().(*validator.Validate)
+		v.RegisterValidation("only-digits-max-length-10", PatientIDValidator)
+		v.RegisterValidation("max-length-50", MaxLengthValidator)
+		v.RegisterValidation("not-valid-reason-value", ReasonValidator)
+		v.RegisterValidation("company-id", CompanyIDValidator)
+		v.RegisterValidation("icd10-code", DiagnosisFormatValidator)
+	})
+}
+
+// in-memory services of single tenant, which are put into the context in the same way as by main of the service
+type testServices struct {
+	records    db_service.DbService[Record]
+	audit      db_service.DbService[AuditEntry]
+	patients   db_service.DbService[Patient]
+	employers  db_service.DbService[Employer]
+	encryption *db_service.FieldEncryption // encryption of records, nil when records are not encrypted
+}
+
+func newTestServices() *testServices {
+	return &testServices{
+		records:   db_service.NewMemoryService[Record](db_service.MemoryServiceConfig{}),
+		audit:     db_service.NewMemoryService[AuditEntry](db_service.MemoryServiceConfig{}),
+		patients:  db_service.NewMemoryService[Patient](db_service.MemoryServiceConfig{}),
+		employers: db_service.NewMemoryService[Employer](db_service.MemoryServiceConfig{}),
+	}
+}
+
+// services of single tenant stored in separate MongoDB database of the test, which is dropped after the test,
+// the test is skipped unless PN_REGISTRY_API_TEST_MONGODB_HOST is set to host of mongo server, other settings
+// of the connection are taken from the same environment variables as in the service (PN_REGISTRY_API_MONGODB_*)
+func newMongoTestServices(t *testing.T) *testServices {
+	t.Helper()
+	host := os.Getenv("PN_REGISTRY_API_TEST_MONGODB_HOST")
+	if host == "" {
+		t.Skip("PN_REGISTRY_API_TEST_MONGODB_HOST is not set")
+	}
+
+	dbName := "pn-registry-test-" + uuid.NewString()
+	config := func(collection string) db_service.MongoServiceConfig {
+		return db_service.MongoServiceConfig{ServerHost: host, DbName: dbName, Collection: collection}
+	}
+	services := &testServices{
+		records:   db_service.NewMongoService[Record](config("record")),
+		audit:     db_service.NewMongoService[AuditEntry](config("record_audit")),
+		patients:  db_service.NewMongoService[Patient](config("patient")),
+		employers: db_service.NewMongoService[Employer](config("employer")),
+	}
+	t.Cleanup(func() {
+		ctx := context.Background()
+		services.records.Disconnect(ctx)
+		services.audit.Disconnect(ctx)
+		services.patients.Disconnect(ctx)
+		services.employers.Disconnect(ctx)
+		dropTestDatabase(t, host, dbName)
+	})
+	return services
+}
+
+// drops database of the test with own connection, because services do not expose their client
+func dropTestDatabase(t *testing.T, host string, dbName string) {
+	ctx := context.Background()
+	credentials := ""
+	if user := os.Getenv("PN_REGISTRY_API_MONGODB_USERNAME"); user != "" {
+		credentials = user + ":" + os.Getenv("PN_REGISTRY_API_MONGODB_PASSWORD") + "@"
+	}
+	uri := fmt.Sprintf("mongodb://%v%v:%v/?directConnection=true", credentials, host, cmp.Or(os.Getenv("PN_REGISTRY_API_MONGODB_PORT"), "27017"))
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Errorf("Failed to connect to drop test database %v: %v", dbName, err)
+		return
+	}
+	defer client.Disconnect(ctx)
+	if err := client.Database(dbName).Drop(ctx); err != nil {
+		t.Errorf("Failed to drop test database %v: %v", dbName, err)
+	}
+}
+
+func (this *testServices) middleware(ctx *gin.Context) {
+	ctx.Set("db_service", this.records)
+	ctx.Set("audit_service", this.audit)
+	ctx.Set("patient_service", this.patients)
+	ctx.Set("employer_service", this.employers)
+	ctx.Set("record_encryption", this.encryption)
+	ctx.Next()
+}
+
+// creates engine with routes of the API and in-memory services
+func newTestEngine(services *testServices, middleware ...gin.HandlerFunc) *gin.Engine {
+	registerTestValidators()
+	gin.SetMode(gin.TestMode)
+
+	engine := gin.New()
+	AddRoutes(engine, append(middleware, services.middleware)...)
+	return engine
+}
+
+// creates engine with routes of the API and in-memory services of every tenant, tenant of the request
+// is resolved from header X-Tenant-ID (or tenant claim of identity set by the middleware) as by main of the service
+func newTenantTestEngine(tenants map[string]*testServices, middleware ...gin.HandlerFunc) *gin.Engine {
+	registerTestValidators()
+	gin.SetMode(gin.TestMode)
+
+	tenantIds := []string{}
+	for tenantId := range tenants {
+		tenantIds = append(tenantIds, tenantId)
+	}
+	resolveTenant := tenant.Middleware(tenant.Config{
+		Tenants: tenantIds,
+		Claim:   "tenant",
+		Header:  "X-Tenant-ID",
+	})
+	tenantServices := func(ctx *gin.Context) {
+		tenants[tenant.FromContext(ctx)].middleware(ctx)
+	}
+
+	engine := gin.New()
+	AddRoutes(engine, append(middleware, resolveTenant, tenantServices)...)
+	return engine
+}
+
+// sets identity of the caller with roles from header X-Test-Roles, employer from header X-Test-Employer
+// and tenant from header X-Tenant-ID, in the same way as authentication middleware sets identity from the bearer token
+func testIdentity(ctx *gin.Context) {
+	roles := ctx.GetHeader("X-Test-Roles")
+	if roles != "" {
+		ctx.Set("identity", auth.Identity{
+			Subject: "test",
+			Roles:   strings.Split(roles, ","),
+			Claims: map[string]interface{}{
+				"employer_id": ctx.GetHeader("X-Test-Employer"),
+				"tenant":      ctx.GetHeader("X-Tenant-ID"),
+			},
+		})
+	}
+	ctx.Next()
+}
+
+// sends request with JSON body (unless body is nil or already raw) and returns recorded response
+func doRequest(engine *gin.Engine, method string, path string, body interface{}, headers map[string]string) *httptest.ResponseRecorder {
+	var data []byte
+	switch body := body.(type) {
+	case nil:
+	case []byte:
+		data = body
+	case string:
+		data = []byte(body)
+	default:
+		data, _ = json.Marshal(body)
+	}
+
+	request := httptest.NewRequest(method, path, bytes.NewReader(data))
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// decodes JSON body of the response, fails the test when the body can not be decoded
+func decodeResponse[T interface{}](t *testing.T, recorder *httptest.ResponseRecorder) T {
+	t.Helper()
+	var result T
+	if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to decode response %q: %v", recorder.Body.String(), err)
+	}
+	return result
+}
+
+// fails the test when the response does not have expected status
+func expectStatus(t *testing.T, recorder *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if recorder.Code != status {
+		t.Fatalf("Expected status %v, got %v: %s", status, recorder.Code, recorder.Body.String())
+	}
+}
+
+// valid record of the patient, which can be changed by the test before it is sent
+func newTestRecord(id string, patientId string, validFrom string, validUntil string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":         id,
+		"fullName":   "Jozef Mrkvicka",
+		"patientId":  patientId,
+		"employer":   "Stavby s.r.o.",
+		"reason":     Choroba,
+		"issued":     validFrom,
+		"validFrom":  validFrom,
+		"validUntil": validUntil,
+	}
+}
+
+// creates the record through the API, fails the test when the record is not created
+func createTestRecord(t *testing.T, engine *gin.Engine, record map[string]interface{}) Record {
+	t.Helper()
+	recorder := doRequest(engine, http.MethodPost, "/api/records/", record, nil)
+	expectStatus(t, recorder, http.StatusCreated)
+	return decodeResponse[Record](t, recorder)
+}
@@ -0,0 +1,305 @@
+package pn_registry
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bmathus/pnregistry-webapi/internal/db_service"
+	"github.com/bmathus/pnregistry-webapi/internal/tenant"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maximal grace period of previous key of rotated API key
+const apiKeyMaxGraceMinutes = 7 * 24 * 60
+
+// CreateApiKey - Issues new API key for machine client
+func (this *implApiKeysAPI) CreateApiKey(ctx *gin.Context) {
+	request := ApiKeyRequest{}
+
+	// Fields validation
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "Invalid request body",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+
+	if recordAccessPolicy != nil {
+		for _, scope := range request.Scopes {
+			if _, exists := recordAccessPolicy.Roles[scope]; !exists {
+				ctx.JSON(http.StatusBadRequest,
+					gin.H{
+						"status":  "Bad Request",
+						"message": "Invalid request body",
+						"error":   fmt.Sprintf("Scope '%s' is not a role of access policy", scope),
+					},
+				)
+				return
+			}
+		}
+	}
+
+	now := time.Now().UTC()
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(now) {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "Invalid request body",
+				"error":   "'Expires At' must be in the future",
+			},
+		)
+		return
+	}
+
+	apiKey := ApiKey{
+		Id:        uuid.New().String(),
+		Name:      request.Name,
+		Scopes:    request.Scopes,
+		Claims:    request.Claims,
+		Tenant:    tenant.FromContext(ctx),
+		ExpiresAt: request.ExpiresAt,
+		Created:   now,
+		CreatedBy: auditActor(ctx),
+	}
+	key, hash, err := newApiKey(apiKey.Id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "Failed to generate API key",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+	apiKey.Hash = hash
+	apiKey.Prefix = apiKeyHint(key)
+
+	if err := apiKeys.keyDb.CreateDocument(ctx, apiKey.Id, &apiKey); err != nil {
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to create API key in database",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, ApiKeySecret{Key: key, ApiKey: apiKey})
+}
+
+// GetApiKey - Provides API key with its usage statistics
+func (this *implApiKeysAPI) GetApiKey(ctx *gin.Context) {
+	apiKey, found := this.findTenantKey(ctx, ctx.Param("keyId"))
+	if !found {
+		return
+	}
+
+	usage, err := apiKeys.Usage(ctx, apiKey.Id)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to load usage of API key from database",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+	apiKey.Usage = usage
+
+	ctx.JSON(http.StatusOK, apiKey)
+}
+
+// GetApiKeys - Provides list of API keys of the tenant
+func (this *implApiKeysAPI) GetApiKeys(ctx *gin.Context) {
+	keys, _, err := apiKeys.keyDb.QueryDocuments(ctx, db_service.Query{
+		Filter: db_service.Eq("tenant", tenant.FromContext(ctx)),
+		Sort:   []db_service.SortField{{Field: "created"}},
+	})
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to load API keys from database",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+
+	for i := range keys {
+		usage, err := apiKeys.Usage(ctx, keys[i].Id)
+		if err != nil {
+			ctx.JSON(http.StatusBadGateway,
+				gin.H{
+					"status":  "Bad Gateway",
+					"message": "Failed to load usage of API key from database",
+					"error":   err.Error(),
+				},
+			)
+			return
+		}
+		keys[i].Usage = usage
+	}
+
+	ctx.JSON(http.StatusOK, keys)
+}
+
+// RevokeApiKey - Revokes API key
+func (this *implApiKeysAPI) RevokeApiKey(ctx *gin.Context) {
+	apiKey, found := this.findTenantKey(ctx, ctx.Param("keyId"))
+	if !found {
+		return
+	}
+
+	// revoked key is kept, so that its usage and revocation can be reviewed
+	if apiKey.Revoked != nil {
+		ctx.AbortWithStatus(http.StatusNoContent)
+		return
+	}
+
+	now := time.Now().UTC()
+	condition := db_service.Eq("hash", apiKey.Hash)
+	apiKey.Revoked = &now
+	apiKey.RevokedBy = auditActor(ctx)
+	if !this.saveApiKey(ctx, apiKey, condition) {
+		return
+	}
+
+	ctx.AbortWithStatus(http.StatusNoContent)
+}
+
+// RotateApiKey - Replaces secret of API key
+func (this *implApiKeysAPI) RotateApiKey(ctx *gin.Context) {
+	graceMinutes, err := strconv.Atoi(ctx.DefaultQuery("graceMinutes", "0"))
+	if err != nil || graceMinutes < 0 || graceMinutes > apiKeyMaxGraceMinutes {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "Invalid query parameter",
+				"error":   fmt.Sprintf("'graceMinutes' must be number of minutes between 0 and %v", apiKeyMaxGraceMinutes),
+			},
+		)
+		return
+	}
+
+	apiKey, found := this.findTenantKey(ctx, ctx.Param("keyId"))
+	if !found {
+		return
+	}
+
+	if apiKey.Revoked != nil {
+		ctx.JSON(http.StatusConflict,
+			gin.H{
+				"status":  "Conflict",
+				"message": "Revoked API key cannot be rotated",
+			},
+		)
+		return
+	}
+
+	key, hash, err := newApiKey(apiKey.Id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "Failed to generate API key",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+
+	// previous key remains valid during grace period, so that clients can switch to the new key
+	now := time.Now().UTC()
+	condition := db_service.Eq("hash", apiKey.Hash)
+	apiKey.PreviousHash, apiKey.PreviousValidUntil = "", nil
+	if graceMinutes > 0 {
+		validUntil := now.Add(time.Duration(graceMinutes) * time.Minute)
+		apiKey.PreviousHash, apiKey.PreviousValidUntil = apiKey.Hash, &validUntil
+	}
+	apiKey.Hash = hash
+	apiKey.Prefix = apiKeyHint(key)
+	apiKey.Rotated = &now
+	if !this.saveApiKey(ctx, apiKey, condition) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, ApiKeySecret{Key: key, ApiKey: *apiKey})
+}
+
+// Utility function which loads API key of the tenant of the request, keys of other tenants are reported
+// as not found, writes error response and returns false when the key cannot be loaded
+func (this *implApiKeysAPI) findTenantKey(ctx *gin.Context, keyId string) (*ApiKey, bool) {
+	apiKey, err := apiKeys.keyDb.FindDocument(ctx, keyId)
+	if err == nil && apiKey.Tenant != tenant.FromContext(ctx) {
+		err = db_service.ErrNotFound
+	}
+
+	switch err {
+	case nil:
+		return apiKey, true
+	case db_service.ErrNotFound:
+		ctx.JSON(http.StatusNotFound,
+			gin.H{
+				"status":  "Not Found",
+				"message": "API key with specified ID not found",
+				"error":   err.Error(),
+			},
+		)
+	default:
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to load API key from database",
+				"error":   err.Error(),
+			},
+		)
+	}
+	return nil, false
+}
+
+// Utility function which stores changed API key when its secret was not changed meanwhile,
+// writes error response and returns false when the key was not stored
+func (this *implApiKeysAPI) saveApiKey(ctx *gin.Context, apiKey *ApiKey, condition db_service.Filter) bool {
+	err := apiKeys.keyDb.UpdateDocumentIf(ctx, apiKey.Id, condition, apiKey)
+
+	switch err {
+	case nil:
+		return true
+	case db_service.ErrConditionFailed:
+		ctx.JSON(http.StatusConflict,
+			gin.H{
+				"status":  "Conflict",
+				"message": "API key was modified by another request, load it and try again",
+				"error":   err.Error(),
+			},
+		)
+	case db_service.ErrNotFound:
+		ctx.JSON(http.StatusNotFound,
+			gin.H{
+				"status":  "Not Found",
+				"message": "API key with specified ID not found",
+				"error":   err.Error(),
+			},
+		)
+	default:
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to update API key in database",
+				"error":   err.Error(),
+			},
+		)
+	}
+	return false
+}
@@ -0,0 +1,222 @@
+package pn_registry
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bmathus/pnregistry-webapi/internal/db_service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// enables API keys stored in memory for the duration of the test, without background job storing usage
+func enableTestApiKeys(t *testing.T) {
+	t.Helper()
+	apiKeys = &apiKeyStore{
+		config:  ApiKeyConfig{TenantClaim: "tenant", AdminRole: "admin", FlushInterval: time.Minute},
+		keyDb:   db_service.NewMemoryService[ApiKey](db_service.MemoryServiceConfig{}),
+		usageDb: db_service.NewMemoryService[ApiKeyUsage](db_service.MemoryServiceConfig{}),
+		pending: map[string]*ApiKeyUsage{},
+	}
+	t.Cleanup(func() { apiKeys = nil })
+}
+
+// sets identity of the client authenticated by header X-API-Key, in the same way as authentication middleware
+func testApiKeyIdentity(ctx *gin.Context) {
+	if key := ctx.GetHeader("X-API-Key"); key != "" {
+		identity, err := apiKeys.VerifyKey(ctx, key)
+		if err != nil {
+			ctx.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		ctx.Set("identity", identity)
+	}
+	ctx.Next()
+}
+
+func TestApiKeysTenantIsolation(t *testing.T) {
+	enableTestApiKeys(t)
+	tenants := map[string]*testServices{"a": newTestServices(), "b": newTestServices()}
+	engine := newTenantTestEngine(tenants, testIdentity, testApiKeyIdentity)
+	adminA := map[string]string{"X-Test-Roles": "admin", "X-Tenant-ID": "a"}
+	adminB := map[string]string{"X-Test-Roles": "admin", "X-Tenant-ID": "b"}
+
+	request := map[string]interface{}{"name": "Mzdovy system", "scopes": []string{"hr"}}
+	recorder := doRequest(engine, http.MethodPost, "/api/api-keys/", request, adminA)
+	expectStatus(t, recorder, http.StatusCreated)
+	secret := decodeResponse[ApiKeySecret](t, recorder)
+	keyPath := "/api/api-keys/" + secret.ApiKey.Id + "/"
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+	}{
+		{"read key", http.MethodGet, keyPath},
+		{"rotate key", http.MethodPost, keyPath + "rotate"},
+		{"revoke key", http.MethodDelete, keyPath},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expectStatus(t, doRequest(engine, test.method, test.path, nil, adminB), http.StatusNotFound)
+		})
+	}
+
+	recorder = doRequest(engine, http.MethodGet, "/api/api-keys/", nil, adminB)
+	expectStatus(t, recorder, http.StatusOK)
+	if keys := decodeResponse[[]ApiKey](t, recorder); len(keys) != 0 {
+		t.Errorf("Expected no API keys of tenant b, got %+v", keys)
+	}
+
+	// the key authenticates its client only within tenant of its issuer
+	expectStatus(t, doRequest(engine, http.MethodGet, "/api/records/", nil, map[string]string{"X-API-Key": secret.Key}), http.StatusOK)
+	expectStatus(t, doRequest(engine, http.MethodGet, "/api/records/", nil, map[string]string{"X-API-Key": secret.Key, "X-Tenant-ID": "a"}), http.StatusOK)
+	expectStatus(t, doRequest(engine, http.MethodGet, "/api/records/", nil, map[string]string{"X-API-Key": secret.Key, "X-Tenant-ID": "b"}), http.StatusForbidden)
+
+	recorder = doRequest(engine, http.MethodGet, keyPath, nil, adminA)
+	expectStatus(t, recorder, http.StatusOK)
+	if apiKey := decodeResponse[ApiKey](t, recorder); apiKey.Revoked != nil || apiKey.Rotated != nil {
+		t.Errorf("Expected API key of tenant a unchanged, got %+v", apiKey)
+	}
+}
+
+// issues API key through the API, fails the test when the key is not issued
+func createTestApiKey(t *testing.T, engine *gin.Engine, headers map[string]string) ApiKeySecret {
+	t.Helper()
+	request := map[string]interface{}{"name": "Mzdovy system", "scopes": []string{"hr"}}
+	recorder := doRequest(engine, http.MethodPost, "/api/api-keys/", request, headers)
+	expectStatus(t, recorder, http.StatusCreated)
+	return decodeResponse[ApiKeySecret](t, recorder)
+}
+
+func TestCreateApiKey(t *testing.T) {
+	enableTestApiKeys(t)
+	engine := newTestEngine(newTestServices(), testIdentity)
+	admin := map[string]string{"X-Test-Roles": "admin"}
+
+	secret := createTestApiKey(t, engine, admin)
+	if !strings.HasPrefix(secret.Key, secret.ApiKey.Prefix) || secret.ApiKey.CreatedBy != "test" {
+		t.Errorf("Expected issued key with its hint, got %+v", secret)
+	}
+	stored, err := apiKeys.keyDb.FindDocument(context.Background(), secret.ApiKey.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only hash of the key is stored
+	if stored.Hash != hashApiKey(secret.Key) || strings.Contains(stored.Hash, secret.Key) {
+		t.Errorf("Expected hash of the key to be stored, got %+v", stored)
+	}
+	if _, err := apiKeys.VerifyKey(context.Background(), secret.Key); err != nil {
+		t.Errorf("Expected issued key to be valid, got %v", err)
+	}
+
+	loadTestAccessPolicy(t)
+	tests := []struct {
+		name    string
+		request map[string]interface{}
+	}{
+		{"without scopes", map[string]interface{}{"name": "Mzdovy system", "scopes": []string{}}},
+		{"scope not in access policy", map[string]interface{}{"name": "Mzdovy system", "scopes": []string{"superuser"}}},
+		{"expired", map[string]interface{}{"name": "Mzdovy system", "scopes": []string{"hr"}, "expiresAt": time.Now().Add(-time.Minute)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expectStatus(t, doRequest(engine, http.MethodPost, "/api/api-keys/", test.request, admin), http.StatusBadRequest)
+		})
+	}
+}
+
+func TestRotateApiKey(t *testing.T) {
+	enableTestApiKeys(t)
+	engine := newTestEngine(newTestServices(), testIdentity, testApiKeyIdentity)
+	admin := map[string]string{"X-Test-Roles": "admin"}
+	first := createTestApiKey(t, engine, admin)
+	rotatePath := "/api/api-keys/" + first.ApiKey.Id + "/rotate"
+	verify := func(key string, valid bool) {
+		t.Helper()
+		if _, err := apiKeys.VerifyKey(context.Background(), key); (err == nil) != valid {
+			t.Errorf("Expected key valid %v, got %v", valid, err)
+		}
+	}
+
+	for _, graceMinutes := range []string{"-1", "abc", strconv.Itoa(apiKeyMaxGraceMinutes + 1)} {
+		expectStatus(t, doRequest(engine, http.MethodPost, rotatePath+"?graceMinutes="+graceMinutes, nil, admin), http.StatusBadRequest)
+	}
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/api-keys/"+uuid.New().String()+"/rotate", nil, admin), http.StatusNotFound)
+
+	// previous key remains valid during grace period
+	recorder := doRequest(engine, http.MethodPost, rotatePath+"?graceMinutes=60", nil, admin)
+	expectStatus(t, recorder, http.StatusOK)
+	second := decodeResponse[ApiKeySecret](t, recorder)
+	if second.ApiKey.Id != first.ApiKey.Id || second.Key == first.Key || second.ApiKey.Rotated == nil || second.ApiKey.PreviousValidUntil == nil {
+		t.Errorf("Expected new key of the same API key with grace period, got %+v", second.ApiKey)
+	}
+	verify(first.Key, true)
+	verify(second.Key, true)
+	expectStatus(t, doRequest(engine, http.MethodGet, "/api/records/", nil, map[string]string{"X-API-Key": first.Key}), http.StatusOK)
+
+	// rotation without grace period invalidates both previous keys immediately
+	recorder = doRequest(engine, http.MethodPost, rotatePath, nil, admin)
+	expectStatus(t, recorder, http.StatusOK)
+	third := decodeResponse[ApiKeySecret](t, recorder)
+	if third.ApiKey.PreviousValidUntil != nil {
+		t.Errorf("Expected no grace period, got %+v", third.ApiKey)
+	}
+	verify(first.Key, false)
+	verify(second.Key, false)
+	verify(third.Key, true)
+	expectStatus(t, doRequest(engine, http.MethodGet, "/api/records/", nil, map[string]string{"X-API-Key": second.Key}), http.StatusUnauthorized)
+}
+
+// service of API keys which rotates the key of the request right before it is stored, as if other request rotated it meanwhile
+type concurrentRotateService struct {
+	db_service.DbService[ApiKey]
+}
+
+func (this concurrentRotateService) UpdateDocumentIf(ctx context.Context, id string, condition db_service.Filter, document *ApiKey) error {
+	stored, err := this.DbService.FindDocument(ctx, id)
+	if err != nil {
+		return err
+	}
+	_, stored.Hash, _ = newApiKey(id)
+	if err := this.DbService.UpdateDocument(ctx, id, stored); err != nil {
+		return err
+	}
+	return this.DbService.UpdateDocumentIf(ctx, id, condition, document)
+}
+
+func TestRevokeApiKey(t *testing.T) {
+	enableTestApiKeys(t)
+	engine := newTestEngine(newTestServices(), testIdentity)
+	admin := map[string]string{"X-Test-Roles": "admin"}
+	secret := createTestApiKey(t, engine, admin)
+	keyPath := "/api/api-keys/" + secret.ApiKey.Id + "/"
+
+	expectStatus(t, doRequest(engine, http.MethodDelete, keyPath, nil, admin), http.StatusNoContent)
+	if _, err := apiKeys.VerifyKey(context.Background(), secret.Key); err == nil || !strings.Contains(err.Error(), "revoked") {
+		t.Errorf("Expected revoked key to be rejected, got %v", err)
+	}
+
+	// revoked key is kept with its revocation
+	recorder := doRequest(engine, http.MethodGet, keyPath, nil, admin)
+	expectStatus(t, recorder, http.StatusOK)
+	revoked := decodeResponse[ApiKey](t, recorder)
+	if revoked.Revoked == nil || revoked.RevokedBy != "test" {
+		t.Errorf("Expected revoked key, got %+v", revoked)
+	}
+	expectStatus(t, doRequest(engine, http.MethodDelete, keyPath, nil, admin), http.StatusNoContent)
+	expectStatus(t, doRequest(engine, http.MethodPost, keyPath+"rotate", nil, admin), http.StatusConflict)
+	expectStatus(t, doRequest(engine, http.MethodDelete, "/api/api-keys/"+uuid.New().String()+"/", nil, admin), http.StatusNotFound)
+
+	// key rotated meanwhile is not revoked, so that the caller can review the new state
+	other := createTestApiKey(t, engine, admin)
+	apiKeys.keyDb = concurrentRotateService{apiKeys.keyDb}
+	expectStatus(t, doRequest(engine, http.MethodDelete, "/api/api-keys/"+other.ApiKey.Id+"/", nil, admin), http.StatusConflict)
+	if stored, err := apiKeys.keyDb.FindDocument(context.Background(), other.ApiKey.Id); err != nil || stored.Revoked != nil {
+		t.Errorf("Expected key rotated meanwhile not to be revoked, got %+v: %v", stored, err)
+	}
+}
@@ -0,0 +1,207 @@
+package pn_registry
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/bmathus/pnregistry-webapi/internal/db_service"
+	"github.com/gin-gonic/gin"
+)
+
+// maximum length of period of check ups calendar
+const maxCalendarDays = 366
+
+// GetCheckUpsCalendar - Provides check ups planned in the period in iCalendar format
+func (this *implCheckUpsAPI) GetCheckUpsCalendar(ctx *gin.Context) {
+	value, exists := ctx.Get("db_service")
+	if !exists {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db not found",
+				"error":   "db not found",
+			})
+		return
+	}
+
+	db, ok := value.(db_service.DbService[Record])
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db_service context is not of type db_service.DbService",
+				"error":   "cannot cast db_service context to db_service.DbService",
+			})
+		return
+	}
+
+	// Period starts today and lasts 90 days, unless other period is requested
+	from, to, err := parseCalendarPeriod(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "Invalid query parameter",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+
+	filters := []db_service.Filter{
+		notDeleted(),
+		db_service.Ne("status", StatusCancelled),
+		checkUpInPeriodCondition(from, to),
+	}
+	for _, field := range []string{"patientId", "employerId"} {
+		if value := ctx.Query(field); value != "" {
+			filters = append(filters, db_service.Eq(field, value))
+		}
+	}
+
+	records, _, err := db.QueryDocuments(ctx, db_service.Query{Filter: db_service.And(filters...)})
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to load records from database",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+
+	events := []calendarEvent{}
+	for _, record := range records {
+		for _, checkUp := range record.CheckUps {
+			if !from.After(checkUp.Date) && !checkUp.Date.After(to) {
+				events = append(events, recordCheckUpEvent(record, checkUp))
+			}
+		}
+	}
+	slices.SortStableFunc(events, func(a, b calendarEvent) int {
+		return time.Time(a.Start).Compare(time.Time(b.Start))
+	})
+
+	ctx.Data(http.StatusOK, calendarContentType, renderCalendar("PN check ups", events, time.Now()))
+}
+
+// Utility function which parses period of check ups calendar from 'from' and 'to' query parameters
+func parseCalendarPeriod(ctx *gin.Context) (DateType, DateType, error) {
+	from := Today()
+	if value := ctx.Query("from"); value != "" {
+		date, err := ParseDate(value)
+		if err != nil {
+			return from, from, fmt.Errorf("Parameter 'from': %w", err)
+		}
+		from = date
+	}
+
+	to := from.AddDays(90)
+	if value := ctx.Query("to"); value != "" {
+		date, err := ParseDate(value)
+		if err != nil {
+			return from, to, fmt.Errorf("Parameter 'to': %w", err)
+		}
+		to = date
+	}
+
+	if from.After(to) {
+		return from, to, fmt.Errorf("Parameter 'to' must be on or after 'from'")
+	}
+	if from.DaysTo(to) >= maxCalendarDays {
+		return from, to, fmt.Errorf("Period of the calendar can be at most %d days long", maxCalendarDays)
+	}
+	return from, to, nil
+}
+
+// GetCheckUpsDueThisWeek - Provides PN records with check ups due this week (Monday to Sunday)
+func (this *implCheckUpsAPI) GetCheckUpsDueThisWeek(ctx *gin.Context) {
+	today := Today()
+	monday := today.AddDays(-((int(time.Time(today).Weekday()) + 6) % 7))
+	this.listPendingCheckUps(ctx, &monday, monday.AddDays(6))
+}
+
+// GetCheckUpsDueToday - Provides PN records with check ups due today
+func (this *implCheckUpsAPI) GetCheckUpsDueToday(ctx *gin.Context) {
+	today := Today()
+	this.listPendingCheckUps(ctx, &today, today)
+}
+
+// GetCheckUpsOverdue - Provides PN records with check ups planned in the past which are not done
+func (this *implCheckUpsAPI) GetCheckUpsOverdue(ctx *gin.Context) {
+	this.listPendingCheckUps(ctx, nil, Today().AddDays(-1))
+}
+
+// lists records with pending check ups in <from, to> period, shared by check up listings,
+// records are ordered by their earliest pending check up unless other order is requested
+func (this *implCheckUpsAPI) listPendingCheckUps(ctx *gin.Context, from *DateType, to DateType) {
+	value, exists := ctx.Get("db_service")
+	if !exists {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db not found",
+				"error":   "db not found",
+			})
+		return
+	}
+
+	db, ok := value.(db_service.DbService[Record])
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db_service context is not of type db_service.DbService",
+				"error":   "cannot cast db_service context to db_service.DbService",
+			})
+		return
+	}
+
+	// Same paging, sorting and filters as list of all records, limited to pending check ups
+	query, err := parseRecordQuery(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "Invalid query parameter",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+
+	query.Filter = db_service.And(query.Filter, pendingCheckUpCondition(from, to))
+	if len(query.Sort) == 0 {
+		query.Sort = []db_service.SortField{{Field: "checkUp"}, {Field: "id"}}
+	}
+
+	records, total, err := db.QueryDocuments(ctx, query)
+	scope, _ := scopeFromContext(ctx)
+
+	switch {
+	case err == nil:
+		ctx.Header("X-Total-Count", strconv.FormatInt(total, 10))
+		ctx.JSON(http.StatusOK, scope.limitAll(records))
+	case errors.Is(err, db_service.ErrEncryptedField):
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "Invalid query parameter",
+				"error":   err.Error(),
+			},
+		)
+	default:
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to load records from database",
+				"error":   err.Error(),
+			},
+		)
+	}
+}
@@ -0,0 +1,35 @@
+package pn_registry
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// default and maximum number of diagnoses returned by search
+const (
+	defaultDiagnosesLimit = 20
+	maxDiagnosesLimit     = 100
+)
+
+// GetDiagnoses - Searches ICD-10 diagnoses
+func (this *implDiagnosesAPI) GetDiagnoses(ctx *gin.Context) {
+	limit := defaultDiagnosesLimit
+	if value := ctx.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxDiagnosesLimit {
+			ctx.JSON(http.StatusBadRequest,
+				gin.H{
+					"status":  "Bad Request",
+					"message": "Invalid query parameter",
+					"error":   "Parameter 'limit' must be a number between 1 and " + strconv.Itoa(maxDiagnosesLimit),
+				},
+			)
+			return
+		}
+		limit = parsed
+	}
+
+	ctx.JSON(http.StatusOK, searchDiagnoses(ctx.Query("q"), limit))
+}
@@ -0,0 +1,610 @@
+package pn_registry
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/bmathus/pnregistry-webapi/internal/db_service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// CreateEmployer - Saves new employer
+func (this *implEmployersAPI) CreateEmployer(ctx *gin.Context) {
+	value, exists := ctx.Get("employer_service")
+	if !exists {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "employer db not found",
+				"error":   "employer db not found",
+			})
+		return
+	}
+
+	employerDb, ok := value.(db_service.DbService[Employer])
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "employer_service context is not of type db_service.DbService",
+				"error":   "cannot cast employer_service context to db_service.DbService",
+			})
+		return
+	}
+
+	newEmployer := Employer{}
+
+	// Fields validation
+	if err := ctx.ShouldBindJSON(&newEmployer); err != nil {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "Invalid request body",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+
+	if newEmployer.Id == "@new" {
+		newEmployer.Id = uuid.New().String()
+	}
+
+	// Serialize requests for the same IČO, so that check of its uniqueness and write are atomic
+	unlock, err := companyIdLocks.Lock(ctx, newEmployer.Ico)
+	if err != nil {
+		lockError(err).respond(ctx)
+		return
+	}
+	defer unlock()
+
+	_, conflicting, err := employerDb.QueryDocuments(ctx, db_service.Query{
+		Filter:     db_service.Eq("ico", newEmployer.Ico),
+		Projection: []string{"id"},
+		Limit:      1,
+	})
+
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to fetch existing employers",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+
+	if conflicting != 0 {
+		ctx.JSON(http.StatusConflict,
+			gin.H{
+				"status":  "Conflict",
+				"message": "Employer with the same IČO already exists",
+			},
+		)
+		return
+	}
+
+	err = employerDb.CreateDocument(ctx, newEmployer.Id, &newEmployer)
+
+	switch err {
+	case nil:
+		ctx.JSON(http.StatusCreated, newEmployer)
+	case db_service.ErrConflict:
+		ctx.JSON(http.StatusConflict,
+			gin.H{
+				"status":  "Conflict",
+				"message": "Employer already exists",
+				"error":   err.Error(),
+			},
+		)
+	default:
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to create employer in database",
+				"error":   err.Error(),
+			},
+		)
+	}
+}
+
+// DeleteEmployer - Deletes specific employer
+func (this *implEmployersAPI) DeleteEmployer(ctx *gin.Context) {
+	value, exists := ctx.Get("employer_service")
+	if !exists {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "employer db not found",
+				"error":   "employer db not found",
+			})
+		return
+	}
+
+	employerDb, ok := value.(db_service.DbService[Employer])
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "employer_service context is not of type db_service.DbService",
+				"error":   "cannot cast employer_service context to db_service.DbService",
+			})
+		return
+	}
+
+	value, exists = ctx.Get("db_service")
+	if !exists {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db not found",
+				"error":   "db not found",
+			})
+		return
+	}
+
+	db, ok := value.(db_service.DbService[Record])
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db_service context is not of type db_service.DbService",
+				"error":   "cannot cast db_service context to db_service.DbService",
+			})
+		return
+	}
+
+	employerId := ctx.Param("employerId")
+
+	unlock, err := employerLocks.Lock(ctx, employerId)
+	if err != nil {
+		lockError(err).respond(ctx)
+		return
+	}
+	defer unlock()
+
+	// Employer referenced by PN records cannot be deleted, records would lose their employer
+	_, records, err := db.QueryDocuments(ctx, db_service.Query{
+		Filter:     db_service.And(db_service.Eq("employerId", employerId), notDeleted()),
+		Projection: []string{"id"},
+		Limit:      1,
+	})
+
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to fetch existing records",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+
+	if records != 0 {
+		ctx.JSON(http.StatusConflict,
+			gin.H{
+				"status":  "Conflict",
+				"message": "Employer is referenced by PN records and cannot be deleted",
+			},
+		)
+		return
+	}
+
+	err = employerDb.DeleteDocument(ctx, employerId)
+
+	switch err {
+	case nil:
+		ctx.AbortWithStatus(http.StatusNoContent)
+	case db_service.ErrNotFound:
+		ctx.JSON(http.StatusNotFound,
+			gin.H{
+				"status":  "Not Found",
+				"message": "Employer with specified ID not found",
+				"error":   err.Error(),
+			},
+		)
+	default:
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to delete employer from database",
+				"error":   err.Error(),
+			})
+	}
+}
+
+// GetEmployer - Provides details about specific employer
+func (this *implEmployersAPI) GetEmployer(ctx *gin.Context) {
+	value, exists := ctx.Get("employer_service")
+	if !exists {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "employer db not found",
+				"error":   "employer db not found",
+			})
+		return
+	}
+
+	employerDb, ok := value.(db_service.DbService[Employer])
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "employer_service context is not of type db_service.DbService",
+				"error":   "cannot cast employer_service context to db_service.DbService",
+			})
+		return
+	}
+
+	employer, err := employerDb.FindDocument(ctx, ctx.Param("employerId"))
+
+	switch err {
+	case nil:
+		ctx.JSON(http.StatusOK, employer)
+	case db_service.ErrNotFound:
+		ctx.JSON(http.StatusNotFound,
+			gin.H{
+				"status":  "Not Found",
+				"message": "Employer with specified ID not found",
+				"error":   err.Error(),
+			},
+		)
+	default:
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to load employer from database",
+				"error":   err.Error(),
+			})
+	}
+}
+
+// GetEmployerAll - Provides list of all employers
+func (this *implEmployersAPI) GetEmployerAll(ctx *gin.Context) {
+	value, exists := ctx.Get("employer_service")
+	if !exists {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "employer db not found",
+				"error":   "employer db not found",
+			})
+		return
+	}
+
+	employerDb, ok := value.(db_service.DbService[Employer])
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "employer_service context is not of type db_service.DbService",
+				"error":   "cannot cast employer_service context to db_service.DbService",
+			})
+		return
+	}
+
+	// Employers can be looked up by IČO
+	var filter db_service.Filter
+	if ico := ctx.Query("ico"); ico != "" {
+		filter = db_service.Eq("ico", ico)
+	}
+
+	employers, _, err := employerDb.QueryDocuments(ctx, db_service.Query{
+		Filter: filter,
+		Sort:   []db_service.SortField{{Field: "name"}, {Field: "id"}},
+	})
+
+	switch err {
+	case nil:
+		ctx.JSON(http.StatusOK, employers)
+	default:
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to load all employers from database",
+				"error":   err.Error(),
+			},
+		)
+	}
+}
+
+// GetEmployerRecords - Provides currently active PN records of employer's employees
+func (this *implEmployersAPI) GetEmployerRecords(ctx *gin.Context) {
+	value, exists := ctx.Get("employer_service")
+	if !exists {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "employer db not found",
+				"error":   "employer db not found",
+			})
+		return
+	}
+
+	employerDb, ok := value.(db_service.DbService[Employer])
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "employer_service context is not of type db_service.DbService",
+				"error":   "cannot cast employer_service context to db_service.DbService",
+			})
+		return
+	}
+
+	value, exists = ctx.Get("db_service")
+	if !exists {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db not found",
+				"error":   "db not found",
+			})
+		return
+	}
+
+	db, ok := value.(db_service.DbService[Record])
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db_service context is not of type db_service.DbService",
+				"error":   "cannot cast db_service context to db_service.DbService",
+			})
+		return
+	}
+
+	employerId := ctx.Param("employerId")
+
+	// Same paging, sorting and filters as list of all records, limited to the employer
+	query, err := parseRecordQuery(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "Invalid query parameter",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+
+	// Sick leaves are active today, unless other date is requested
+	activeDate := Today()
+	if value := ctx.Query("date"); value != "" {
+		activeDate, err = ParseDate(value)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest,
+				gin.H{
+					"status":  "Bad Request",
+					"message": "Invalid query parameter",
+					"error":   "Parameter 'date': " + err.Error(),
+				},
+			)
+			return
+		}
+	}
+
+	if _, err := employerDb.FindDocument(ctx, employerId); err != nil {
+		if err == db_service.ErrNotFound {
+			ctx.JSON(http.StatusNotFound,
+				gin.H{
+					"status":  "Not Found",
+					"message": "Employer with specified ID not found",
+					"error":   err.Error(),
+				},
+			)
+			return
+		}
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to load employer from database",
+				"error":   err.Error(),
+			})
+		return
+	}
+
+	query.Filter = db_service.And(query.Filter, db_service.Eq("employerId", employerId), activeOn(activeDate))
+	if len(query.Sort) == 0 {
+		query.Sort = []db_service.SortField{{Field: "validUntil"}, {Field: "id"}}
+	}
+
+	records, total, err := db.QueryDocuments(ctx, query)
+	scope, _ := scopeFromContext(ctx)
+
+	switch {
+	case err == nil:
+		ctx.Header("X-Total-Count", strconv.FormatInt(total, 10))
+		ctx.JSON(http.StatusOK, scope.limitAll(records))
+	case errors.Is(err, db_service.ErrEncryptedField):
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "Invalid query parameter",
+				"error":   err.Error(),
+			},
+		)
+	default:
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to load employer's records from database",
+				"error":   err.Error(),
+			},
+		)
+	}
+}
+
+// UpdateEmployer - Updates specific employer, rename of the employer is propagated to all records referencing it
+func (this *implEmployersAPI) UpdateEmployer(ctx *gin.Context) {
+	value, exists := ctx.Get("employer_service")
+	if !exists {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "employer db not found",
+				"error":   "employer db not found",
+			})
+		return
+	}
+
+	employerDb, ok := value.(db_service.DbService[Employer])
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "employer_service context is not of type db_service.DbService",
+				"error":   "cannot cast employer_service context to db_service.DbService",
+			})
+		return
+	}
+
+	value, exists = ctx.Get("db_service")
+	if !exists {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db not found",
+				"error":   "db not found",
+			})
+		return
+	}
+
+	db, ok := value.(db_service.DbService[Record])
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db_service context is not of type db_service.DbService",
+				"error":   "cannot cast db_service context to db_service.DbService",
+			})
+		return
+	}
+
+	updatedEmployer := Employer{}
+
+	// Fields validation
+	if err := ctx.ShouldBindJSON(&updatedEmployer); err != nil {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "Invalid request body",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+
+	employerId := ctx.Param("employerId")
+
+	// Ensure the ID in the URL matches the ID in the request body
+	if updatedEmployer.Id != employerId {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "Employer ID in URL does not match ID in request body",
+			},
+		)
+		return
+	}
+
+	// Serialize requests for the same employer, so that records are not created with old name during rename
+	unlock, err := employerLocks.Lock(ctx, employerId)
+	if err != nil {
+		lockError(err).respond(ctx)
+		return
+	}
+	defer unlock()
+	unlockCompanyId, err := companyIdLocks.Lock(ctx, updatedEmployer.Ico)
+	if err != nil {
+		lockError(err).respond(ctx)
+		return
+	}
+	defer unlockCompanyId()
+
+	if _, err := employerDb.FindDocument(ctx, employerId); err != nil {
+		if err == db_service.ErrNotFound {
+			ctx.JSON(http.StatusNotFound,
+				gin.H{
+					"status":  "Not Found",
+					"message": "Employer with specified ID not found",
+					"error":   err.Error(),
+				},
+			)
+			return
+		}
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to load employer from database",
+				"error":   err.Error(),
+			})
+		return
+	}
+
+	_, conflicting, err := employerDb.QueryDocuments(ctx, db_service.Query{
+		Filter:     db_service.And(db_service.Eq("ico", updatedEmployer.Ico), db_service.Ne("id", employerId)),
+		Projection: []string{"id"},
+		Limit:      1,
+	})
+
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to fetch existing employers",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+
+	if conflicting != 0 {
+		ctx.JSON(http.StatusConflict,
+			gin.H{
+				"status":  "Conflict",
+				"message": "Employer with the same IČO already exists",
+			},
+		)
+		return
+	}
+
+	// Records are renamed first, failed rename can be fixed by repeating the request
+	if err := renameEmployerRecords(ctx, db, employerId, updatedEmployer.Name); err != nil {
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to propagate name to employer's records",
+				"error":   err.Error(),
+			})
+		return
+	}
+
+	err = employerDb.UpdateDocument(ctx, employerId, &updatedEmployer)
+
+	switch err {
+	case nil:
+		ctx.JSON(http.StatusOK, updatedEmployer)
+	case db_service.ErrNotFound:
+		ctx.JSON(http.StatusNotFound,
+			gin.H{
+				"status":  "Not Found",
+				"message": "Employer with specified ID not found",
+				"error":   err.Error(),
+			},
+		)
+	default:
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to update employer in database",
+				"error":   err.Error(),
+			})
+	}
+}
@@ -0,0 +1,74 @@
+package pn_registry
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+// stores record of the employer directly in database, so that it can be in any lifecycle state
+func storeEmployerRecord(t *testing.T, services *testServices, id string, status string) {
+	t.Helper()
+	validFrom, _ := ParseDate("2024-01-01")
+	validUntil, _ := ParseDate("2024-01-31")
+	record := Record{
+		Id:         id,
+		PatientId:  id + "0",
+		FullName:   "Jozef Mrkvicka",
+		EmployerId: "e1",
+		Employer:   "Stavby s.r.o.",
+		Reason:     Choroba,
+		Issued:     validFrom,
+		ValidFrom:  validFrom,
+		ValidUntil: validUntil,
+		Status:     status,
+		Version:    1,
+	}
+	if err := services.records.CreateDocument(context.Background(), id, &record); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetEmployerRecords(t *testing.T) {
+	services := newTestServices()
+	engine := newTestEngine(services)
+	employer := map[string]interface{}{"id": "e1", "name": "Stavby s.r.o.", "ico": "12345678"}
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/employers/", employer, nil), http.StatusCreated)
+
+	storeEmployerRecord(t, services, "r1", StatusActive)
+	storeEmployerRecord(t, services, "r2", StatusCancelled)
+
+	recorder := doRequest(engine, http.MethodGet, "/api/employers/e1/records?date=2024-01-15", nil, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	if records := decodeResponse[[]Record](t, recorder); len(records) != 1 || records[0].Id != "r1" {
+		t.Errorf("Expected only active record r1, got %+v", records)
+	}
+}
+
+func TestRenameEmployerKeepsFinalAndDeletedRecords(t *testing.T) {
+	services := newTestServices()
+	engine := newTestEngine(services)
+	employer := map[string]interface{}{"id": "e1", "name": "Stavby s.r.o.", "ico": "12345678"}
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/employers/", employer, nil), http.StatusCreated)
+
+	storeEmployerRecord(t, services, "r1", StatusActive)
+	storeEmployerRecord(t, services, "r2", StatusClosed)
+	storeEmployerRecord(t, services, "r3", StatusCancelled)
+	storeEmployerRecord(t, services, "r4", StatusActive)
+	expectStatus(t, doRequest(engine, http.MethodDelete, "/api/records/r4/", nil, nil), http.StatusNoContent)
+
+	employer["name"] = "Stavby a.s."
+	expectStatus(t, doRequest(engine, http.MethodPut, "/api/employers/e1/", employer, nil), http.StatusOK)
+
+	// deleted records are kept as they were deleted
+	expected := map[string]string{"r1": "Stavby a.s.", "r2": "Stavby s.r.o.", "r3": "Stavby s.r.o.", "r4": "Stavby s.r.o."}
+	for id, name := range expected {
+		record, err := services.records.FindDocument(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.Employer != name {
+			t.Errorf("Expected record %v with employer %q, got %q", id, name, record.Employer)
+		}
+	}
+}
@@ -0,0 +1,547 @@
+package pn_registry
+
+import (
+	"cmp"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/bmathus/pnregistry-webapi/internal/db_service"
+	"github.com/gin-gonic/gin"
+)
+
+// CreatePatient - Saves new patient
+func (this *implPatientsAPI) CreatePatient(ctx *gin.Context) {
+	value, exists := ctx.Get("patient_service")
+	if !exists {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "patient db not found",
+				"error":   "patient db not found",
+			})
+		return
+	}
+
+	patientDb, ok := value.(db_service.DbService[Patient])
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "patient_service context is not of type db_service.DbService",
+				"error":   "cannot cast patient_service context to db_service.DbService",
+			})
+		return
+	}
+
+	value, exists = ctx.Get("db_service")
+	if !exists {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db not found",
+				"error":   "db not found",
+			})
+		return
+	}
+
+	db, ok := value.(db_service.DbService[Record])
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db_service context is not of type db_service.DbService",
+				"error":   "cannot cast db_service context to db_service.DbService",
+			})
+		return
+	}
+
+	newPatient := Patient{}
+
+	// Fields validation
+	if err := ctx.ShouldBindJSON(&newPatient); err != nil {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "Invalid request body",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+
+	// Birth date and sex are derived from patient's ID when it is birth number
+	newPatient.deriveFromBirthNumber()
+
+	// Serialize requests for the same patient, so that conflict checks and write are atomic
+	unlock, err := patientLocks.Lock(ctx, newPatient.Id)
+	if err != nil {
+		lockError(err).respond(ctx)
+		return
+	}
+	defer unlock()
+
+	// Patient may already have records, their full name must correspond to the registered patient
+	_, conflicting, err := db.QueryDocuments(ctx, db_service.Query{
+		Filter: db_service.And(
+			db_service.Eq("patientId", newPatient.Id),
+			db_service.Ne("fullName", newPatient.FullName),
+			notDeleted(),
+		),
+		Projection: []string{"id"},
+		Limit:      1,
+	})
+
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to fetch existing records",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+
+	if conflicting != 0 {
+		ctx.JSON(http.StatusConflict,
+			gin.H{
+				"status":  "Conflict",
+				"message": "Full Name does not correspond to patient's existing PN records",
+			},
+		)
+		return
+	}
+
+	err = patientDb.CreateDocument(ctx, newPatient.Id, &newPatient)
+
+	switch err {
+	case nil:
+		ctx.JSON(http.StatusCreated, newPatient)
+	case db_service.ErrConflict:
+		ctx.JSON(http.StatusConflict,
+			gin.H{
+				"status":  "Conflict",
+				"message": "Patient already exists",
+				"error":   err.Error(),
+			},
+		)
+	default:
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to create patient in database",
+				"error":   err.Error(),
+			},
+		)
+	}
+}
+
+// DeletePatient - Deletes specific patient
+func (this *implPatientsAPI) DeletePatient(ctx *gin.Context) {
+	value, exists := ctx.Get("patient_service")
+	if !exists {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "patient db not found",
+				"error":   "patient db not found",
+			})
+		return
+	}
+
+	patientDb, ok := value.(db_service.DbService[Patient])
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "patient_service context is not of type db_service.DbService",
+				"error":   "cannot cast patient_service context to db_service.DbService",
+			})
+		return
+	}
+
+	value, exists = ctx.Get("db_service")
+	if !exists {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db not found",
+				"error":   "db not found",
+			})
+		return
+	}
+
+	db, ok := value.(db_service.DbService[Record])
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db_service context is not of type db_service.DbService",
+				"error":   "cannot cast db_service context to db_service.DbService",
+			})
+		return
+	}
+
+	patientId := ctx.Param("patientId")
+
+	unlock, err := patientLocks.Lock(ctx, patientId)
+	if err != nil {
+		lockError(err).respond(ctx)
+		return
+	}
+	defer unlock()
+
+	// Patient with PN records cannot be deleted, records would lose their patient
+	_, records, err := db.QueryDocuments(ctx, db_service.Query{
+		Filter:     db_service.And(db_service.Eq("patientId", patientId), notDeleted()),
+		Projection: []string{"id"},
+		Limit:      1,
+	})
+
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to fetch existing records",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+
+	if records != 0 {
+		ctx.JSON(http.StatusConflict,
+			gin.H{
+				"status":  "Conflict",
+				"message": "Patient has PN records and cannot be deleted",
+			},
+		)
+		return
+	}
+
+	err = patientDb.DeleteDocument(ctx, patientId)
+
+	switch err {
+	case nil:
+		ctx.AbortWithStatus(http.StatusNoContent)
+	case db_service.ErrNotFound:
+		ctx.JSON(http.StatusNotFound,
+			gin.H{
+				"status":  "Not Found",
+				"message": "Patient with specified ID not found",
+				"error":   err.Error(),
+			},
+		)
+	default:
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to delete patient from database",
+				"error":   err.Error(),
+			})
+	}
+}
+
+// GetPatient - Provides details about specific patient
+func (this *implPatientsAPI) GetPatient(ctx *gin.Context) {
+	value, exists := ctx.Get("patient_service")
+	if !exists {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "patient db not found",
+				"error":   "patient db not found",
+			})
+		return
+	}
+
+	patientDb, ok := value.(db_service.DbService[Patient])
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "patient_service context is not of type db_service.DbService",
+				"error":   "cannot cast patient_service context to db_service.DbService",
+			})
+		return
+	}
+
+	patient, err := patientDb.FindDocument(ctx, ctx.Param("patientId"))
+
+	switch err {
+	case nil:
+		patient.deriveFromBirthNumber()
+		ctx.JSON(http.StatusOK, patient)
+	case db_service.ErrNotFound:
+		ctx.JSON(http.StatusNotFound,
+			gin.H{
+				"status":  "Not Found",
+				"message": "Patient with specified ID not found",
+				"error":   err.Error(),
+			},
+		)
+	default:
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to load patient from database",
+				"error":   err.Error(),
+			})
+	}
+}
+
+// GetPatientAll - Provides list of all patients
+func (this *implPatientsAPI) GetPatientAll(ctx *gin.Context) {
+	value, exists := ctx.Get("patient_service")
+	if !exists {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "patient db not found",
+				"error":   "patient db not found",
+			})
+		return
+	}
+
+	patientDb, ok := value.(db_service.DbService[Patient])
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "patient_service context is not of type db_service.DbService",
+				"error":   "cannot cast patient_service context to db_service.DbService",
+			})
+		return
+	}
+
+	// Patients are sorted after they are loaded, because their names may be stored encrypted
+	patients, _, err := patientDb.QueryDocuments(ctx, db_service.Query{})
+
+	switch err {
+	case nil:
+		slices.SortFunc(patients, func(a, b Patient) int {
+			return cmp.Or(cmp.Compare(a.FullName, b.FullName), cmp.Compare(a.Id, b.Id))
+		})
+		for i := range patients {
+			patients[i].deriveFromBirthNumber()
+		}
+		ctx.JSON(http.StatusOK, patients)
+	default:
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to load all patients from database",
+				"error":   err.Error(),
+			},
+		)
+	}
+}
+
+// GetPatientRecords - Provides PN records of specific patient
+func (this *implPatientsAPI) GetPatientRecords(ctx *gin.Context) {
+	value, exists := ctx.Get("db_service")
+	if !exists {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db not found",
+				"error":   "db not found",
+			})
+		return
+	}
+
+	db, ok := value.(db_service.DbService[Record])
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db_service context is not of type db_service.DbService",
+				"error":   "cannot cast db_service context to db_service.DbService",
+			})
+		return
+	}
+
+	// Same paging, sorting and filters as list of all records, limited to the patient
+	query, err := parseRecordQuery(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "Invalid query parameter",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+
+	query.Filter = db_service.And(query.Filter, db_service.Eq("patientId", ctx.Param("patientId")))
+	if len(query.Sort) == 0 {
+		// PN history is ordered chronologically by default
+		query.Sort = []db_service.SortField{{Field: "validFrom"}, {Field: "id"}}
+	}
+
+	records, total, err := db.QueryDocuments(ctx, query)
+	scope, _ := scopeFromContext(ctx)
+
+	switch {
+	case err == nil:
+		ctx.Header("X-Total-Count", strconv.FormatInt(total, 10))
+		ctx.JSON(http.StatusOK, scope.limitAll(records))
+	case errors.Is(err, db_service.ErrEncryptedField):
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "Invalid query parameter",
+				"error":   err.Error(),
+			},
+		)
+	default:
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to load patient's records from database",
+				"error":   err.Error(),
+			},
+		)
+	}
+}
+
+// UpdatePatient - Updates specific patient, rename of the patient is propagated to all their records
+func (this *implPatientsAPI) UpdatePatient(ctx *gin.Context) {
+	value, exists := ctx.Get("patient_service")
+	if !exists {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "patient db not found",
+				"error":   "patient db not found",
+			})
+		return
+	}
+
+	patientDb, ok := value.(db_service.DbService[Patient])
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "patient_service context is not of type db_service.DbService",
+				"error":   "cannot cast patient_service context to db_service.DbService",
+			})
+		return
+	}
+
+	value, exists = ctx.Get("db_service")
+	if !exists {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db not found",
+				"error":   "db not found",
+			})
+		return
+	}
+
+	db, ok := value.(db_service.DbService[Record])
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db_service context is not of type db_service.DbService",
+				"error":   "cannot cast db_service context to db_service.DbService",
+			})
+		return
+	}
+
+	updatedPatient := Patient{}
+
+	// Fields validation
+	if err := ctx.ShouldBindJSON(&updatedPatient); err != nil {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "Invalid request body",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+
+	patientId := ctx.Param("patientId")
+
+	// Ensure the ID in the URL matches the ID in the request body
+	if updatedPatient.Id != patientId {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "Patient ID in URL does not match ID in request body",
+			},
+		)
+		return
+	}
+
+	// Birth date and sex are derived from patient's ID when it is birth number
+	updatedPatient.deriveFromBirthNumber()
+
+	// Serialize requests for the same patient, so that records are not created with old name during rename
+	unlock, err := patientLocks.Lock(ctx, patientId)
+	if err != nil {
+		lockError(err).respond(ctx)
+		return
+	}
+	defer unlock()
+
+	if _, err := patientDb.FindDocument(ctx, patientId); err != nil {
+		if err == db_service.ErrNotFound {
+			ctx.JSON(http.StatusNotFound,
+				gin.H{
+					"status":  "Not Found",
+					"message": "Patient with specified ID not found",
+					"error":   err.Error(),
+				},
+			)
+			return
+		}
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to load patient from database",
+				"error":   err.Error(),
+			})
+		return
+	}
+
+	// Records are renamed first, failed rename can be fixed by repeating the request
+	if err := renamePatientRecords(ctx, db, patientId, updatedPatient.FullName); err != nil {
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to propagate Full Name to patient's records",
+				"error":   err.Error(),
+			})
+		return
+	}
+
+	err = patientDb.UpdateDocument(ctx, patientId, &updatedPatient)
+
+	switch err {
+	case nil:
+		ctx.JSON(http.StatusOK, updatedPatient)
+	case db_service.ErrNotFound:
+		ctx.JSON(http.StatusNotFound,
+			gin.H{
+				"status":  "Not Found",
+				"message": "Patient with specified ID not found",
+				"error":   err.Error(),
+			},
+		)
+	default:
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to update patient in database",
+				"error":   err.Error(),
+			})
+	}
+}
@@ -0,0 +1,41 @@
+package pn_registry
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestRenamePatientKeepsFinalAndDeletedRecords(t *testing.T) {
+	services := newTestServices()
+	engine := newTestEngine(services)
+	patient := map[string]interface{}{"id": "123", "fullName": "Jozef Mrkvicka"}
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/patients/", patient, nil), http.StatusCreated)
+
+	createTestRecord(t, engine, newTestRecord("r1", "123", "2024-01-01", "2024-01-10"))
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/records/r1/activate", nil, nil), http.StatusOK)
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/records/r1/cancel", nil, nil), http.StatusOK)
+	createTestRecord(t, engine, newTestRecord("r2", "123", "2024-02-01", "2024-02-10"))
+	createTestRecord(t, engine, newTestRecord("r4", "123", "2024-04-01", "2024-04-10"))
+	expectStatus(t, doRequest(engine, http.MethodDelete, "/api/records/r4/", nil, nil), http.StatusNoContent)
+
+	patient["fullName"] = "Jozef Mrkva"
+	expectStatus(t, doRequest(engine, http.MethodPut, "/api/patients/123/", patient, nil), http.StatusOK)
+
+	// deleted records are kept as they were deleted
+	expected := map[string]string{"r1": "Jozef Mrkvicka", "r2": "Jozef Mrkva", "r4": "Jozef Mrkvicka"}
+	for id, name := range expected {
+		record, err := services.records.FindDocument(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.FullName != name {
+			t.Errorf("Expected record %v with full name %q, got %q", id, name, record.FullName)
+		}
+	}
+
+	// registered patient is authoritative, so new record is not in conflict with name of cancelled record
+	renamed := newTestRecord("r3", "123", "2024-03-01", "2024-03-10")
+	renamed["fullName"] = "Jozef Mrkva"
+	createTestRecord(t, engine, renamed)
+}
@@ -1,15 +1,24 @@
 package pn_registry
 
 import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/bmathus/pnregistry-webapi/internal/db_service"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
-// CreateRecord - Saves new PN record into list of all PN records
-func (this *implPnRegistryRecordsAPI) CreateRecord(ctx *gin.Context) {
+// ActivateRecord - Activates issued PN record
+func (this *implPnRegistryRecordsAPI) ActivateRecord(ctx *gin.Context) {
 	value, exists := ctx.Get("db_service")
 	if !exists {
 		ctx.JSON(http.StatusInternalServerError,
@@ -32,132 +41,242 @@ func (this *implPnRegistryRecordsAPI) CreateRecord(ctx *gin.Context) {
 		return
 	}
 
-	newRecord := Record{}
+	this.transitionRecord(ctx, db, ctx.Param("recordId"), StatusActive, nil)
+}
 
-	// Fields validation
-	if err := ctx.ShouldBindJSON(&newRecord); err != nil {
-		ctx.JSON(http.StatusBadRequest,
+// BatchRecords - Creates, updates and deletes multiple PN records at once
+func (this *implPnRegistryRecordsAPI) BatchRecords(ctx *gin.Context) {
+	value, exists := ctx.Get("db_service")
+	if !exists {
+		ctx.JSON(http.StatusInternalServerError,
 			gin.H{
-				"status":  "Bad Request",
-				"message": "Invalid request body",
-				"error":   err.Error(),
-			},
-		)
+				"status":  "Internal Server Error",
+				"message": "db not found",
+				"error":   "db not found",
+			})
 		return
 	}
 
-	// Dates validation
-	if newRecord.CheckUp != nil && newRecord.ValidFrom.After(*newRecord.CheckUp) {
-		ctx.JSON(http.StatusBadRequest,
+	db, ok := value.(db_service.DbService[Record])
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError,
 			gin.H{
-				"status":  "Bad Request",
-				"message": "'Check Up' date can only be on or after 'Valid from' date",
-			},
-		)
+				"status":  "Internal Server Error",
+				"message": "db_service context is not of type db_service.DbService",
+				"error":   "cannot cast db_service context to db_service.DbService",
+			})
 		return
 	}
-	if newRecord.ValidFrom.After(newRecord.ValidUntil) {
+
+	request := BatchRequest{}
+	if err := ctx.ShouldBindJSON(&request); err != nil {
 		ctx.JSON(http.StatusBadRequest,
 			gin.H{
 				"status":  "Bad Request",
-				"message": "'Valid until' date can only be on or after 'Valid from' date",
+				"message": "Invalid request body",
+				"error":   err.Error(),
 			},
 		)
 		return
 	}
 
-	if newRecord.Id == "@new" {
-		newRecord.Id = uuid.New().String()
+	response := BatchResponse{
+		Atomic:  request.Atomic,
+		Results: make([]BatchOperationResult, len(request.Operations)),
 	}
 
-	// Fetching patient's records by patient ID to validate conflict with new record
-	patientRecords, err := db.FindDocuments(ctx, "patientId", newRecord.PatientId)
+	// Records of create and update operations are validated in the same way as request body of single record endpoints
+	records := make([]*Record, len(request.Operations))
+	patientIds := []string{}
+	employerIds := []string{}
+	for i, operation := range request.Operations {
+		response.Results[i].Index = i
+		if operation.Op == BatchOpDelete {
+			continue
+		}
 
-	if err != nil {
-		ctx.JSON(http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to fetch existing records",
-				"error":   err.Error(),
-			},
-		)
-		return
+		record, recordErr := decodeBatchRecord(operation)
+		if recordErr != nil {
+			response.Results[i].fail(recordErr)
+			continue
+		}
+		records[i] = record
+		patientIds = append(patientIds, record.PatientId)
+		if record.EmployerId != "" {
+			employerIds = append(employerIds, record.EmployerId)
+		}
 	}
 
-	//Full Name validation
-	if newRecord.FullName == "" { // is fullName is not provided
-		if len(patientRecords) != 0 { // inherit fullname from existing records
-			newRecord.FullName = patientRecords[0].FullName
-		} else {
-			ctx.JSON(http.StatusNotFound,
+	// Updated and deleted records can be moved from their current patient and employer, so they are locked too
+	modifiedIds := []interface{}{}
+	for _, operation := range request.Operations {
+		if operation.Op != BatchOpCreate {
+			modifiedIds = append(modifiedIds, operation.RecordId)
+		}
+	}
+	if len(modifiedIds) != 0 {
+		currentRecords, _, err := db.QueryDocuments(ctx, db_service.Query{
+			Filter:     db_service.In("id", modifiedIds...),
+			Projection: []string{"id", "patientId", "employerId"},
+		})
+		if err != nil {
+			ctx.JSON(http.StatusBadGateway,
 				gin.H{
-					"status":  "Not Found",
-					"message": "Patient's PN records not found, provide Full Name",
+					"status":  "Bad Gateway",
+					"message": "Failed to fetch existing records",
+					"error":   err.Error(),
 				},
 			)
 			return
 		}
+		for _, record := range currentRecords {
+			patientIds = append(patientIds, record.PatientId)
+			if record.EmployerId != "" {
+				employerIds = append(employerIds, record.EmployerId)
+			}
+		}
 	}
 
-	if (len(patientRecords) != 0) && newRecord.FullName != patientRecords[0].FullName {
-		ctx.JSON(http.StatusConflict,
-			gin.H{
-				"status":  "Conflict",
-				"message": "Full Name does not correspond to patient's ID (conflict with existing records)",
-			},
-		)
+	// Serialize batch with other requests for the same patients and employers until all operations are written
+	unlock, err := patientLocks.Lock(ctx, patientIds...)
+	if err != nil {
+		lockError(err).respond(ctx)
 		return
 	}
+	defer unlock()
+	unlockEmployers, err := employerLocks.Lock(ctx, employerIds...)
+	if err != nil {
+		lockError(err).respond(ctx)
+		return
+	}
+	defer unlockEmployers()
 
-	// Date validity overlap validation
-	for _, record := range patientRecords {
-		if !newRecord.ValidFrom.After(record.ValidUntil) {
-			ctx.JSON(http.StatusConflict,
-				gin.H{
-					"status":  "Conflict",
-					"message": "Patient already has more up-to-date record or their validity overlap",
-				},
-			)
-			return
+	// Operations are validated in their order, later operations are checked also against records of earlier ones
+	writes := []db_service.WriteOperation[Record]{}
+	writeIndexes := []int{}
+	storedRecords := make([]*Record, len(request.Operations))
+	touched := map[string]bool{}
+	pending := []Record{}
+	for i, operation := range request.Operations {
+		result := &response.Results[i]
+		if result.Status != 0 {
+			continue
+		}
+
+		recordId := operation.RecordId
+		if operation.Op == BatchOpCreate {
+			recordId = records[i].Id
+		}
+		if touched[recordId] {
+			result.fail(newRecordError(http.StatusConflict, "Record is already modified by other operation of the batch", nil))
+			continue
 		}
+
+		// Every operation requires the same permission as single record endpoint
+		if _, err := checkAccess(ctx, batchOperationNames[operation.Op]); err != nil {
+			result.fail(newRecordError(http.StatusForbidden, "Access denied", err))
+			continue
+		}
+
+		var recordErr *recordError
+		write := db_service.WriteOperation[Record]{Id: recordId}
+		switch operation.Op {
+		case BatchOpCreate:
+			recordErr = this.prepareNewRecord(ctx, db, records[i], pending)
+			write.Kind = db_service.WriteCreate
+			write.Id = records[i].Id
+			write.Document = records[i]
+			result.Status = http.StatusCreated
+		case BatchOpUpdate:
+			storedRecords[i], recordErr = this.prepareUpdatedRecord(ctx, db, recordId, records[i], operation.IfMatch, nil, pending)
+			write.Kind = db_service.WriteUpdate
+			write.Document = records[i]
+			result.Status = http.StatusOK
+		case BatchOpDelete:
+			storedRecords[i], records[i], recordErr = this.prepareDeletedRecord(ctx, db, recordId, operation.IfMatch, operation.Reason)
+			write.Kind = db_service.WriteUpdate
+			write.Document = records[i]
+			result.Status = http.StatusNoContent
+		}
+
+		if recordErr != nil {
+			result.fail(recordErr)
+			continue
+		}
+
+		// Record moved to other patient or employer before the batch locked them can not be written safely
+		if stored := storedRecords[i]; stored != nil && (!slices.Contains(patientIds, stored.PatientId) || (stored.EmployerId != "" && !slices.Contains(employerIds, stored.EmployerId))) {
+			result.fail(newRecordError(http.StatusConflict, "Record was modified by another request, load it and try again", nil))
+			continue
+		}
+
+		// Write succeeds only if record was not modified since it was loaded
+		if storedRecords[i] != nil {
+			write.Condition = versionCondition(storedRecords[i].Version)
+		}
+		touched[write.Id] = true
+		if operation.Op != BatchOpDelete {
+			pending = append(pending, *records[i])
+		}
+		writes = append(writes, write)
+		writeIndexes = append(writeIndexes, i)
 	}
 
-	// Dreate new record in db
-	err = db.CreateDocument(ctx, newRecord.Id, &newRecord)
+	if request.Atomic && len(writes) != len(request.Operations) {
+		for _, i := range writeIndexes {
+			response.Results[i].fail(errBatchRolledBack)
+		}
+		response.countResults()
+		ctx.JSON(http.StatusUnprocessableEntity, response)
+		return
+	}
 
-	switch err {
-	case nil:
-		ctx.JSON(
-			http.StatusCreated,
-			newRecord,
-		)
-	case db_service.ErrConflict:
-		ctx.JSON(
-			http.StatusConflict,
-			gin.H{
-				"status":  "Conflict",
-				"message": "Record already exists",
-				"error":   err.Error(),
-			},
-		)
-	default:
-		ctx.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to create record in database",
-				"error":   err.Error(),
-			},
-		)
+	writeErrors, err := db.BulkWrite(ctx, writes, request.Atomic)
+	if err != nil {
+		bulkWriteError("Failed to write records into database", err).respond(ctx)
+		return
+	}
+
+	for j, i := range writeIndexes {
+		operation := request.Operations[i]
+		result := &response.Results[i]
+		record := records[i]
+
+		switch {
+		case writeErrors[j] == db_service.ErrRolledBack:
+			result.fail(errBatchRolledBack)
+		case writeErrors[j] != nil && operation.Op == BatchOpCreate:
+			result.fail(createWriteError(writeErrors[j]))
+		case writeErrors[j] != nil && operation.Op == BatchOpUpdate:
+			result.fail(updateWriteError(writeErrors[j]))
+		case writeErrors[j] != nil:
+			result.fail(deleteWriteError(writeErrors[j]))
+		case operation.Op == BatchOpCreate:
+			recordAudit(ctx, AuditActionCreate, record.Id, nil, record)
+			result.ETag = record.ETag()
+			result.Body = record
+		case operation.Op == BatchOpUpdate:
+			recordAudit(ctx, AuditActionUpdate, record.Id, storedRecords[i], record)
+			result.ETag = record.ETag()
+			result.Body = record
+		default:
+			recordAudit(ctx, AuditActionDelete, record.Id, storedRecords[i], record)
+		}
 	}
+
+	response.countResults()
+	if request.Atomic && response.Failed != 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, response)
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
 }
 
-// DeleteRecord - Deletes specific PN record
-func (this *implPnRegistryRecordsAPI) DeleteRecord(ctx *gin.Context) {
+// CancelRecord - Cancels PN record
+func (this *implPnRegistryRecordsAPI) CancelRecord(ctx *gin.Context) {
 	value, exists := ctx.Get("db_service")
 	if !exists {
-		ctx.JSON(
-			http.StatusInternalServerError,
+		ctx.JSON(http.StatusInternalServerError,
 			gin.H{
 				"status":  "Internal Server Error",
 				"message": "db not found",
@@ -168,8 +287,7 @@ func (this *implPnRegistryRecordsAPI) DeleteRecord(ctx *gin.Context) {
 
 	db, ok := value.(db_service.DbService[Record])
 	if !ok {
-		ctx.JSON(
-			http.StatusInternalServerError,
+		ctx.JSON(http.StatusInternalServerError,
 			gin.H{
 				"status":  "Internal Server Error",
 				"message": "db_service context is not of type db_service.DbService",
@@ -178,39 +296,75 @@ func (this *implPnRegistryRecordsAPI) DeleteRecord(ctx *gin.Context) {
 		return
 	}
 
-	recordId := ctx.Param("recordId")
-
-	err := db.DeleteDocument(ctx, recordId)
+	this.transitionRecord(ctx, db, ctx.Param("recordId"), StatusCancelled, nil)
+}
 
-	switch err {
-	case nil:
-		ctx.AbortWithStatus(http.StatusNoContent)
-	case db_service.ErrNotFound:
-		ctx.JSON(
-			http.StatusNotFound,
+// CloseRecord - Closes active PN record with actual end date
+func (this *implPnRegistryRecordsAPI) CloseRecord(ctx *gin.Context) {
+	value, exists := ctx.Get("db_service")
+	if !exists {
+		ctx.JSON(http.StatusInternalServerError,
 			gin.H{
-				"status":  "Not Found",
-				"message": "Record with specified ID not found",
-				"error":   err.Error(),
-			},
-		)
-	default:
-		ctx.JSON(
-			http.StatusBadGateway,
+				"status":  "Internal Server Error",
+				"message": "db not found",
+				"error":   "db not found",
+			})
+		return
+	}
+
+	db, ok := value.(db_service.DbService[Record])
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError,
 			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to delete record from database",
-				"error":   err.Error(),
+				"status":  "Internal Server Error",
+				"message": "db_service context is not of type db_service.DbService",
+				"error":   "cannot cast db_service context to db_service.DbService",
 			})
+		return
+	}
+
+	// Request body is optional, the record is closed today when end date is not provided
+	closure := RecordClosure{}
+	if ctx.Request.ContentLength != 0 {
+		if err := ctx.ShouldBindJSON(&closure); err != nil {
+			ctx.JSON(http.StatusBadRequest,
+				gin.H{
+					"status":  "Bad Request",
+					"message": "Invalid request body",
+					"error":   err.Error(),
+				},
+			)
+			return
+		}
+	}
+
+	endDate := Today()
+	if closure.EndDate != nil {
+		endDate = *closure.EndDate
 	}
+
+	this.transitionRecord(ctx, db, ctx.Param("recordId"), StatusClosed, func(record *Record) bool {
+		// Sick leave can end only within its validity, actual end date becomes end of its validity
+		if record.ValidFrom.After(endDate) || endDate.After(record.ValidUntil) {
+			ctx.JSON(http.StatusBadRequest,
+				gin.H{
+					"status":  "Bad Request",
+					"message": "'End date' can only be between 'Valid from' and 'Valid until' dates of the record",
+				},
+			)
+			return false
+		}
+
+		record.ValidUntil = endDate
+		return true
+	})
 }
 
-// GetRecord - Provides details about specific PN record
-func (this *implPnRegistryRecordsAPI) GetRecord(ctx *gin.Context) {
+// CompleteCheckUp - Marks check up of PN record as done
+func (this *implPnRegistryRecordsAPI) CompleteCheckUp(ctx *gin.Context) {
 	value, exists := ctx.Get("db_service")
 	if !exists {
-		ctx.JSON(
-			http.StatusInternalServerError,
+		ctx.JSON(http.StatusInternalServerError,
 			gin.H{
 				"status":  "Internal Server Error",
 				"message": "db not found",
@@ -221,8 +375,7 @@ func (this *implPnRegistryRecordsAPI) GetRecord(ctx *gin.Context) {
 
 	db, ok := value.(db_service.DbService[Record])
 	if !ok {
-		ctx.JSON(
-			http.StatusInternalServerError,
+		ctx.JSON(http.StatusInternalServerError,
 			gin.H{
 				"status":  "Internal Server Error",
 				"message": "db_service context is not of type db_service.DbService",
@@ -231,52 +384,95 @@ func (this *implPnRegistryRecordsAPI) GetRecord(ctx *gin.Context) {
 		return
 	}
 
-	recordId := ctx.Param("recordId")
-
-	if recordId == "" {
-		ctx.JSON(
-			http.StatusBadRequest,
+	date, err := ParseDate(ctx.Param("date"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest,
 			gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Record ID is required",
-			})
+				"status":  "Bad Request",
+				"message": "Invalid check up date",
+				"error":   err.Error(),
+			},
+		)
 		return
 	}
 
+	// Request body is optional, it contains outcome notes of the check up
+	completion := CheckUpCompletion{}
+	if ctx.Request.ContentLength != 0 {
+		if err := ctx.ShouldBindJSON(&completion); err != nil {
+			ctx.JSON(http.StatusBadRequest,
+				gin.H{
+					"status":  "Bad Request",
+					"message": "Invalid request body",
+					"error":   err.Error(),
+				},
+			)
+			return
+		}
+	}
+
+	recordId := ctx.Param("recordId")
+
 	record, err := db.FindDocument(ctx, recordId)
+	if err == nil && record.Deleted != nil {
+		err = db_service.ErrNotFound
+	}
 
 	switch err {
 	case nil:
-		ctx.JSON(
-			http.StatusOK,
-			record,
-		)
 	case db_service.ErrNotFound:
-		ctx.JSON(
-			http.StatusNotFound,
+		ctx.JSON(http.StatusNotFound,
 			gin.H{
 				"status":  "Not Found",
 				"message": "Record with specified ID not found",
 				"error":   err.Error(),
 			},
 		)
+		return
 	default:
-		ctx.JSON(
-			http.StatusBadGateway,
+		ctx.JSON(http.StatusBadGateway,
 			gin.H{
 				"status":  "Bad Gateway",
 				"message": "Failed to load record from database",
 				"error":   err.Error(),
 			})
+		return
 	}
+
+	index := record.findCheckUp(date)
+	if index == -1 {
+		ctx.JSON(http.StatusNotFound,
+			gin.H{
+				"status":  "Not Found",
+				"message": "Check up on specified date not found",
+			},
+		)
+		return
+	}
+	if record.CheckUps[index].Done {
+		ctx.JSON(http.StatusConflict,
+			gin.H{
+				"status":  "Conflict",
+				"message": "Check up is already done",
+			},
+		)
+		return
+	}
+
+	updatedRecord := *record
+	updatedRecord.CheckUps = slices.Clone(record.CheckUps)
+	updatedRecord.CheckUps[index].Done = true
+	updatedRecord.CheckUps[index].Notes = completion.Notes
+
+	// Record with completed check up is validated and stored in the same way as record updated by UpdateRecord
+	this.saveUpdatedRecord(ctx, db, recordId, updatedRecord, &record.Version)
 }
 
-// GetRecordAll - Provides list of all PN records
-func (this *implPnRegistryRecordsAPI) GetRecordAll(ctx *gin.Context) {
+// ContinueRecord - Saves follow-up PN record continuing specific PN record
+func (this *implPnRegistryRecordsAPI) ContinueRecord(ctx *gin.Context) {
 	value, exists := ctx.Get("db_service")
 	if !exists {
-		ctx.JSON(
-			http.StatusInternalServerError,
+		ctx.JSON(http.StatusInternalServerError,
 			gin.H{
 				"status":  "Internal Server Error",
 				"message": "db not found",
@@ -287,8 +483,7 @@ func (this *implPnRegistryRecordsAPI) GetRecordAll(ctx *gin.Context) {
 
 	db, ok := value.(db_service.DbService[Record])
 	if !ok {
-		ctx.JSON(
-			http.StatusInternalServerError,
+		ctx.JSON(http.StatusInternalServerError,
 			gin.H{
 				"status":  "Internal Server Error",
 				"message": "db_service context is not of type db_service.DbService",
@@ -297,24 +492,1534 @@ func (this *implPnRegistryRecordsAPI) GetRecordAll(ctx *gin.Context) {
 		return
 	}
 
-	records, err := db.FindDocuments(ctx, "", nil)
+	continuation := RecordContinuation{}
 
-	switch err {
-	case nil:
-		ctx.JSON(
-			http.StatusOK,
-			records,
-		)
-	default:
-		ctx.JSON(
+	// Fields validation
+	if err := ctx.ShouldBindJSON(&continuation); err != nil {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "Invalid request body",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+
+	// Dates validation
+	if continuation.CheckUp != nil && continuation.ValidFrom.After(*continuation.CheckUp) {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "'Check Up' date can only be on or after 'Valid from' date",
+			},
+		)
+		return
+	}
+	if continuation.ValidFrom.After(continuation.ValidUntil) {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "'Valid until' date can only be on or after 'Valid from' date",
+			},
+		)
+		return
+	}
+
+	recordId := ctx.Param("recordId")
+
+	// Serialize requests for the same patient, so that checks and writes of the records are atomic
+	predecessor, unlock, err := lockRecord(ctx, db, recordId)
+	if err == nil {
+		defer unlock()
+		if predecessor.Deleted != nil {
+			err = db_service.ErrNotFound
+		}
+	} else if errors.Is(err, errLockUnavailable) {
+		lockError(err).respond(ctx)
+		return
+	}
+
+	switch err {
+	case nil:
+	case db_service.ErrNotFound:
+		ctx.JSON(http.StatusNotFound,
+			gin.H{
+				"status":  "Not Found",
+				"message": "Record with specified ID not found",
+				"error":   err.Error(),
+			},
+		)
+		return
+	default:
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to load record from database",
+				"error":   err.Error(),
+			})
+		return
+	}
+
+	// Optimistic concurrency - client can require continuation of specific version of the record
+	if ifMatch := ctx.GetHeader("If-Match"); ifMatch != "" && !etagMatches(ifMatch, predecessor.ETag(), false) {
+		ctx.JSON(http.StatusPreconditionFailed,
+			gin.H{
+				"status":  "Precondition Failed",
+				"message": "Record was modified, version does not match If-Match header",
+			},
+		)
+		return
+	}
+
+	// Only ongoing sick leave can be continued, continued record becomes extended
+	if !predecessor.CanTransitionTo(StatusExtended) {
+		ctx.JSON(http.StatusConflict,
+			gin.H{
+				"status":  "Conflict",
+				"message": fmt.Sprintf("Record in state '%s' can not be continued", predecessor.LifecycleStatus()),
+			},
+		)
+		return
+	}
+
+	// Contiguity validation - follow-up starts during validity of the record or right after it, without gap
+	if !continuation.ValidFrom.After(predecessor.ValidFrom) || continuation.ValidFrom.After(predecessor.ValidUntil.AddDays(1)) {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "'Valid from' date of follow-up record must be after 'Valid from' date of continued record and at latest the day after its 'Valid until' date",
+			},
+		)
+		return
+	}
+
+	// Follow-up record continues the same sick leave of the same patient
+	newRecord := *predecessor
+	newRecord.Id = continuation.Id
+	newRecord.Issued = continuation.Issued
+	newRecord.ValidFrom = continuation.ValidFrom
+	newRecord.ValidUntil = continuation.ValidUntil
+	newRecord.CheckUp = continuation.CheckUp
+	newRecord.CheckUpDone = false
+	newRecord.CheckUps = nil
+	newRecord.normalizeCheckUps()
+	newRecord.stampCheckUps(time.Now().UTC())
+	newRecord.Status = StatusActive
+	newRecord.PredecessorId = predecessor.Id
+	newRecord.EpisodeId = predecessor.EpisodeKey()
+	newRecord.Version = 1
+
+	// Reason validation - follow-up must satisfy rules of its reason on its own
+	if conflict := reasonConflict(newRecord); conflict != "" {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": conflict,
+			},
+		)
+		return
+	}
+
+	if newRecord.Id == "" || newRecord.Id == "@new" {
+		newRecord.Id = uuid.New().String()
+	}
+
+	// Continued record ends the day before its follow-up starts
+	continuedRecord := *predecessor
+	continuedRecord.ValidUntil = continuation.ValidFrom.AddDays(-1)
+	continuedRecord.Status = StatusExtended
+	continuedRecord.Version = predecessor.Version + 1
+
+	// Date validity overlap validation - any other patient's record valid on or after start of follow-up is conflict,
+	// it includes follow-up record which already continues the record
+	_, overlapping, err := db.QueryDocuments(ctx, db_service.Query{
+		Filter: db_service.And(
+			db_service.Eq("patientId", predecessor.PatientId),
+			db_service.Ne("id", predecessor.Id),
+			db_service.Gte("validUntil", newRecord.ValidFrom),
+			notDeleted(),
+		),
+		Projection: []string{"id"},
+		Limit:      1,
+	})
+
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to fetch existing records",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+
+	if overlapping != 0 {
+		ctx.JSON(http.StatusConflict,
+			gin.H{
+				"status":  "Conflict",
+				"message": "Patient already has more up-to-date record or their validity overlap",
+			},
+		)
+		return
+	}
+
+	// Follow-up record must not exist without its continued record being extended, so both are written at once
+	writeErrors, err := db.BulkWrite(ctx, []db_service.WriteOperation[Record]{
+		{Kind: db_service.WriteCreate, Id: newRecord.Id, Document: &newRecord},
+		{Kind: db_service.WriteUpdate, Id: predecessor.Id, Document: &continuedRecord, Condition: versionCondition(predecessor.Version)},
+	}, true)
+
+	switch {
+	case err != nil:
+		bulkWriteError("Failed to write records to database", err).respond(ctx)
+	case writeErrors[0] != nil && writeErrors[0] != db_service.ErrRolledBack:
+		createWriteError(writeErrors[0]).respond(ctx)
+	case writeErrors[1] != nil && writeErrors[1] != db_service.ErrRolledBack:
+		updateWriteError(writeErrors[1]).respond(ctx)
+	default:
+		recordAudit(ctx, AuditActionCreate, newRecord.Id, nil, &newRecord)
+		recordAudit(ctx, AuditActionUpdate, predecessor.Id, predecessor, &continuedRecord)
+		ctx.Header("ETag", newRecord.ETag())
+		ctx.JSON(http.StatusCreated, newRecord)
+	}
+}
+
+// CreateRecord - Saves new PN record into list of all PN records
+func (this *implPnRegistryRecordsAPI) CreateRecord(ctx *gin.Context) {
+	value, exists := ctx.Get("db_service")
+	if !exists {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db not found",
+				"error":   "db not found",
+			})
+		return
+	}
+
+	db, ok := value.(db_service.DbService[Record])
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db_service context is not of type db_service.DbService",
+				"error":   "cannot cast db_service context to db_service.DbService",
+			})
+		return
+	}
+
+	newRecord := Record{}
+
+	// Fields validation
+	if err := ctx.ShouldBindJSON(&newRecord); err != nil {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "Invalid request body",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+
+	// Serialize requests for the same patient and employer, so that conflict checks and write of the record are atomic
+	unlock, err := patientLocks.Lock(ctx, newRecord.PatientId)
+	if err != nil {
+		lockError(err).respond(ctx)
+		return
+	}
+	defer unlock()
+	if newRecord.EmployerId != "" {
+		unlockEmployer, err := employerLocks.Lock(ctx, newRecord.EmployerId)
+		if err != nil {
+			lockError(err).respond(ctx)
+			return
+		}
+		defer unlockEmployer()
+	}
+
+	if recordErr := this.prepareNewRecord(ctx, db, &newRecord, nil); recordErr != nil {
+		recordErr.respond(ctx)
+		return
+	}
+
+	// Dreate new record in db
+	err = db.CreateDocument(ctx, newRecord.Id, &newRecord)
+	if err != nil {
+		createWriteError(err).respond(ctx)
+		return
+	}
+
+	recordAudit(ctx, AuditActionCreate, newRecord.Id, nil, &newRecord)
+	ctx.Header("ETag", newRecord.ETag())
+	ctx.JSON(
+		http.StatusCreated,
+		newRecord,
+	)
+}
+
+// DeleteRecord - Deletes specific PN record
+func (this *implPnRegistryRecordsAPI) DeleteRecord(ctx *gin.Context) {
+	value, exists := ctx.Get("db_service")
+	if !exists {
+		ctx.JSON(
+			http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db not found",
+				"error":   "db not found",
+			})
+		return
+	}
+
+	db, ok := value.(db_service.DbService[Record])
+	if !ok {
+		ctx.JSON(
+			http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db_service context is not of type db_service.DbService",
+				"error":   "cannot cast db_service context to db_service.DbService",
+			})
+		return
+	}
+
+	recordId := ctx.Param("recordId")
+
+	record, deletedRecord, recordErr := this.prepareDeletedRecord(ctx, db, recordId, ctx.GetHeader("If-Match"), ctx.Query("reason"))
+	if recordErr != nil {
+		recordErr.respond(ctx)
+		return
+	}
+
+	// Write succeeds only if record was not modified since it was loaded
+	if err := db.UpdateDocumentIf(ctx, recordId, versionCondition(record.Version), deletedRecord); err != nil {
+		deleteWriteError(err).respond(ctx)
+		return
+	}
+
+	recordAudit(ctx, AuditActionDelete, recordId, record, deletedRecord)
+	ctx.AbortWithStatus(http.StatusNoContent)
+}
+
+// ExportRecords - Exports PN records matching the filters as CSV or NDJSON
+func (this *implPnRegistryRecordsAPI) ExportRecords(ctx *gin.Context) {
+	value, exists := ctx.Get("db_service")
+	if !exists {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db not found",
+				"error":   "db not found",
+			})
+		return
+	}
+
+	db, ok := value.(db_service.DbService[Record])
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db_service context is not of type db_service.DbService",
+				"error":   "cannot cast db_service context to db_service.DbService",
+			})
+		return
+	}
+
+	format := ctx.DefaultQuery("format", "csv")
+	contentType, supported := exportContentTypes[format]
+	if !supported {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "Invalid query parameter",
+				"error":   "Parameter 'format' must be csv or ndjson",
+			},
+		)
+		return
+	}
+
+	// Same paging, sorting and filters as list of all records
+	query, err := parseRecordQuery(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "Invalid query parameter",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+	if len(query.Sort) == 0 {
+		query.Sort = []db_service.SortField{{Field: "id"}}
+	}
+
+	// Caller restricted to some fields of records gets only their columns
+	scope, _ := scopeFromContext(ctx)
+	columns := scope.csvColumns()
+
+	// Response is started with the first record, so that failure of the query can still be reported as error
+	started := false
+	exported := 0
+	csvWriter := csv.NewWriter(ctx.Writer)
+	start := func() error {
+		started = true
+		ctx.Header("Content-Type", contentType)
+		ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"records.%s\"", format))
+		ctx.Status(http.StatusOK)
+		if format == "csv" {
+			return csvWriter.Write(columns)
+		}
+		return nil
+	}
+
+	err = db.StreamDocuments(ctx, query, func(record *Record) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+
+		if format == "csv" {
+			if err := csvWriter.Write(scope.limitCsvRow(recordCsvRow(*record))); err != nil {
+				return err
+			}
+		} else {
+			line, err := json.Marshal(scope.limit(*record))
+			if err != nil {
+				return err
+			}
+			if _, err := ctx.Writer.Write(append(line, '\n')); err != nil {
+				return err
+			}
+		}
+
+		exported++
+		if exported%exportFlushInterval == 0 {
+			csvWriter.Flush()
+			ctx.Writer.Flush()
+		}
+		return nil
+	})
+
+	if err == nil && !started {
+		err = start()
+	}
+	csvWriter.Flush()
+	if err == nil {
+		err = csvWriter.Error()
+	}
+
+	switch {
+	case err == nil:
+	case !started && errors.Is(err, db_service.ErrEncryptedField):
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "Invalid query parameter",
+				"error":   err.Error(),
+			},
+		)
+	case !started:
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to load records from database",
+				"error":   err.Error(),
+			},
+		)
+	default:
+		// status was already sent, the client receives incomplete export
+		log.Printf("Export of records failed after %v records: %v", exported, err)
+		ctx.Error(err)
+	}
+}
+
+// ExtendRecord - Extends validity of active PN record
+func (this *implPnRegistryRecordsAPI) ExtendRecord(ctx *gin.Context) {
+	value, exists := ctx.Get("db_service")
+	if !exists {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db not found",
+				"error":   "db not found",
+			})
+		return
+	}
+
+	db, ok := value.(db_service.DbService[Record])
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db_service context is not of type db_service.DbService",
+				"error":   "cannot cast db_service context to db_service.DbService",
+			})
+		return
+	}
+
+	extension := RecordExtension{}
+
+	// Fields validation
+	if err := ctx.ShouldBindJSON(&extension); err != nil {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "Invalid request body",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+
+	this.transitionRecord(ctx, db, ctx.Param("recordId"), StatusExtended, func(record *Record) bool {
+		if !extension.ValidUntil.After(record.ValidUntil) {
+			ctx.JSON(http.StatusBadRequest,
+				gin.H{
+					"status":  "Bad Request",
+					"message": "Extended 'Valid until' date can only be after current 'Valid until' date of the record",
+				},
+			)
+			return false
+		}
+
+		// Extended record must still satisfy rules of its reason, e.g. maximum duration
+		extendedRecord := *record
+		extendedRecord.ValidUntil = extension.ValidUntil
+		if conflict := reasonConflict(extendedRecord); conflict != "" {
+			ctx.JSON(http.StatusBadRequest,
+				gin.H{
+					"status":  "Bad Request",
+					"message": conflict,
+				},
+			)
+			return false
+		}
+
+		// Extended validity must not overlap with patient's other records
+		_, overlapping, err := db.QueryDocuments(ctx, db_service.Query{
+			Filter: db_service.And(
+				db_service.Eq("patientId", record.PatientId),
+				db_service.Ne("id", record.Id),
+				db_service.Lte("validFrom", extension.ValidUntil),
+				db_service.Gte("validUntil", record.ValidFrom),
+				notDeleted(),
+			),
+			Projection: []string{"id"},
+			Limit:      1,
+		})
+
+		if err != nil {
+			ctx.JSON(http.StatusBadGateway,
+				gin.H{
+					"status":  "Bad Gateway",
+					"message": "Failed to fetch existing records",
+					"error":   err.Error(),
+				},
+			)
+			return false
+		}
+
+		if overlapping != 0 {
+			ctx.JSON(http.StatusConflict,
+				gin.H{
+					"status":  "Conflict",
+					"message": "Extended record would overlap with patient's other records",
+				},
+			)
+			return false
+		}
+
+		record.ValidUntil = extension.ValidUntil
+		return true
+	})
+}
+
+// GetRecord - Provides details about specific PN record
+func (this *implPnRegistryRecordsAPI) GetRecord(ctx *gin.Context) {
+	value, exists := ctx.Get("db_service")
+	if !exists {
+		ctx.JSON(
+			http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db not found",
+				"error":   "db not found",
+			})
+		return
+	}
+
+	db, ok := value.(db_service.DbService[Record])
+	if !ok {
+		ctx.JSON(
+			http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db_service context is not of type db_service.DbService",
+				"error":   "cannot cast db_service context to db_service.DbService",
+			})
+		return
+	}
+
+	recordId := ctx.Param("recordId")
+
+	if recordId == "" {
+		ctx.JSON(
+			http.StatusBadRequest,
+			gin.H{
+				"status":  http.StatusBadRequest,
+				"message": "Record ID is required",
+			})
+		return
+	}
+
+	// Historical state of the record is reconstructed from its audit trail
+	if asOfParam := ctx.Query("asOf"); asOfParam != "" {
+		asOf, err := time.Parse(time.RFC3339, asOfParam)
+		if err != nil {
+			ctx.JSON(
+				http.StatusBadRequest,
+				gin.H{
+					"status":  "Bad Request",
+					"message": "Invalid query parameter",
+					"error":   "Parameter 'asOf' must be a timestamp in RFC 3339 format",
+				})
+			return
+		}
+
+		value, exists = ctx.Get("audit_service")
+		if !exists {
+			ctx.JSON(
+				http.StatusInternalServerError,
+				gin.H{
+					"status":  "Internal Server Error",
+					"message": "audit db not found",
+					"error":   "audit db not found",
+				})
+			return
+		}
+
+		auditDb, ok := value.(db_service.DbService[AuditEntry])
+		if !ok {
+			ctx.JSON(
+				http.StatusInternalServerError,
+				gin.H{
+					"status":  "Internal Server Error",
+					"message": "audit_service context is not of type db_service.DbService",
+					"error":   "cannot cast audit_service context to db_service.DbService",
+				})
+			return
+		}
+
+		record, err := recordAsOf(ctx, auditDb, recordId, asOf)
+		scope, scoped := scopeFromContext(ctx)
+		if err == nil && scoped && !scope.allows(*record) {
+			err = db_service.ErrNotFound
+		}
+
+		switch err {
+		case nil:
+			ctx.JSON(
+				http.StatusOK,
+				scope.limit(*record),
+			)
+		case db_service.ErrNotFound:
+			ctx.JSON(
+				http.StatusNotFound,
+				gin.H{
+					"status":  "Not Found",
+					"message": "Record with specified ID did not exist at specified time",
+					"error":   err.Error(),
+				},
+			)
+		default:
+			ctx.JSON(
+				http.StatusBadGateway,
+				gin.H{
+					"status":  "Bad Gateway",
+					"message": "Failed to load record history from database",
+					"error":   err.Error(),
+				})
+		}
+		return
+	}
+
+	record, err := db.FindDocument(ctx, recordId)
+	if err == nil && record.Deleted != nil {
+		err = db_service.ErrNotFound
+	}
+
+	// Records outside of caller's scope are reported as not existing
+	scope, scoped := scopeFromContext(ctx)
+	if err == nil && scoped && !scope.allows(*record) {
+		err = db_service.ErrNotFound
+	}
+
+	switch err {
+	case nil:
+		ctx.Header("ETag", record.ETag())
+		if ifNoneMatch := ctx.GetHeader("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, record.ETag(), true) {
+			ctx.AbortWithStatus(http.StatusNotModified)
+			return
+		}
+		ctx.JSON(
+			http.StatusOK,
+			scope.limit(*record),
+		)
+	case db_service.ErrNotFound:
+		ctx.JSON(
+			http.StatusNotFound,
+			gin.H{
+				"status":  "Not Found",
+				"message": "Record with specified ID not found",
+				"error":   err.Error(),
+			},
+		)
+	default:
+		ctx.JSON(
+			http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to load record from database",
+				"error":   err.Error(),
+			})
+	}
+}
+
+// GetRecordAll - Provides list of all PN records
+func (this *implPnRegistryRecordsAPI) GetRecordAll(ctx *gin.Context) {
+	value, exists := ctx.Get("db_service")
+	if !exists {
+		ctx.JSON(
+			http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db not found",
+				"error":   "db not found",
+			})
+		return
+	}
+
+	db, ok := value.(db_service.DbService[Record])
+	if !ok {
+		ctx.JSON(
+			http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db_service context is not of type db_service.DbService",
+				"error":   "cannot cast db_service context to db_service.DbService",
+			})
+		return
+	}
+
+	query, err := parseRecordQuery(ctx)
+	if err != nil {
+		ctx.JSON(
+			http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "Invalid query parameter",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+
+	records, total, err := db.QueryDocuments(ctx, query)
+	scope, _ := scopeFromContext(ctx)
+
+	switch {
+	case err == nil:
+		ctx.Header("X-Total-Count", strconv.FormatInt(total, 10))
+		ctx.JSON(
+			http.StatusOK,
+			scope.limitAll(records),
+		)
+	case errors.Is(err, db_service.ErrEncryptedField):
+		// encrypted personal data can not be sorted or filtered by their value
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "Invalid query parameter",
+				"error":   err.Error(),
+			},
+		)
+	default:
+		ctx.JSON(
+			http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to load all records from database",
+				"error":   err.Error(),
+			},
+		)
+	}
+}
+
+// Utility function which matches path of record calendar '/records/{recordId}.ics', router cannot match suffix
+// of path parameter. Other paths of the record without trailing slash are redirected as the router would do.
+func recordCalendarPath(ctx *gin.Context) {
+	recordId, isCalendar := strings.CutSuffix(ctx.Param("recordId"), ".ics")
+	if !isCalendar {
+		location := *ctx.Request.URL
+		location.Path += "/"
+		ctx.Redirect(http.StatusMovedPermanently, location.String())
+		ctx.Abort()
+		return
+	}
+
+	for i := range ctx.Params {
+		if ctx.Params[i].Key == "recordId" {
+			ctx.Params[i].Value = recordId
+		}
+	}
+	ctx.Next()
+}
+
+// GetRecordCalendar - Provides validity period and check ups of specific PN record in iCalendar format
+func (this *implPnRegistryRecordsAPI) GetRecordCalendar(ctx *gin.Context) {
+	value, exists := ctx.Get("db_service")
+	if !exists {
+		ctx.JSON(
+			http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db not found",
+				"error":   "db not found",
+			})
+		return
+	}
+
+	db, ok := value.(db_service.DbService[Record])
+	if !ok {
+		ctx.JSON(
+			http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db_service context is not of type db_service.DbService",
+				"error":   "cannot cast db_service context to db_service.DbService",
+			})
+		return
+	}
+
+	recordId := ctx.Param("recordId")
+
+	record, err := db.FindDocument(ctx, recordId)
+	if err == nil && record.Deleted != nil {
+		err = db_service.ErrNotFound
+	}
+
+	switch err {
+	case nil:
+		events := []calendarEvent{recordValidityEvent(*record)}
+		for _, checkUp := range record.CheckUps {
+			events = append(events, recordCheckUpEvent(*record, checkUp))
+		}
+		ctx.Data(http.StatusOK, calendarContentType, renderCalendar("PN "+record.FullName, events, time.Now()))
+	case db_service.ErrNotFound:
+		ctx.JSON(
+			http.StatusNotFound,
+			gin.H{
+				"status":  "Not Found",
+				"message": "Record with specified ID not found",
+				"error":   err.Error(),
+			},
+		)
+	default:
+		ctx.JSON(
+			http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to load record from database",
+				"error":   err.Error(),
+			})
+	}
+}
+
+// GetRecordEpisode - Provides whole sick leave episode of specific PN record
+func (this *implPnRegistryRecordsAPI) GetRecordEpisode(ctx *gin.Context) {
+	value, exists := ctx.Get("db_service")
+	if !exists {
+		ctx.JSON(
+			http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db not found",
+				"error":   "db not found",
+			})
+		return
+	}
+
+	db, ok := value.(db_service.DbService[Record])
+	if !ok {
+		ctx.JSON(
+			http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db_service context is not of type db_service.DbService",
+				"error":   "cannot cast db_service context to db_service.DbService",
+			})
+		return
+	}
+
+	record, err := db.FindDocument(ctx, ctx.Param("recordId"))
+	if err == nil && record.Deleted != nil {
+		err = db_service.ErrNotFound
+	}
+
+	var records []Record
+	if err == nil {
+		// Episode consists of the first record of the chain and all its follow-ups
+		records, _, err = db.QueryDocuments(ctx, db_service.Query{
+			Filter: db_service.And(episodeCondition(record.EpisodeKey()), notDeleted()),
+			Sort:   []db_service.SortField{{Field: "validFrom"}, {Field: "id"}},
+		})
+	}
+
+	switch err {
+	case nil:
+		ctx.JSON(
+			http.StatusOK,
+			newEpisode(record.EpisodeKey(), records),
+		)
+	case db_service.ErrNotFound:
+		ctx.JSON(
+			http.StatusNotFound,
+			gin.H{
+				"status":  "Not Found",
+				"message": "Record with specified ID not found",
+				"error":   err.Error(),
+			},
+		)
+	default:
+		ctx.JSON(
 			http.StatusBadGateway,
 			gin.H{
 				"status":  "Bad Gateway",
-				"message": "Failed to load all records from database",
+				"message": "Failed to load records of episode from database",
+				"error":   err.Error(),
+			})
+	}
+}
+
+// GetRecordHistory - Provides history of changes of specific PN record
+func (this *implPnRegistryRecordsAPI) GetRecordHistory(ctx *gin.Context) {
+	value, exists := ctx.Get("audit_service")
+	if !exists {
+		ctx.JSON(
+			http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "audit db not found",
+				"error":   "audit db not found",
+			})
+		return
+	}
+
+	auditDb, ok := value.(db_service.DbService[AuditEntry])
+	if !ok {
+		ctx.JSON(
+			http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "audit_service context is not of type db_service.DbService",
+				"error":   "cannot cast audit_service context to db_service.DbService",
+			})
+		return
+	}
+
+	recordId := ctx.Param("recordId")
+
+	entries, _, err := auditDb.QueryDocuments(ctx, db_service.Query{
+		Filter: db_service.Eq("recordId", recordId),
+		Sort: []db_service.SortField{
+			{Field: "timestamp"},
+			{Field: "version"},
+		},
+	})
+
+	switch {
+	case err != nil:
+		ctx.JSON(
+			http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to load record history from database",
+				"error":   err.Error(),
+			})
+	case len(entries) == 0:
+		ctx.JSON(
+			http.StatusNotFound,
+			gin.H{
+				"status":  "Not Found",
+				"message": "History of record with specified ID not found",
+			})
+	default:
+		ctx.JSON(
+			http.StatusOK,
+			entries,
+		)
+	}
+}
+
+// ImportRecords - Imports PN records from CSV or NDJSON file
+func (this *implPnRegistryRecordsAPI) ImportRecords(ctx *gin.Context) {
+	value, exists := ctx.Get("db_service")
+	if !exists {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db not found",
+				"error":   "db not found",
+			})
+		return
+	}
+
+	db, ok := value.(db_service.DbService[Record])
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db_service context is not of type db_service.DbService",
+				"error":   "cannot cast db_service context to db_service.DbService",
+			})
+		return
+	}
+
+	format := importFormat(ctx.Query("format"), ctx.ContentType())
+	if format == "" {
+		ctx.JSON(http.StatusUnsupportedMediaType,
+			gin.H{
+				"status":  "Unsupported Media Type",
+				"message": "Unsupported import format",
+				"error":   "Parameter 'format' must be csv or ndjson, or Content-Type must be text/csv or application/x-ndjson",
+			},
+		)
+		return
+	}
+
+	mode := ctx.DefaultQuery("mode", ImportModeAllOrNothing)
+	dryRun, err := strconv.ParseBool(ctx.DefaultQuery("dryRun", "false"))
+	if err != nil || (mode != ImportModeAllOrNothing && mode != ImportModeBestEffort) {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "Invalid query parameter",
+				"error":   "Parameter 'dryRun' must be true or false and parameter 'mode' must be all-or-nothing or best-effort",
+			},
+		)
+		return
+	}
+
+	rows, err := parseImportRows(format, ctx.Request.Body)
+	// dry run only validates the rows, so it is limited only by maximal number of rows of the import
+	if err == nil && mode == ImportModeAllOrNothing && !dryRun && len(rows) > importMaxAtomicRows {
+		err = errAtomicImportTooLarge
+	}
+	if err == errImportTooLarge || err == errAtomicImportTooLarge {
+		ctx.JSON(http.StatusRequestEntityTooLarge,
+			gin.H{
+				"status":  "Request Entity Too Large",
+				"message": "Too many rows to import",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "Invalid request body",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+
+	// Serialize import with other requests for the same patients and employers for whole duration of the import
+	patientIds := []string{}
+	employerIds := []string{}
+	for _, row := range rows {
+		if row.Err == nil {
+			patientIds = append(patientIds, row.Record.PatientId)
+			if row.Record.EmployerId != "" {
+				employerIds = append(employerIds, row.Record.EmployerId)
+			}
+		}
+	}
+	unlock, err := patientLocks.Lock(ctx, patientIds...)
+	if err != nil {
+		lockError(err).respond(ctx)
+		return
+	}
+	defer unlock()
+	unlockEmployers, err := employerLocks.Lock(ctx, employerIds...)
+	if err != nil {
+		lockError(err).respond(ctx)
+		return
+	}
+	defer unlockEmployers()
+
+	report := ImportReport{
+		DryRun: dryRun,
+		Mode:   mode,
+		Total:  len(rows),
+		Rows:   make([]ImportRowResult, len(rows)),
+	}
+
+	// Rows are validated in chronological order, so that patient's history can be imported regardless of order of rows
+	order := make([]int, len(rows))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return time.Time(rows[a].Record.ValidFrom).Compare(time.Time(rows[b].Record.ValidFrom))
+	})
+
+	accepted := []Record{}
+	acceptedRows := []int{}
+	for _, i := range order {
+		row := rows[i]
+		result := &report.Rows[i]
+		result.Row = row.Row
+		if row.Record.Id != "@new" {
+			result.Id = row.Record.Id
+		}
+
+		if row.Err != nil {
+			result.Status = ImportRowRejected
+			result.Message = "Invalid record"
+			result.Error = row.Err.Error()
+			continue
+		}
+
+		recordErr := this.checkImportedId(ctx, db, row.Record.Id, accepted)
+		if recordErr == nil {
+			recordErr = this.prepareNewRecord(ctx, db, &row.Record, accepted)
+		}
+
+		if recordErr != nil && recordErr.isServerError() {
+			recordErr.respond(ctx)
+			return
+		}
+
+		switch {
+		case recordErr == nil:
+			result.Id = row.Record.Id
+			result.Status = ImportRowAccepted
+			accepted = append(accepted, row.Record)
+			acceptedRows = append(acceptedRows, i)
+		case recordErr.Status == http.StatusConflict:
+			result.Status = ImportRowConflicting
+			result.Message = recordErr.Message
+		default:
+			result.Status = ImportRowRejected
+			result.Message = recordErr.Message
+		}
+		if recordErr != nil && recordErr.Err != nil {
+			result.Error = recordErr.Err.Error()
+		}
+	}
+
+	// Dry run reports result of validation of all rows, even when all-or-nothing import would be rejected
+	if dryRun {
+		report.countRows()
+		ctx.JSON(http.StatusOK, report)
+		return
+	}
+
+	if mode == ImportModeAllOrNothing && len(accepted) != len(rows) {
+		report.countRows()
+		ctx.JSON(http.StatusUnprocessableEntity, report)
+		return
+	}
+
+	// All-or-nothing import is written atomically, so that it is never applied partially
+	writes := make([]db_service.WriteOperation[Record], len(accepted))
+	for j := range accepted {
+		writes[j] = db_service.WriteOperation[Record]{Kind: db_service.WriteCreate, Id: accepted[j].Id, Document: &accepted[j]}
+	}
+
+	writeErrors, err := db.BulkWrite(ctx, writes, mode == ImportModeAllOrNothing)
+	if err != nil {
+		bulkWriteError("Failed to create records in database", err).respond(ctx)
+		return
+	}
+
+	created := []Record{}
+	for j, err := range writeErrors {
+		result := &report.Rows[acceptedRows[j]]
+		switch err {
+		case nil:
+			created = append(created, accepted[j])
+			continue
+		case db_service.ErrRolledBack:
+			continue
+		case db_service.ErrConflict:
+			result.Status = ImportRowConflicting
+			result.Message = "Record already exists"
+		default:
+			if mode == ImportModeAllOrNothing {
+				ctx.JSON(http.StatusBadGateway,
+					gin.H{
+						"status":  "Bad Gateway",
+						"message": "Failed to create record in database, import was rolled back",
+						"error":   err.Error(),
+					},
+				)
+				return
+			}
+			result.Status = ImportRowRejected
+			result.Message = "Failed to create record in database"
+		}
+		result.Error = err.Error()
+	}
+
+	// record created meanwhile by other request caused rollback of all-or-nothing import
+	if mode == ImportModeAllOrNothing && len(created) != len(accepted) {
+		report.countRows()
+		ctx.JSON(http.StatusUnprocessableEntity, report)
+		return
+	}
+
+	for i := range created {
+		recordAudit(ctx, AuditActionCreate, created[i].Id, nil, &created[i])
+	}
+
+	report.Committed = true
+	report.countRows()
+	ctx.JSON(http.StatusOK, report)
+}
+
+// PatchRecord - Partially updates specific PN record
+func (this *implPnRegistryRecordsAPI) PatchRecord(ctx *gin.Context) {
+	value, exists := ctx.Get("db_service")
+	if !exists {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db not found",
+				"error":   "db not found",
+			})
+		return
+	}
+
+	db, ok := value.(db_service.DbService[Record])
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db_service context is not of type db_service.DbService",
+				"error":   "cannot cast db_service context to db_service.DbService",
+			})
+		return
+	}
+
+	recordId := ctx.Param("recordId")
+
+	patch, err := ctx.GetRawData()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "Invalid request body",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+
+	storedRecord, err := db.FindDocument(ctx, recordId)
+	if err == nil && storedRecord.Deleted != nil {
+		err = db_service.ErrNotFound
+	}
+
+	switch err {
+	case nil:
+	case db_service.ErrNotFound:
+		ctx.JSON(http.StatusNotFound,
+			gin.H{
+				"status":  "Not Found",
+				"message": "Record with specified ID not found",
+				"error":   err.Error(),
+			},
+		)
+		return
+	default:
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to load record from database",
+				"error":   err.Error(),
+			})
+		return
+	}
+
+	// Apply patch on JSON representation of stored record
+	patchedRecord, err := applyRecordPatch(*storedRecord, ctx.ContentType(), patch)
+
+	switch {
+	case err == errUnsupportedPatchType:
+		ctx.JSON(http.StatusUnsupportedMediaType,
+			gin.H{
+				"status":  "Unsupported Media Type",
+				"message": "Patch must be of type application/merge-patch+json or application/json-patch+json",
+				"error":   err.Error(),
+			},
+		)
+		return
+	case err != nil:
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "Invalid request body",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+
+	// Patched record is validated and stored in the same way as record updated by UpdateRecord
+	this.saveUpdatedRecord(ctx, db, recordId, patchedRecord, &storedRecord.Version)
+}
+
+// RestoreRecord - Restores deleted PN record
+func (this *implPnRegistryRecordsAPI) RestoreRecord(ctx *gin.Context) {
+	value, exists := ctx.Get("db_service")
+	if !exists {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db not found",
+				"error":   "db not found",
+			})
+		return
+	}
+
+	db, ok := value.(db_service.DbService[Record])
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db_service context is not of type db_service.DbService",
+				"error":   "cannot cast db_service context to db_service.DbService",
+			})
+		return
+	}
+
+	recordId := ctx.Param("recordId")
+
+	record, err := db.FindDocument(ctx, recordId)
+
+	switch err {
+	case nil:
+	case db_service.ErrNotFound:
+		ctx.JSON(http.StatusNotFound,
+			gin.H{
+				"status":  "Not Found",
+				"message": "Record with specified ID not found",
+				"error":   err.Error(),
+			},
+		)
+		return
+	default:
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to load record from database",
+				"error":   err.Error(),
+			})
+		return
+	}
+
+	if record.Deleted == nil {
+		ctx.JSON(http.StatusConflict,
+			gin.H{
+				"status":  "Conflict",
+				"message": "Record is not deleted",
+			},
+		)
+		return
+	}
+
+	// Serialize requests for the same patient, so that conflict checks and write of the record are atomic
+	unlock, err := patientLocks.Lock(ctx, record.PatientId)
+	if err != nil {
+		lockError(err).respond(ctx)
+		return
+	}
+	defer unlock()
+
+	// Patient's records could change while the record was deleted, restored record must not conflict with them
+	otherRecordsFilter := db_service.And(
+		db_service.Eq("patientId", record.PatientId),
+		db_service.Ne("id", recordId),
+		notDeleted(),
+	)
+
+	_, conflicting, err := db.QueryDocuments(ctx, db_service.Query{
+		Filter: db_service.And(
+			otherRecordsFilter,
+			db_service.Or(
+				db_service.Ne("fullName", record.FullName),
+				db_service.And(
+					db_service.Lte("validFrom", record.ValidUntil),
+					db_service.Gte("validUntil", record.ValidFrom),
+				),
+			),
+		),
+		Projection: []string{"id"},
+		Limit:      1,
+	})
+
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to fetch existing records",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+
+	if conflicting != 0 {
+		ctx.JSON(http.StatusConflict,
+			gin.H{
+				"status":  "Conflict",
+				"message": "Restored record would conflict with patient's existing records (validity overlap or different Full Name)",
+			},
+		)
+		return
+	}
+
+	restoredRecord := *record
+	restoredRecord.Version = record.Version + 1
+	restoredRecord.Deleted = nil
+
+	err = db.UpdateDocumentIf(ctx, recordId, versionCondition(record.Version), &restoredRecord)
+
+	switch err {
+	case nil:
+		recordAudit(ctx, AuditActionRestore, recordId, record, &restoredRecord)
+		ctx.Header("ETag", restoredRecord.ETag())
+		ctx.JSON(http.StatusOK, restoredRecord)
+	case db_service.ErrConditionFailed:
+		ctx.JSON(http.StatusPreconditionFailed,
+			gin.H{
+				"status":  "Precondition Failed",
+				"message": "Record was modified by another request, load it and try again",
+				"error":   err.Error(),
+			},
+		)
+	case db_service.ErrNotFound:
+		ctx.JSON(http.StatusNotFound,
+			gin.H{
+				"status":  "Not Found",
+				"message": "Record with specified ID not found",
+				"error":   err.Error(),
+			},
+		)
+	default:
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to restore record in database",
+				"error":   err.Error(),
+			})
+	}
+}
+
+// ScheduleCheckUp - Plans new check up of PN record
+func (this *implPnRegistryRecordsAPI) ScheduleCheckUp(ctx *gin.Context) {
+	value, exists := ctx.Get("db_service")
+	if !exists {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db not found",
+				"error":   "db not found",
+			})
+		return
+	}
+
+	db, ok := value.(db_service.DbService[Record])
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"status":  "Internal Server Error",
+				"message": "db_service context is not of type db_service.DbService",
+				"error":   "cannot cast db_service context to db_service.DbService",
+			})
+		return
+	}
+
+	checkUp := CheckUp{}
+
+	// Fields validation
+	if err := ctx.ShouldBindJSON(&checkUp); err != nil {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{
+				"status":  "Bad Request",
+				"message": "Invalid request body",
+				"error":   err.Error(),
+			},
+		)
+		return
+	}
+
+	recordId := ctx.Param("recordId")
+
+	record, err := db.FindDocument(ctx, recordId)
+	if err == nil && record.Deleted != nil {
+		err = db_service.ErrNotFound
+	}
+
+	switch err {
+	case nil:
+	case db_service.ErrNotFound:
+		ctx.JSON(http.StatusNotFound,
+			gin.H{
+				"status":  "Not Found",
+				"message": "Record with specified ID not found",
+				"error":   err.Error(),
+			},
+		)
+		return
+	default:
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to load record from database",
 				"error":   err.Error(),
+			})
+		return
+	}
+
+	if record.findCheckUp(checkUp.Date) != -1 {
+		ctx.JSON(http.StatusConflict,
+			gin.H{
+				"status":  "Conflict",
+				"message": "Check up on the same date is already planned",
 			},
 		)
+		return
 	}
+
+	// New check up is pending, it is completed by CompleteCheckUp
+	checkUp.Done = false
+	checkUp.DoneAt = nil
+
+	updatedRecord := *record
+	updatedRecord.CheckUps = append(slices.Clone(record.CheckUps), checkUp)
+
+	// Record with new check up is validated and stored in the same way as record updated by UpdateRecord
+	this.saveUpdatedRecord(ctx, db, recordId, updatedRecord, &record.Version)
 }
 
 // UpdateRecord - Updates specific PN record
@@ -355,118 +2060,510 @@ func (this *implPnRegistryRecordsAPI) UpdateRecord(ctx *gin.Context) {
 		return
 	}
 
-	// Validate dates
-	if updatedRecord.CheckUp != nil && updatedRecord.ValidFrom.After(*updatedRecord.CheckUp) {
-		ctx.JSON(http.StatusBadRequest,
-			gin.H{
-				"status":  "Bad Request",
-				"message": "'Check Up' date can only be on or after 'Valid from' date",
-			},
-		)
+	this.saveUpdatedRecord(ctx, db, ctx.Param("recordId"), updatedRecord, nil)
+}
+
+// validates updated record against patient's other records and stores it, shared by UpdateRecord and PatchRecord,
+// when baseVersion is set the record must not be modified since that version
+func (this *implPnRegistryRecordsAPI) saveUpdatedRecord(ctx *gin.Context, db db_service.DbService[Record], recordId string, updatedRecord Record, baseVersion *int64) {
+	// Serialize requests for the same patient and employer, so that conflict checks and write of the record are atomic
+	unlock, err := patientLocks.Lock(ctx, updatedRecord.PatientId)
+	if err != nil {
+		lockError(err).respond(ctx)
 		return
 	}
-	if updatedRecord.ValidFrom.After(updatedRecord.ValidUntil) {
-		ctx.JSON(http.StatusBadRequest,
-			gin.H{
-				"status":  "Bad Request",
-				"message": "'Valid until' date can only be on or after 'Valid from' date",
-			},
-		)
+	defer unlock()
+	if updatedRecord.EmployerId != "" {
+		unlockEmployer, err := employerLocks.Lock(ctx, updatedRecord.EmployerId)
+		if err != nil {
+			lockError(err).respond(ctx)
+			return
+		}
+		defer unlockEmployer()
+	}
+
+	storedRecord, recordErr := this.prepareUpdatedRecord(ctx, db, recordId, &updatedRecord, ctx.GetHeader("If-Match"), baseVersion, nil)
+	if recordErr != nil {
+		recordErr.respond(ctx)
 		return
 	}
 
-	recordId := ctx.Param("recordId")
+	// Write succeeds only if record was not modified since it was loaded
+	if err := db.UpdateDocumentIf(ctx, recordId, versionCondition(storedRecord.Version), &updatedRecord); err != nil {
+		updateWriteError(err).respond(ctx)
+		return
+	}
+
+	recordAudit(ctx, AuditActionUpdate, recordId, storedRecord, &updatedRecord)
+	ctx.Header("ETag", updatedRecord.ETag())
+	ctx.JSON(http.StatusOK, updatedRecord)
+}
+
+// validates updated record against registered patient and employer, stored version of the record and patient's
+// other records - stored ones and pending ones which are written by the same request, fills in server managed fields,
+// inherited full name and employer name, returns stored version of the record. Shared by saveUpdatedRecord and
+// BatchRecords, caller must hold locks of the patient and employer.
+func (this *implPnRegistryRecordsAPI) prepareUpdatedRecord(ctx *gin.Context, db db_service.DbService[Record], recordId string, updatedRecord *Record, ifMatch string, baseVersion *int64, pending []Record) (*Record, *recordError) {
+	// Validate dates, check-ups are validated when changes of legacy check-up fields are applied to stored ones
+	if updatedRecord.ValidFrom.After(updatedRecord.ValidUntil) {
+		return nil, newRecordError(http.StatusBadRequest, "'Valid until' date can only be on or after 'Valid from' date", nil)
+	}
+
+	// Diagnosis validation
+	if conflict := diagnosisReasonConflict(updatedRecord.Diagnosis, updatedRecord.Reason); conflict != "" {
+		return nil, newRecordError(http.StatusBadRequest, conflict, nil)
+	}
 
 	// Ensure the ID in the URL matches the ID in the request body
 	if updatedRecord.Id != recordId {
-		ctx.JSON(http.StatusBadRequest,
-			gin.H{
-				"status":  "Bad Request",
-				"message": "Record ID in URL does not match ID in request body",
-			},
-		)
-		return
+		return nil, newRecordError(http.StatusBadRequest, "Record ID in URL does not match ID in request body", nil)
 	}
 
-	// Fetching patient's records by patient ID to validate conflict with updated record
-	patientRecords, err := db.FindDocuments(ctx, "patientId", updatedRecord.PatientId)
-
+	// Registered patient is authoritative source of patient's full name
+	patient, err := findPatient(ctx, updatedRecord.PatientId)
 	if err != nil {
-		ctx.JSON(http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to fetch existing records",
-				"error":   err.Error(),
-			},
-		)
-		return
+		return nil, newRecordError(http.StatusBadGateway, "Failed to fetch patient", err)
 	}
 
-	// Full Name validation
-	if updatedRecord.FullName == "" { // if fullname not provided
-		if len(patientRecords) == 0 { // and no existing records
-			ctx.JSON(http.StatusNotFound,
-				gin.H{
-					"status":  "Not Found",
-					"message": "Patient's PN records not found, create new record (with Full Name)",
-				},
-			)
-			return
+	if patient != nil {
+		if updatedRecord.FullName == "" {
+			updatedRecord.FullName = patient.FullName
+		} else if updatedRecord.FullName != patient.FullName {
+			return nil, newRecordError(http.StatusConflict, "Cannot update Full Name for this patient's ID (rename the registered patient instead)", nil)
+		}
+	}
+
+	// Referenced employer is authoritative source of employer's name
+	if updatedRecord.EmployerId != "" {
+		employer, err := findEmployer(ctx, updatedRecord.EmployerId)
+		if err != nil {
+			return nil, newRecordError(http.StatusBadGateway, "Failed to fetch employer", err)
+		}
+
+		if employer == nil {
+			return nil, newRecordError(http.StatusNotFound, "Employer with specified employerId not found", nil)
 		}
 
-		// when records are available, set FullName from them
-		updatedRecord.FullName = patientRecords[0].FullName
+		if updatedRecord.Employer == "" {
+			updatedRecord.Employer = employer.Name
+		} else if updatedRecord.Employer != employer.Name {
+			return nil, newRecordError(http.StatusConflict, "Cannot update Employer for this employer's ID (rename the registered employer instead)", nil)
+		}
 	}
 
+	// Loading stored version of updated record, it is relevant only when record stays with the same patient
 	var recordToUpdate *Record //record we are updating but from db
-	recordIsLatest := false    //if updated record is latest for patient
+	storedRecord, err := db.FindDocument(ctx, recordId)
+
+	if err == nil && storedRecord.Deleted != nil {
+		// deleted record has to be restored first
+		err = db_service.ErrNotFound
+	}
+
+	switch err {
+	case nil:
+		if storedRecord.PatientId == updatedRecord.PatientId {
+			recordToUpdate = storedRecord
+		}
+	case db_service.ErrNotFound:
+		// reported when updating the record
+		storedRecord = nil
+	default:
+		return nil, newRecordError(http.StatusBadGateway, "Failed to fetch existing records", err)
+	}
+
+	// Closed and cancelled records are final and can not be modified
+	if storedRecord != nil && storedRecord.IsFinal() {
+		return nil, newRecordError(http.StatusConflict, fmt.Sprintf("Record in state '%s' can not be modified", storedRecord.LifecycleStatus()), nil)
+	}
+
+	// Changes of legacy check-up fields are applied to stored check-ups, so that they are not lost
+	if conflict := updatedRecord.mergeLegacyCheckUp(storedRecord); conflict != "" {
+		return nil, newRecordError(http.StatusUnprocessableEntity, conflict, nil)
+	}
+	if conflict := checkUpsConflict(*updatedRecord); conflict != "" {
+		return nil, newRecordError(http.StatusBadRequest, conflict, nil)
+	}
+	updatedRecord.stampCheckUps(time.Now().UTC())
+
+	// Reason validation
+	if conflict := reasonConflict(*updatedRecord); conflict != "" {
+		return nil, newRecordError(http.StatusBadRequest, conflict, nil)
+	}
+
+	// Optimistic concurrency - client can require update of specific version of the record
+	if ifMatch != "" && (storedRecord == nil || !etagMatches(ifMatch, storedRecord.ETag(), false)) {
+		return nil, newRecordError(http.StatusPreconditionFailed, "Record was modified, version does not match If-Match header", nil)
+	}
+
+	// Patch was applied to specific version of the record, which must not change in the meantime
+	if baseVersion != nil && (storedRecord == nil || storedRecord.Version != *baseVersion) {
+		return nil, newRecordError(http.StatusPreconditionFailed, "Record was modified by another request, load it and try again", nil)
+	}
+
+	// All patient's records except the updated one
+	otherRecordsFilter := db_service.And(
+		db_service.Eq("patientId", updatedRecord.PatientId),
+		db_service.Ne("id", recordId),
+		notDeleted(),
+	)
+
+	// Fetching one of patient's other records to validate conflict of full name with updated record
+	patientRecords, _, err := db.QueryDocuments(ctx, db_service.Query{
+		Filter:     otherRecordsFilter,
+		Projection: []string{"fullName"},
+		Limit:      1,
+	})
 
-	// Filter out updated record from all patient's records
-	patientRecords, recordToUpdate, recordIsLatest = filterUpdatedAndLatest(patientRecords, updatedRecord.Id)
+	if err != nil {
+		return nil, newRecordError(http.StatusBadGateway, "Failed to fetch existing records", err)
+	}
+
+	// Pending records of the patient are checked in the same way as stored ones
+	pendingRecords := []Record{}
+	for _, record := range pending {
+		if record.PatientId == updatedRecord.PatientId && record.Id != recordId {
+			pendingRecords = append(pendingRecords, record)
+		}
+	}
+	patientRecords = append(patientRecords, pendingRecords...)
+
+	// Full Name validation
+	if updatedRecord.FullName == "" { // if fullname not provided
+		if recordToUpdate != nil { // inherit it from stored record
+			updatedRecord.FullName = recordToUpdate.FullName
+		} else if len(patientRecords) != 0 { // or from other patient's records
+			updatedRecord.FullName = patientRecords[0].FullName
+		} else {
+			return nil, newRecordError(http.StatusNotFound, "Patient's PN records not found, create new record (with Full Name)", nil)
+		}
+	}
 
-	// Check if FullName matches the existing records
-	if len(patientRecords) != 0 && updatedRecord.FullName != patientRecords[0].FullName {
+	// Check if FullName matches the existing records, final records of registered patient can have name before rename
+	if patient == nil && len(patientRecords) != 0 && updatedRecord.FullName != patientRecords[0].FullName {
 		// allow fullname update if there's only one existing record and the IDs match
-		ctx.JSON(http.StatusConflict, gin.H{
-			"status":  "Conflict",
-			"message": "Cannot update Full Name for this patient's ID (conflict with existing records)",
+		return nil, newRecordError(http.StatusConflict, "Cannot update Full Name for this patient's ID (conflict with existing records)", nil)
+	}
+
+	// Updated record is latest for patient if none of other patient's records is valid longer
+	recordIsLatest := false
+	if recordToUpdate != nil {
+		_, newerRecords, err := db.QueryDocuments(ctx, db_service.Query{
+			Filter:     db_service.And(otherRecordsFilter, db_service.Gt("validUntil", recordToUpdate.ValidUntil)),
+			Projection: []string{"id"},
+			Limit:      1,
 		})
-		return
+
+		if err != nil {
+			return nil, newRecordError(http.StatusBadGateway, "Failed to fetch existing records", err)
+		}
+
+		for _, record := range pendingRecords {
+			if record.ValidUntil.After(recordToUpdate.ValidUntil) {
+				newerRecords++
+			}
+		}
+
+		recordIsLatest = newerRecords == 0
 	}
 
 	// Date validity overlap validation - if record changed patient or patient is the same and record its latest
-	if recordToUpdate == nil || (recordToUpdate != nil && recordIsLatest) {
-		for _, record := range patientRecords {
+	if recordToUpdate == nil || recordIsLatest {
+		_, overlapping, err := db.QueryDocuments(ctx, db_service.Query{
+			Filter:     db_service.And(otherRecordsFilter, db_service.Gte("validUntil", updatedRecord.ValidFrom)),
+			Projection: []string{"id"},
+			Limit:      1,
+		})
+
+		if err != nil {
+			return nil, newRecordError(http.StatusBadGateway, "Failed to fetch existing records", err)
+		}
+
+		for _, record := range pendingRecords {
 			if !updatedRecord.ValidFrom.After(record.ValidUntil) {
-				ctx.JSON(http.StatusConflict,
-					gin.H{
-						"status":  "Conflict",
-						"message": "Patient already has more up-to-date record or their validity overlap",
-					},
-				)
-				return
+				overlapping++
 			}
 		}
+
+		if overlapping != 0 {
+			return nil, newRecordError(http.StatusConflict, "Patient already has more up-to-date record or their validity overlap", nil)
+		}
 	}
 
 	// If validity dates are updated
 	validityDatesChanged := recordToUpdate != nil && (recordToUpdate.ValidFrom != updatedRecord.ValidFrom || recordToUpdate.ValidUntil != updatedRecord.ValidUntil)
 
 	if !recordIsLatest && validityDatesChanged {
-		ctx.JSON(http.StatusBadRequest,
+		return nil, newRecordError(http.StatusBadRequest, "Validity dates of not the latest PN record can not be updated", nil)
+	}
+
+	if storedRecord == nil {
+		return nil, newRecordError(http.StatusNotFound, "Record with specified ID not found", db_service.ErrNotFound)
+	}
+
+	// Version, lifecycle state and episode are managed by server
+	updatedRecord.Deleted = nil
+	updatedRecord.Version = storedRecord.Version + 1
+	updatedRecord.Status = storedRecord.LifecycleStatus()
+	updatedRecord.PredecessorId = storedRecord.PredecessorId
+	updatedRecord.EpisodeId = storedRecord.EpisodeId
+
+	return storedRecord, nil
+}
+
+// validates new record against registered patient and employer and patient's other records - stored ones
+// and pending ones which are created by the same request, fills in server managed fields, inherited full name
+// and employer name, shared by CreateRecord and ImportRecords. Caller must hold locks of the patient and employer.
+func (this *implPnRegistryRecordsAPI) prepareNewRecord(ctx *gin.Context, db db_service.DbService[Record], newRecord *Record, pending []Record) *recordError {
+	// Dates validation
+	newRecord.normalizeCheckUps()
+	if conflict := checkUpsConflict(*newRecord); conflict != "" {
+		return newRecordError(http.StatusBadRequest, conflict, nil)
+	}
+	newRecord.stampCheckUps(time.Now().UTC())
+	if newRecord.ValidFrom.After(newRecord.ValidUntil) {
+		return newRecordError(http.StatusBadRequest, "'Valid until' date can only be on or after 'Valid from' date", nil)
+	}
+
+	// Reason and diagnosis validation
+	if conflict := reasonConflict(*newRecord); conflict != "" {
+		return newRecordError(http.StatusBadRequest, conflict, nil)
+	}
+	if conflict := diagnosisReasonConflict(newRecord.Diagnosis, newRecord.Reason); conflict != "" {
+		return newRecordError(http.StatusBadRequest, conflict, nil)
+	}
+
+	if newRecord.Id == "@new" {
+		newRecord.Id = uuid.New().String()
+	}
+
+	// Version, deletion, lifecycle state and episode are managed by server, new record always starts with first version as issued
+	newRecord.Version = 1
+	newRecord.Deleted = nil
+	newRecord.Status = StatusIssued
+	newRecord.PredecessorId = ""
+	newRecord.EpisodeId = ""
+
+	// Registered patient is authoritative source of patient's full name
+	patient, err := findPatient(ctx, newRecord.PatientId)
+	if err != nil {
+		return newRecordError(http.StatusBadGateway, "Failed to fetch patient", err)
+	}
+
+	if patient != nil {
+		if newRecord.FullName == "" {
+			newRecord.FullName = patient.FullName
+		} else if newRecord.FullName != patient.FullName {
+			return newRecordError(http.StatusConflict, "Full Name does not correspond to patient's ID (conflict with registered patient)", nil)
+		}
+	}
+
+	// Referenced employer is authoritative source of employer's name
+	if newRecord.EmployerId != "" {
+		employer, err := findEmployer(ctx, newRecord.EmployerId)
+		if err != nil {
+			return newRecordError(http.StatusBadGateway, "Failed to fetch employer", err)
+		}
+
+		if employer == nil {
+			return newRecordError(http.StatusNotFound, "Employer with specified employerId not found", nil)
+		}
+
+		if newRecord.Employer == "" {
+			newRecord.Employer = employer.Name
+		} else if newRecord.Employer != employer.Name {
+			return newRecordError(http.StatusConflict, "Employer does not correspond to employer's ID (conflict with registered employer)", nil)
+		}
+	}
+
+	// Fetching one of patient's records by patient ID to validate conflict of full name with new record
+	patientRecords, _, err := db.QueryDocuments(ctx, db_service.Query{
+		Filter:     db_service.And(db_service.Eq("patientId", newRecord.PatientId), notDeleted()),
+		Projection: []string{"fullName"},
+		Limit:      1,
+	})
+
+	if err != nil {
+		return newRecordError(http.StatusBadGateway, "Failed to fetch existing records", err)
+	}
+
+	// Pending records of the patient are checked in the same way as stored ones
+	for _, record := range pending {
+		if record.PatientId == newRecord.PatientId {
+			patientRecords = append(patientRecords, record)
+		}
+	}
+
+	//Full Name validation
+	if newRecord.FullName == "" { // is fullName is not provided
+		if len(patientRecords) != 0 { // inherit fullname from existing records
+			newRecord.FullName = patientRecords[0].FullName
+		} else {
+			return newRecordError(http.StatusNotFound, "Patient's PN records not found, provide Full Name", nil)
+		}
+	}
+
+	// final records of registered patient can have name before rename
+	if patient == nil && (len(patientRecords) != 0) && newRecord.FullName != patientRecords[0].FullName {
+		return newRecordError(http.StatusConflict, "Full Name does not correspond to patient's ID (conflict with existing records)", nil)
+	}
+
+	// Date validity overlap validation - any patient's record valid on or after start of new record is conflict
+	_, overlapping, err := db.QueryDocuments(ctx, db_service.Query{
+		Filter: db_service.And(
+			db_service.Eq("patientId", newRecord.PatientId),
+			db_service.Gte("validUntil", newRecord.ValidFrom),
+			notDeleted(),
+		),
+		Projection: []string{"id"},
+		Limit:      1,
+	})
+
+	if err != nil {
+		return newRecordError(http.StatusBadGateway, "Failed to fetch existing records", err)
+	}
+
+	for _, record := range pending {
+		if record.PatientId == newRecord.PatientId && !newRecord.ValidFrom.After(record.ValidUntil) {
+			overlapping++
+		}
+	}
+
+	if overlapping != 0 {
+		return newRecordError(http.StatusConflict, "Patient already has more up-to-date record or their validity overlap", nil)
+	}
+
+	return nil
+}
+
+// checks that record with the imported ID does not exist yet and is not imported twice
+func (this *implPnRegistryRecordsAPI) checkImportedId(ctx *gin.Context, db db_service.DbService[Record], recordId string, pending []Record) *recordError {
+	if recordId == "@new" {
+		return nil
+	}
+
+	for _, record := range pending {
+		if record.Id == recordId {
+			return newRecordError(http.StatusConflict, "Record with the same ID is imported more than once", nil)
+		}
+	}
+
+	_, err := db.FindDocument(ctx, recordId)
+	switch err {
+	case nil:
+		return newRecordError(http.StatusConflict, "Record already exists", nil)
+	case db_service.ErrNotFound:
+		return nil
+	default:
+		return newRecordError(http.StatusBadGateway, "Failed to fetch existing records", err)
+	}
+}
+
+// loads the record and prepares its version marked as deleted, shared by DeleteRecord and BatchRecords.
+// Record is only marked as deleted, it is removed from database by purge job after retention period.
+func (this *implPnRegistryRecordsAPI) prepareDeletedRecord(ctx *gin.Context, db db_service.DbService[Record], recordId string, ifMatch string, reason string) (*Record, *Record, *recordError) {
+	record, err := db.FindDocument(ctx, recordId)
+	if err == nil && record.Deleted != nil {
+		err = db_service.ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, deleteWriteError(err)
+	}
+
+	// Optimistic concurrency - client can require deletion of specific version of the record
+	if ifMatch != "" && !etagMatches(ifMatch, record.ETag(), false) {
+		return nil, nil, deleteWriteError(db_service.ErrConditionFailed)
+	}
+
+	deletedRecord := *record
+	deletedRecord.Version = record.Version + 1
+	deletedRecord.Deleted = &Deletion{
+		Reason:    reason,
+		DeletedAt: time.Now().UTC(),
+	}
+	return record, &deletedRecord, nil
+}
+
+// moves the record to another lifecycle state, shared by lifecycle transition endpoints,
+// apply can make additional changes of the record and responds itself when it rejects the transition
+func (this *implPnRegistryRecordsAPI) transitionRecord(ctx *gin.Context, db db_service.DbService[Record], recordId string, status string, apply func(record *Record) bool) {
+	record, err := db.FindDocument(ctx, recordId)
+	if err == nil && record.Deleted != nil {
+		err = db_service.ErrNotFound
+	}
+
+	switch err {
+	case nil:
+	case db_service.ErrNotFound:
+		ctx.JSON(http.StatusNotFound,
 			gin.H{
-				"status":  "Bad Request",
-				"message": "Validity dates of not the latest PN record can not be updated",
+				"status":  "Not Found",
+				"message": "Record with specified ID not found",
+				"error":   err.Error(),
+			},
+		)
+		return
+	default:
+		ctx.JSON(http.StatusBadGateway,
+			gin.H{
+				"status":  "Bad Gateway",
+				"message": "Failed to load record from database",
+				"error":   err.Error(),
+			})
+		return
+	}
+
+	// Optimistic concurrency - client can require transition of specific version of the record
+	if ifMatch := ctx.GetHeader("If-Match"); ifMatch != "" && !etagMatches(ifMatch, record.ETag(), false) {
+		ctx.JSON(http.StatusPreconditionFailed,
+			gin.H{
+				"status":  "Precondition Failed",
+				"message": "Record was modified, version does not match If-Match header",
+			},
+		)
+		return
+	}
+
+	if !record.CanTransitionTo(status) {
+		ctx.JSON(http.StatusConflict,
+			gin.H{
+				"status":  "Conflict",
+				"message": fmt.Sprintf("Record in state '%s' can not be moved to state '%s'", record.LifecycleStatus(), status),
 			},
 		)
 		return
 	}
 
-	err = db.UpdateDocument(ctx, recordId, &updatedRecord)
+	// Serialize requests for the same patient, so that conflict checks and write of the record are atomic
+	unlock, err := patientLocks.Lock(ctx, record.PatientId)
+	if err != nil {
+		lockError(err).respond(ctx)
+		return
+	}
+	defer unlock()
+
+	transitionedRecord := *record
+	if apply != nil && !apply(&transitionedRecord) {
+		return
+	}
+
+	transitionedRecord.Status = status
+	transitionedRecord.Version = record.Version + 1
+	err = db.UpdateDocumentIf(ctx, recordId, versionCondition(record.Version), &transitionedRecord)
 
 	switch err {
 	case nil:
-		ctx.JSON(http.StatusOK, updatedRecord)
+		recordAudit(ctx, AuditActionUpdate, recordId, record, &transitionedRecord)
+		ctx.Header("ETag", transitionedRecord.ETag())
+		ctx.JSON(http.StatusOK, transitionedRecord)
+	case db_service.ErrConditionFailed:
+		ctx.JSON(http.StatusPreconditionFailed,
+			gin.H{
+				"status":  "Precondition Failed",
+				"message": "Record was modified by another request, load it and try again",
+				"error":   err.Error(),
+			},
+		)
 	case db_service.ErrNotFound:
 		ctx.JSON(http.StatusNotFound,
 			gin.H{
@@ -482,7 +2579,5 @@ func (this *implPnRegistryRecordsAPI) UpdateRecord(ctx *gin.Context) {
 				"message": "Failed to update record in database",
 				"error":   err.Error(),
 			})
-
 	}
-
 }
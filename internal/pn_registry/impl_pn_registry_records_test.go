@@ -0,0 +1,570 @@
+package pn_registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bmathus/pnregistry-webapi/internal/db_service"
+	"github.com/gin-gonic/gin"
+)
+
+func TestCreateRecord(t *testing.T) {
+	engine := newTestEngine(newTestServices())
+
+	record := createTestRecord(t, engine, newTestRecord("@new", "123", "2024-01-01", "2024-01-10"))
+	if record.Id == "@new" || record.Id == "" {
+		t.Errorf("Expected generated ID, got %q", record.Id)
+	}
+	if record.Version != 1 || record.Status != StatusIssued {
+		t.Errorf("Expected first version of issued record, got version %v in state %q", record.Version, record.Status)
+	}
+}
+
+func TestCreateRecordValidation(t *testing.T) {
+	engine := newTestEngine(newTestServices())
+
+	invalidPatientId := newTestRecord("r1", "12a", "2024-01-01", "2024-01-10")
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/records/", invalidPatientId, nil), http.StatusBadRequest)
+
+	invalidDates := newTestRecord("r1", "123", "2024-01-10", "2024-01-01")
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/records/", invalidDates, nil), http.StatusBadRequest)
+
+	createTestRecord(t, engine, newTestRecord("r1", "123", "2024-01-01", "2024-01-10"))
+	duplicate := newTestRecord("r1", "456", "2024-01-01", "2024-01-10")
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/records/", duplicate, nil), http.StatusConflict)
+
+	overlapping := newTestRecord("r2", "123", "2024-01-05", "2024-01-20")
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/records/", overlapping, nil), http.StatusConflict)
+
+	otherName := newTestRecord("r3", "123", "2024-02-01", "2024-02-10")
+	otherName["fullName"] = "Jana Mrkvickova"
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/records/", otherName, nil), http.StatusConflict)
+}
+
+func TestGetRecord(t *testing.T) {
+	engine := newTestEngine(newTestServices())
+	createTestRecord(t, engine, newTestRecord("r1", "123", "2024-01-01", "2024-01-10"))
+
+	recorder := doRequest(engine, http.MethodGet, "/api/records/r1/", nil, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	if record := decodeResponse[Record](t, recorder); record.Id != "r1" || record.PatientId != "123" {
+		t.Errorf("Unexpected record %+v", record)
+	}
+	if etag := recorder.Header().Get("ETag"); etag != `"1"` {
+		t.Errorf("Expected ETag of first version, got %q", etag)
+	}
+
+	expectStatus(t, doRequest(engine, http.MethodGet, "/api/records/r1/", nil, map[string]string{"If-None-Match": `"1"`}), http.StatusNotModified)
+	expectStatus(t, doRequest(engine, http.MethodGet, "/api/records/missing/", nil, nil), http.StatusNotFound)
+}
+
+func TestGetRecordAll(t *testing.T) {
+	engine := newTestEngine(newTestServices())
+	createTestRecord(t, engine, newTestRecord("r1", "123", "2024-01-01", "2024-01-10"))
+	createTestRecord(t, engine, newTestRecord("r2", "123", "2024-02-01", "2024-02-10"))
+	createTestRecord(t, engine, newTestRecord("r3", "456", "2024-01-05", "2024-01-06"))
+
+	recorder := doRequest(engine, http.MethodGet, "/api/records/?patientId=123&sort=-validFrom", nil, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	records := decodeResponse[[]Record](t, recorder)
+	if len(records) != 2 || records[0].Id != "r2" || records[1].Id != "r1" {
+		t.Errorf("Expected records r2 and r1 of the patient, got %+v", records)
+	}
+	if total := recorder.Header().Get("X-Total-Count"); total != "2" {
+		t.Errorf("Expected total count 2, got %q", total)
+	}
+
+	expectStatus(t, doRequest(engine, http.MethodGet, "/api/records/?sort=reason", nil, nil), http.StatusBadRequest)
+}
+
+func TestUpdateRecord(t *testing.T) {
+	engine := newTestEngine(newTestServices())
+	createTestRecord(t, engine, newTestRecord("r1", "123", "2024-01-01", "2024-01-10"))
+
+	updated := newTestRecord("r1", "123", "2024-01-01", "2024-01-15")
+	recorder := doRequest(engine, http.MethodPut, "/api/records/r1/", updated, map[string]string{"If-Match": `"1"`})
+	expectStatus(t, recorder, http.StatusOK)
+	if record := decodeResponse[Record](t, recorder); record.Version != 2 || record.ValidUntil.String() != "2024-01-15" {
+		t.Errorf("Expected second version valid until 2024-01-15, got %+v", record)
+	}
+
+	// stale version is rejected
+	expectStatus(t, doRequest(engine, http.MethodPut, "/api/records/r1/", updated, map[string]string{"If-Match": `"1"`}), http.StatusPreconditionFailed)
+
+	otherId := newTestRecord("r2", "123", "2024-01-01", "2024-01-15")
+	expectStatus(t, doRequest(engine, http.MethodPut, "/api/records/r1/", otherId, nil), http.StatusBadRequest)
+
+	missing := newTestRecord("missing", "789", "2024-01-01", "2024-01-15")
+	expectStatus(t, doRequest(engine, http.MethodPut, "/api/records/missing/", missing, nil), http.StatusNotFound)
+}
+
+func TestDeleteAndRestoreRecord(t *testing.T) {
+	engine := newTestEngine(newTestServices())
+	createTestRecord(t, engine, newTestRecord("r1", "123", "2024-01-01", "2024-01-10"))
+
+	expectStatus(t, doRequest(engine, http.MethodDelete, "/api/records/r1/?reason=duplicate", nil, nil), http.StatusNoContent)
+	expectStatus(t, doRequest(engine, http.MethodGet, "/api/records/r1/", nil, nil), http.StatusNotFound)
+	expectStatus(t, doRequest(engine, http.MethodDelete, "/api/records/r1/", nil, nil), http.StatusNotFound)
+
+	recorder := doRequest(engine, http.MethodGet, "/api/records/", nil, nil)
+	if records := decodeResponse[[]Record](t, recorder); len(records) != 0 {
+		t.Errorf("Expected deleted record to be hidden from list, got %+v", records)
+	}
+
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/records/r1/restore", nil, nil), http.StatusOK)
+	expectStatus(t, doRequest(engine, http.MethodGet, "/api/records/r1/", nil, nil), http.StatusOK)
+}
+
+// service of records with slow queries, which widens the window between conflict checks and write of the record
+type slowQueryService struct {
+	db_service.DbService[Record]
+}
+
+func (this slowQueryService) QueryDocuments(ctx context.Context, query db_service.Query) ([]Record, int64, error) {
+	documents, total, err := this.DbService.QueryDocuments(ctx, query)
+	time.Sleep(5 * time.Millisecond)
+	return documents, total, err
+}
+
+func TestCreateRecordConcurrentOverlap(t *testing.T) {
+	services := newTestServices()
+	services.records = slowQueryService{services.records}
+	engine := newTestEngine(services)
+
+	// all records of the patient overlap, so only one of them can be created
+	const requests = 20
+	statuses := make(chan int, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			record := newTestRecord(fmt.Sprintf("r%d", i), "123", "2024-01-01", fmt.Sprintf("2024-01-%02d", 10+i))
+			statuses <- doRequest(engine, http.MethodPost, "/api/records/", record, nil).Code
+		}(i)
+	}
+	wg.Wait()
+	close(statuses)
+
+	created := 0
+	for status := range statuses {
+		switch status {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Errorf("Unexpected status %v", status)
+		}
+	}
+	if created != 1 {
+		t.Errorf("Expected exactly one created record, got %v", created)
+	}
+
+	records, err := services.records.FindDocuments(context.Background(), "patientId", "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Errorf("Expected exactly one stored record, got %v", len(records))
+	}
+}
+
+// service of records which changes stored record right before bulk write, as if other instance modified it meanwhile
+type concurrentChangeService struct {
+	db_service.DbService[Record]
+	recordId string
+}
+
+func (this concurrentChangeService) BulkWrite(ctx context.Context, operations []db_service.WriteOperation[Record], atomic bool) ([]error, error) {
+	record, err := this.DbService.FindDocument(ctx, this.recordId)
+	if err != nil {
+		return nil, err
+	}
+	record.Version++
+	if err := this.DbService.UpdateDocument(ctx, this.recordId, record); err != nil {
+		return nil, err
+	}
+	return this.DbService.BulkWrite(ctx, operations, atomic)
+}
+
+// creates active record which can be continued
+func createActiveTestRecord(t *testing.T, engine *gin.Engine, id string) {
+	t.Helper()
+	createTestRecord(t, engine, newTestRecord(id, "123", "2024-01-01", "2024-01-10"))
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/records/"+id+"/activate", nil, nil), http.StatusOK)
+}
+
+func TestContinueRecord(t *testing.T) {
+	services := newTestServices()
+	engine := newTestEngine(services)
+	createActiveTestRecord(t, engine, "r1")
+
+	continuation := map[string]interface{}{"id": "r2", "issued": "2024-01-08", "validFrom": "2024-01-08", "validUntil": "2024-01-20"}
+	recorder := doRequest(engine, http.MethodPost, "/api/records/r1/continuation", continuation, nil)
+	expectStatus(t, recorder, http.StatusCreated)
+	if record := decodeResponse[Record](t, recorder); record.PredecessorId != "r1" || record.Status != StatusActive {
+		t.Errorf("Expected active follow-up of r1, got %+v", record)
+	}
+
+	predecessor, err := services.records.FindDocument(context.Background(), "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if predecessor.Status != StatusExtended || predecessor.ValidUntil.String() != "2024-01-07" {
+		t.Errorf("Expected extended record ending before follow-up, got %+v", predecessor)
+	}
+}
+
+func TestContinueRecordConcurrentChange(t *testing.T) {
+	services := newTestServices()
+	engine := newTestEngine(services)
+	createActiveTestRecord(t, engine, "r1")
+
+	services.records = concurrentChangeService{services.records, "r1"}
+	continuation := map[string]interface{}{"id": "r2", "issued": "2024-01-08", "validFrom": "2024-01-08", "validUntil": "2024-01-20"}
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/records/r1/continuation", continuation, nil), http.StatusPreconditionFailed)
+
+	// follow-up record is not created without extension of its predecessor
+	if _, err := services.records.FindDocument(context.Background(), "r2"); err != db_service.ErrNotFound {
+		t.Errorf("Expected follow-up record not to be created, got %v", err)
+	}
+}
+
+func TestContinueRecordConcurrentRequests(t *testing.T) {
+	services := newTestServices()
+	engine := newTestEngine(services)
+	createActiveTestRecord(t, engine, "r1")
+
+	const requests = 10
+	statuses := make(chan int, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			continuation := map[string]interface{}{"id": fmt.Sprintf("f%d", i), "issued": "2024-01-08", "validFrom": "2024-01-08", "validUntil": "2024-01-20"}
+			statuses <- doRequest(engine, http.MethodPost, "/api/records/r1/continuation", continuation, nil).Code
+		}(i)
+	}
+	wg.Wait()
+	close(statuses)
+
+	created := 0
+	for status := range statuses {
+		if status == http.StatusCreated {
+			created++
+		} else if status != http.StatusConflict {
+			t.Errorf("Unexpected status %v", status)
+		}
+	}
+	if created != 1 {
+		t.Errorf("Expected exactly one follow-up record, got %v", created)
+	}
+}
+
+func TestGetRecordCalendar(t *testing.T) {
+	engine := newTestEngine(newTestServices())
+	createTestRecord(t, engine, newTestRecord("r1", "123", "2024-01-01", "2024-01-10"))
+
+	recorder := doRequest(engine, http.MethodGet, "/api/records/r1.ics", nil, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/calendar") {
+		t.Errorf("Expected iCalendar content type, got %q", contentType)
+	}
+	if body := recorder.Body.String(); !strings.Contains(body, "BEGIN:VCALENDAR") || !strings.Contains(body, "DTSTART;VALUE=DATE:20240101") {
+		t.Errorf("Unexpected calendar %q", body)
+	}
+
+	expectStatus(t, doRequest(engine, http.MethodGet, "/api/records/missing.ics", nil, nil), http.StatusNotFound)
+	// record path without trailing slash is still redirected to the record
+	recorder = doRequest(engine, http.MethodGet, "/api/records/r1?fields=id", nil, nil)
+	expectStatus(t, recorder, http.StatusMovedPermanently)
+	if location := recorder.Header().Get("Location"); location != "/api/records/r1/?fields=id" {
+		t.Errorf("Expected redirect to the record, got %q", location)
+	}
+}
+
+// joins records into NDJSON body of import
+func importBody(records ...map[string]interface{}) string {
+	lines := []string{}
+	for _, record := range records {
+		data, _ := json.Marshal(record)
+		lines = append(lines, string(data))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestImportRecordsDryRun(t *testing.T) {
+	services := newTestServices()
+	engine := newTestEngine(services)
+
+	invalid := newTestRecord("r2", "456", "2024-01-10", "2024-01-01")
+	body := importBody(newTestRecord("r1", "123", "2024-01-01", "2024-01-10"), invalid)
+	recorder := doRequest(engine, http.MethodPost, "/api/records/import?format=ndjson&dryRun=true", body, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	report := decodeResponse[ImportReport](t, recorder)
+	if report.Committed || report.Accepted != 1 || report.Rejected != 1 {
+		t.Errorf("Expected report of one accepted and one rejected row, got %+v", report)
+	}
+
+	if records := decodeResponse[[]Record](t, doRequest(engine, http.MethodGet, "/api/records/", nil, nil)); len(records) != 0 {
+		t.Errorf("Expected no record to be created by dry run, got %+v", records)
+	}
+
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/records/import?format=ndjson", body, nil), http.StatusUnprocessableEntity)
+}
+
+// service of records which creates record right before bulk write, as if other request created it meanwhile
+type concurrentCreateService struct {
+	db_service.DbService[Record]
+	record Record
+}
+
+func (this concurrentCreateService) BulkWrite(ctx context.Context, operations []db_service.WriteOperation[Record], atomic bool) ([]error, error) {
+	if err := this.DbService.CreateDocument(ctx, this.record.Id, &this.record); err != nil {
+		return nil, err
+	}
+	return this.DbService.BulkWrite(ctx, operations, atomic)
+}
+
+func TestImportRecordsAllOrNothingRollback(t *testing.T) {
+	services := newTestServices()
+	memory := services.records
+	services.records = concurrentCreateService{memory, Record{Id: "r2", PatientId: "789", FullName: "Jana Mrkvickova", Version: 1}}
+	engine := newTestEngine(services)
+
+	body := importBody(newTestRecord("r1", "123", "2024-01-01", "2024-01-10"), newTestRecord("r2", "456", "2024-01-01", "2024-01-10"))
+	recorder := doRequest(engine, http.MethodPost, "/api/records/import?format=ndjson", body, nil)
+	expectStatus(t, recorder, http.StatusUnprocessableEntity)
+	if report := decodeResponse[ImportReport](t, recorder); report.Committed || report.Conflicting != 1 {
+		t.Errorf("Expected uncommitted import with one conflicting row, got %+v", report)
+	}
+
+	// record written before the conflict was rolled back
+	if _, err := memory.FindDocument(context.Background(), "r1"); err != db_service.ErrNotFound {
+		t.Errorf("Expected record r1 not to be created, got %v", err)
+	}
+}
+
+func TestImportRecordsBestEffort(t *testing.T) {
+	services := newTestServices()
+	engine := newTestEngine(services)
+	createTestRecord(t, engine, newTestRecord("r1", "123", "2024-01-01", "2024-01-10"))
+
+	body := importBody(newTestRecord("r1", "456", "2024-02-01", "2024-02-10"), newTestRecord("r2", "789", "2024-01-01", "2024-01-10"))
+	recorder := doRequest(engine, http.MethodPost, "/api/records/import?format=ndjson&mode=best-effort", body, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	if report := decodeResponse[ImportReport](t, recorder); !report.Committed || report.Accepted != 1 || report.Conflicting != 1 {
+		t.Errorf("Expected committed import with one accepted and one conflicting row, got %+v", report)
+	}
+	expectStatus(t, doRequest(engine, http.MethodGet, "/api/records/r2/", nil, nil), http.StatusOK)
+}
+
+// rows of distinct patients, so that all of them are accepted
+func importTestRows(count int) string {
+	records := []map[string]interface{}{}
+	for i := 0; i < count; i++ {
+		records = append(records, newTestRecord(fmt.Sprintf("i%v", i), strconv.Itoa(1000000+i), "2024-01-01", "2024-01-10"))
+	}
+	return importBody(records...)
+}
+
+func TestImportRecordsAtomicLimit(t *testing.T) {
+	engine := newTestEngine(newTestServices())
+	body := importTestRows(importMaxAtomicRows + 1)
+
+	// all-or-nothing import is written in single transaction, so its size is limited
+	recorder := doRequest(engine, http.MethodPost, "/api/records/import?format=ndjson", body, nil)
+	expectStatus(t, recorder, http.StatusRequestEntityTooLarge)
+	if records := decodeResponse[[]Record](t, doRequest(engine, http.MethodGet, "/api/records/", nil, nil)); len(records) != 0 {
+		t.Errorf("Expected no record to be created by too large import, got %v records", len(records))
+	}
+
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/records/import?format=ndjson&dryRun=true", body, nil), http.StatusOK)
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/records/import?format=ndjson&mode=best-effort", body, nil), http.StatusOK)
+}
+
+func TestMongoImportRecordsAllOrNothing(t *testing.T) {
+	services := newMongoTestServices(t)
+	mongoRecords := services.records
+	services.records = concurrentCreateService{mongoRecords, Record{Id: "r2", PatientId: "789", FullName: "Jana Mrkvickova", Version: 1}}
+	engine := newTestEngine(services)
+
+	body := importBody(newTestRecord("r1", "123", "2024-01-01", "2024-01-10"), newTestRecord("r2", "456", "2024-01-01", "2024-01-10"))
+	recorder := doRequest(engine, http.MethodPost, "/api/records/import?format=ndjson", body, nil)
+	if recorder.Code == http.StatusServiceUnavailable {
+		t.Skip("PN_REGISTRY_API_MONGODB_REPLICA_SET is not set to replica set of the test server")
+	}
+	expectStatus(t, recorder, http.StatusUnprocessableEntity)
+	if report := decodeResponse[ImportReport](t, recorder); report.Committed || report.Conflicting != 1 {
+		t.Errorf("Expected uncommitted import with one conflicting row, got %+v", report)
+	}
+	// record written in the transaction before the conflict was rolled back
+	if _, err := mongoRecords.FindDocument(context.Background(), "r1"); err != db_service.ErrNotFound {
+		t.Errorf("Expected record r1 not to be created, got %v", err)
+	}
+
+	// import of maximal size fits into limits of the transaction
+	services.records = mongoRecords
+	recorder = doRequest(engine, http.MethodPost, "/api/records/import?format=ndjson", importTestRows(importMaxAtomicRows), nil)
+	expectStatus(t, recorder, http.StatusOK)
+	if report := decodeResponse[ImportReport](t, recorder); !report.Committed || report.Accepted != importMaxAtomicRows {
+		t.Errorf("Expected committed import of all rows, got %+v", report)
+	}
+	if _, total, err := mongoRecords.QueryDocuments(context.Background(), db_service.Query{Limit: 1}); err != nil || total != importMaxAtomicRows+1 {
+		t.Errorf("Expected %v records, got %v: %v", importMaxAtomicRows+1, total, err)
+	}
+}
+
+// service of records which moves the record to other patient right after first query, as if other request
+// moved it after the batch loaded it and before the batch locked its patient
+type concurrentMoveService struct {
+	db_service.DbService[Record]
+	recordId  string
+	patientId string
+	moved     *bool
+}
+
+func (this concurrentMoveService) QueryDocuments(ctx context.Context, query db_service.Query) ([]Record, int64, error) {
+	documents, total, err := this.DbService.QueryDocuments(ctx, query)
+	if !*this.moved {
+		*this.moved = true
+		record, findErr := this.DbService.FindDocument(ctx, this.recordId)
+		if findErr != nil {
+			return nil, 0, findErr
+		}
+		record.PatientId = this.patientId
+		record.Version++
+		if updateErr := this.DbService.UpdateDocument(ctx, this.recordId, record); updateErr != nil {
+			return nil, 0, updateErr
+		}
+	}
+	return documents, total, err
+}
+
+func TestBatchRecords(t *testing.T) {
+	engine := newTestEngine(newTestServices())
+	createTestRecord(t, engine, newTestRecord("r1", "123", "2024-01-01", "2024-01-10"))
+
+	updated := newTestRecord("r1", "123", "2024-01-01", "2024-01-15")
+	batch := map[string]interface{}{
+		"operations": []map[string]interface{}{
+			{"op": "create", "record": newTestRecord("r2", "456", "2024-01-01", "2024-01-10")},
+			{"op": "update", "recordId": "r1", "record": updated},
+			{"op": "delete", "recordId": "missing"},
+		},
+	}
+	recorder := doRequest(engine, http.MethodPost, "/api/records/batch", batch, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	response := decodeResponse[BatchResponse](t, recorder)
+	statuses := []int{http.StatusCreated, http.StatusOK, http.StatusNotFound}
+	for i, status := range statuses {
+		if response.Results[i].Status != status {
+			t.Errorf("Expected status %v of operation %v, got %+v", status, i, response.Results[i])
+		}
+	}
+}
+
+func TestBatchRecordsMovedRecord(t *testing.T) {
+	services := newTestServices()
+	engine := newTestEngine(services)
+	createTestRecord(t, engine, newTestRecord("r1", "123", "2024-01-01", "2024-01-10"))
+
+	moved := false
+	services.records = concurrentMoveService{services.records, "r1", "999", &moved}
+	updated := newTestRecord("r1", "789", "2024-01-01", "2024-01-15")
+	batch := map[string]interface{}{
+		"operations": []map[string]interface{}{{"op": "update", "recordId": "r1", "record": updated}},
+	}
+	recorder := doRequest(engine, http.MethodPost, "/api/records/batch", batch, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	if response := decodeResponse[BatchResponse](t, recorder); response.Results[0].Status != http.StatusConflict {
+		t.Errorf("Expected conflict of record moved to other patient, got %+v", response.Results[0])
+	}
+}
+
+// database without transactions, e.g. standalone mongo server
+type noTransactionsService struct {
+	db_service.DbService[Record]
+}
+
+func (this noTransactionsService) BulkWrite(ctx context.Context, operations []db_service.WriteOperation[Record], atomic bool) ([]error, error) {
+	if atomic {
+		return nil, db_service.ErrTransactionsUnsupported
+	}
+	return this.DbService.BulkWrite(ctx, operations, atomic)
+}
+
+func TestBatchRecordsWithoutTransactions(t *testing.T) {
+	services := newTestServices()
+	services.records = noTransactionsService{services.records}
+	engine := newTestEngine(services)
+
+	batch := map[string]interface{}{
+		"operations": []map[string]interface{}{{"op": "create", "record": newTestRecord("r1", "123", "2024-01-01", "2024-01-10")}},
+	}
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/records/batch", batch, nil), http.StatusOK)
+
+	batch["operations"] = []map[string]interface{}{{"op": "create", "record": newTestRecord("r2", "456", "2024-01-01", "2024-01-10")}}
+	batch["atomic"] = true
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/records/batch", batch, nil), http.StatusServiceUnavailable)
+	if _, err := services.records.FindDocument(context.Background(), "r2"); err != db_service.ErrNotFound {
+		t.Errorf("Expected no record written without transactions, got %v", err)
+	}
+}
+
+func TestRecordsTenantIsolation(t *testing.T) {
+	tenants := map[string]*testServices{"a": newTestServices(), "b": newTestServices()}
+	engine := newTenantTestEngine(tenants)
+	tenantA := map[string]string{"X-Tenant-ID": "a"}
+	tenantB := map[string]string{"X-Tenant-ID": "b"}
+
+	recorder := doRequest(engine, http.MethodPost, "/api/records/", newTestRecord("r1", "123", "2024-01-01", "2024-01-10"), tenantA)
+	expectStatus(t, recorder, http.StatusCreated)
+	record := decodeResponse[Record](t, recorder)
+	patient := map[string]interface{}{"id": "8001011234", "fullName": "Jozef Mrkvicka"}
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/patients/", patient, tenantA), http.StatusCreated)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+	}{
+		{"read record", http.MethodGet, "/api/records/r1/", nil},
+		{"update record", http.MethodPut, "/api/records/r1/", record},
+		{"cancel record", http.MethodPost, "/api/records/r1/cancel", nil},
+		{"delete record", http.MethodDelete, "/api/records/r1/", nil},
+		{"read patient", http.MethodGet, "/api/patients/8001011234/", nil},
+		{"update patient", http.MethodPut, "/api/patients/8001011234/", patient},
+		{"delete patient", http.MethodDelete, "/api/patients/8001011234/", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expectStatus(t, doRequest(engine, test.method, test.path, test.body, tenantB), http.StatusNotFound)
+		})
+	}
+
+	recorder = doRequest(engine, http.MethodGet, "/api/records/", nil, tenantB)
+	expectStatus(t, recorder, http.StatusOK)
+	if records := decodeResponse[[]Record](t, recorder); len(records) != 0 {
+		t.Errorf("Expected no records of tenant b, got %+v", records)
+	}
+
+	// the same IDs are independent in every tenant
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/records/", newTestRecord("r1", "123", "2024-01-05", "2024-01-20"), tenantB), http.StatusCreated)
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/patients/", patient, tenantB), http.StatusCreated)
+
+	recorder = doRequest(engine, http.MethodGet, "/api/records/r1/", nil, tenantA)
+	expectStatus(t, recorder, http.StatusOK)
+	if stored := decodeResponse[Record](t, recorder); stored.ValidFrom != record.ValidFrom || stored.Version != record.Version {
+		t.Errorf("Expected record of tenant a unchanged, got %+v", stored)
+	}
+}
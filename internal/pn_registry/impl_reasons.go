@@ -0,0 +1,12 @@
+package pn_registry
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetReasons - Provides catalog of reasons of PN records
+func (this *implReasonsAPI) GetReasons(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, reasonCatalog.All())
+}
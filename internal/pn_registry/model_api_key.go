@@ -0,0 +1,24 @@
+package pn_registry
+
+import (
+	"time"
+)
+
+type ApiKey struct {
+	Id                 string            `json:"id" bson:"id"`
+	Name               string            `json:"name" bson:"name"`
+	Prefix             string            `json:"prefix" bson:"prefix"`
+	Scopes             []string          `json:"scopes" bson:"scopes"`
+	Claims             map[string]string `json:"claims,omitempty" bson:"claims,omitempty"`
+	Tenant             string            `json:"-" bson:"tenant"`
+	ExpiresAt          *time.Time        `json:"expiresAt,omitempty" bson:"expiresAt,omitempty"`
+	Created            time.Time         `json:"created" bson:"created"`
+	CreatedBy          string            `json:"createdBy" bson:"createdBy"`
+	Rotated            *time.Time        `json:"rotated,omitempty" bson:"rotated,omitempty"`
+	Revoked            *time.Time        `json:"revoked,omitempty" bson:"revoked,omitempty"`
+	RevokedBy          string            `json:"revokedBy,omitempty" bson:"revokedBy,omitempty"`
+	Hash               string            `json:"-" bson:"hash"`
+	PreviousHash       string            `json:"-" bson:"previousHash,omitempty"`
+	PreviousValidUntil *time.Time        `json:"previousValidUntil,omitempty" bson:"previousValidUntil,omitempty"`
+	Usage              *ApiKeyUsage      `json:"usage,omitempty" bson:"-"`
+}
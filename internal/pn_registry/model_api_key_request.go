@@ -0,0 +1,12 @@
+package pn_registry
+
+import (
+	"time"
+)
+
+type ApiKeyRequest struct {
+	Name      string            `json:"name" binding:"required,max-length-50"`
+	Scopes    []string          `json:"scopes" binding:"required,min=1,dive,required"`
+	Claims    map[string]string `json:"claims,omitempty"`
+	ExpiresAt *time.Time        `json:"expiresAt,omitempty"`
+}
@@ -0,0 +1,6 @@
+package pn_registry
+
+type ApiKeySecret struct {
+	Key    string `json:"key"`
+	ApiKey ApiKey `json:"apiKey"`
+}
@@ -0,0 +1,13 @@
+package pn_registry
+
+import (
+	"time"
+)
+
+type ApiKeyUsage struct {
+	Id       string     `json:"-" bson:"id"`
+	Requests int64      `json:"requests" bson:"requests"`
+	Failed   int64      `json:"failed" bson:"failed"`
+	LastUsed *time.Time `json:"lastUsed,omitempty" bson:"lastUsed,omitempty"`
+	LastIp   string     `json:"lastIp,omitempty" bson:"lastIp,omitempty"`
+}
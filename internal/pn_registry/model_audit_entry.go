@@ -0,0 +1,34 @@
+package pn_registry
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Enum constant of possible values of field "action" of audit entry
+const (
+	AuditActionCreate  = "create"
+	AuditActionUpdate  = "update"
+	AuditActionDelete  = "delete"
+	AuditActionRestore = "restore"
+	AuditActionPurge   = "purge"
+)
+
+type AuditEntry struct {
+	Id        string        `json:"id" bson:"id"`
+	RecordId  string        `json:"recordId" bson:"recordId"`
+	Action    string        `json:"action" bson:"action"`
+	Actor     string        `json:"actor" bson:"actor"`
+	RequestId string        `json:"requestId" bson:"requestId"`
+	Timestamp time.Time     `json:"timestamp" bson:"timestamp"`
+	Version   int64         `json:"version" bson:"version"`
+	Before    *Record       `json:"before,omitempty" bson:"before,omitempty"`
+	After     *Record       `json:"after,omitempty" bson:"after,omitempty"`
+	Changes   []FieldChange `json:"changes,omitempty" bson:"changes,omitempty"`
+}
+
+type FieldChange struct {
+	Field  string          `json:"field" bson:"field"`
+	Before json.RawMessage `json:"before" bson:"before"`
+	After  json.RawMessage `json:"after" bson:"after"`
+}
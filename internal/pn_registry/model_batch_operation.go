@@ -0,0 +1,13 @@
+package pn_registry
+
+import (
+	"encoding/json"
+)
+
+type BatchOperation struct {
+	Op       string          `json:"op" binding:"required,oneof=create update delete"`
+	RecordId string          `json:"recordId,omitempty" binding:"required_unless=Op create"`
+	IfMatch  string          `json:"ifMatch,omitempty"`
+	Reason   string          `json:"reason,omitempty"`
+	Record   json.RawMessage `json:"record,omitempty"`
+}
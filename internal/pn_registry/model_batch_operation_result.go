@@ -0,0 +1,8 @@
+package pn_registry
+
+type BatchOperationResult struct {
+	Index  int         `json:"index"`
+	Status int         `json:"status"`
+	ETag   string      `json:"etag,omitempty"`
+	Body   interface{} `json:"body,omitempty"`
+}
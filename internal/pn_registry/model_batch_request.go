@@ -0,0 +1,6 @@
+package pn_registry
+
+type BatchRequest struct {
+	Atomic     bool             `json:"atomic"`
+	Operations []BatchOperation `json:"operations" binding:"required,min=1,max=100,dive"`
+}
@@ -0,0 +1,8 @@
+package pn_registry
+
+type BatchResponse struct {
+	Atomic    bool                   `json:"atomic"`
+	Succeeded int                    `json:"succeeded"`
+	Failed    int                    `json:"failed"`
+	Results   []BatchOperationResult `json:"results"`
+}
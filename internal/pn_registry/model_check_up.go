@@ -0,0 +1,12 @@
+package pn_registry
+
+import (
+	"time"
+)
+
+type CheckUp struct {
+	Date   DateType   `json:"date" bson:"date" binding:"required"`
+	Done   bool       `json:"done" bson:"done"`
+	DoneAt *time.Time `json:"doneAt,omitempty" bson:"doneAt,omitempty"`
+	Notes  string     `json:"notes,omitempty" bson:"notes,omitempty"`
+}
@@ -0,0 +1,5 @@
+package pn_registry
+
+type CheckUpCompletion struct {
+	Notes string `json:"notes,omitempty"`
+}
@@ -0,0 +1,7 @@
+package pn_registry
+
+type Contact struct {
+	Email   string `json:"email,omitempty" bson:"email,omitempty" binding:"omitempty,email"`
+	Phone   string `json:"phone,omitempty" bson:"phone,omitempty" binding:"max-length-50"`
+	Address string `json:"address,omitempty" bson:"address,omitempty"`
+}
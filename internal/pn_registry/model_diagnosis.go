@@ -0,0 +1,6 @@
+package pn_registry
+
+type Diagnosis struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
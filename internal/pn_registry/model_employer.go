@@ -0,0 +1,9 @@
+package pn_registry
+
+type Employer struct {
+	Id           string `json:"id" bson:"id" binding:"required"`
+	Name         string `json:"name" bson:"name" binding:"required,max-length-50"`
+	Ico          string `json:"ico" bson:"ico" binding:"required,company-id"`
+	Address      string `json:"address,omitempty" bson:"address,omitempty"`
+	ContactEmail string `json:"contactEmail,omitempty" bson:"contactEmail,omitempty" binding:"omitempty,email"`
+}
@@ -0,0 +1,10 @@
+package pn_registry
+
+type Episode struct {
+	Id           string   `json:"id"`
+	PatientId    string   `json:"patientId"`
+	ValidFrom    DateType `json:"validFrom"`
+	ValidUntil   DateType `json:"validUntil"`
+	DurationDays int      `json:"durationDays"`
+	Records      []Record `json:"records"`
+}
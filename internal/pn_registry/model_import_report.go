@@ -0,0 +1,12 @@
+package pn_registry
+
+type ImportReport struct {
+	DryRun      bool              `json:"dryRun"`
+	Mode        string            `json:"mode"`
+	Committed   bool              `json:"committed"`
+	Total       int               `json:"total"`
+	Accepted    int               `json:"accepted"`
+	Rejected    int               `json:"rejected"`
+	Conflicting int               `json:"conflicting"`
+	Rows        []ImportRowResult `json:"rows"`
+}
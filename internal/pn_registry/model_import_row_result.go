@@ -0,0 +1,9 @@
+package pn_registry
+
+type ImportRowResult struct {
+	Row     int    `json:"row"`
+	Id      string `json:"id,omitempty"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
@@ -0,0 +1,10 @@
+package pn_registry
+
+type Patient struct {
+	Id        string    `json:"id" bson:"id" binding:"required,only-digits-max-length-10"`
+	FullName  string    `json:"fullName" bson:"fullName" binding:"required,max-length-50"`
+	BirthDate *DateType `json:"birthDate,omitempty" bson:"birthDate,omitempty"`
+	Sex       string    `json:"sex,omitempty" bson:"-"`
+	Contact   *Contact  `json:"contact,omitempty" bson:"contact,omitempty"`
+	Insurer   string    `json:"insurer,omitempty" bson:"insurer,omitempty" binding:"max-length-50"`
+}
@@ -0,0 +1,9 @@
+package pn_registry
+
+type Reason struct {
+	Id              string      `json:"id" bson:"id"`
+	Label           ReasonLabel `json:"label" bson:"label"`
+	MaxDurationDays int         `json:"maxDurationDays,omitempty" bson:"maxDurationDays,omitempty"`
+	CheckUpRequired bool        `json:"checkUpRequired" bson:"checkUpRequired"`
+	Injury          bool        `json:"injury" bson:"injury"`
+}
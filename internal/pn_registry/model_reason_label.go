@@ -0,0 +1,6 @@
+package pn_registry
+
+type ReasonLabel struct {
+	Sk string `json:"sk" bson:"sk"`
+	En string `json:"en" bson:"en"`
+}
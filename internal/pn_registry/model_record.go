@@ -7,7 +7,7 @@ import (
 // Custom date type to work with formats dd-mm-yyyy without time part
 type DateType time.Time
 
-// Enum constant of possible values of field "reason"
+// IDs of built-in reasons of field "reason" (see reason catalog)
 const (
 	Choroba                     = "choroba"
 	Uraz                        = "uraz"
@@ -17,15 +17,38 @@ const (
 	Ine                         = "ine"
 )
 
+// Enum constant of possible values of field "status" - lifecycle state of the record
+const (
+	StatusIssued    = "issued"
+	StatusActive    = "active"
+	StatusClosed    = "closed"
+	StatusCancelled = "cancelled"
+	StatusExtended  = "extended"
+)
+
 type Record struct {
-	Id          string    `json:"id" bson:"id" binding:"required"`
-	FullName    string    `json:"fullName,omitempty" bson:"fullName,omitempty" binding:"max-length-50"`
-	PatientId   string    `json:"patientId" bson:"patientId" binding:"required,only-digits-max-length-10"`
-	Employer    string    `json:"employer" bson:"employer" binding:"required,max-length-50"`
-	Reason      string    `json:"reason" bson:"reason" binding:"required,not-valid-reason-value"`
-	Issued      DateType  `json:"issued" bson:"issued" binding:"required"`
-	ValidFrom   DateType  `json:"validFrom" bson:"validFrom" binding:"required"`
-	ValidUntil  DateType  `json:"validUntil" bson:"validUntil" binding:"required"`
-	CheckUp     *DateType `json:"checkUp,omitempty" bson:"checkUp,omitempty"`
-	CheckUpDone bool      `json:"checkUpDone" bson:"checkUpDone"`
+	Id            string    `json:"id" bson:"id" binding:"required"`
+	FullName      string    `json:"fullName,omitempty" bson:"fullName,omitempty" binding:"max-length-50"`
+	PatientId     string    `json:"patientId" bson:"patientId" binding:"required,only-digits-max-length-10"`
+	Employer      string    `json:"employer" bson:"employer" binding:"required_without=EmployerId,max-length-50"`
+	EmployerId    string    `json:"employerId,omitempty" bson:"employerId,omitempty"`
+	Reason        string    `json:"reason" bson:"reason" binding:"required,not-valid-reason-value"`
+	Diagnosis     string    `json:"diagnosis,omitempty" bson:"diagnosis,omitempty" binding:"omitempty,icd10-code"`
+	Issued        DateType  `json:"issued" bson:"issued" binding:"required"`
+	ValidFrom     DateType  `json:"validFrom" bson:"validFrom" binding:"required"`
+	ValidUntil    DateType  `json:"validUntil" bson:"validUntil" binding:"required"`
+	CheckUp       *DateType `json:"checkUp,omitempty" bson:"checkUp,omitempty"`
+	CheckUpDone   bool      `json:"checkUpDone" bson:"checkUpDone"`
+	CheckUps      []CheckUp `json:"checkUps,omitempty" bson:"checkUps,omitempty" binding:"dive"`
+	Status        string    `json:"status" bson:"status"`
+	PredecessorId string    `json:"predecessorId,omitempty" bson:"predecessorId,omitempty"`
+	EpisodeId     string    `json:"episodeId,omitempty" bson:"episodeId,omitempty"`
+	Version       int64     `json:"version" bson:"version"`
+	Deleted       *Deletion `json:"deleted,omitempty" bson:"deleted,omitempty"`
+}
+
+// Information about soft deletion of the record
+type Deletion struct {
+	Reason    string    `json:"reason,omitempty" bson:"reason,omitempty"`
+	DeletedAt time.Time `json:"deletedAt" bson:"deletedAt"`
 }
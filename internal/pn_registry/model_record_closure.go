@@ -0,0 +1,5 @@
+package pn_registry
+
+type RecordClosure struct {
+	EndDate *DateType `json:"endDate,omitempty"`
+}
@@ -0,0 +1,9 @@
+package pn_registry
+
+type RecordContinuation struct {
+	Id         string    `json:"id"`
+	Issued     DateType  `json:"issued" binding:"required"`
+	ValidFrom  DateType  `json:"validFrom" binding:"required"`
+	ValidUntil DateType  `json:"validUntil" binding:"required"`
+	CheckUp    *DateType `json:"checkUp,omitempty"`
+}
@@ -0,0 +1,5 @@
+package pn_registry
+
+type RecordExtension struct {
+	ValidUntil DateType `json:"validUntil" binding:"required"`
+}
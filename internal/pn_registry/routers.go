@@ -14,12 +14,42 @@ import (
     "github.com/gin-gonic/gin"
 )
 
-func AddRoutes(engine *gin.Engine) {
-  group := engine.Group("/api")
+func AddRoutes(engine *gin.Engine, middleware ...gin.HandlerFunc) {
+  group := engine.Group("/api", middleware...)
+  
+  {
+    api := newCheckUpsAPI()
+    api.addRoutes(group)
+  }
+  
+  {
+    api := newDiagnosesAPI()
+    api.addRoutes(group)
+  }
+  
+  {
+    api := newReasonsAPI()
+    api.addRoutes(group)
+  }
+  
+  {
+    api := newEmployersAPI()
+    api.addRoutes(group)
+  }
+  
+  {
+    api := newPatientsAPI()
+    api.addRoutes(group)
+  }
   
   {
     api := newPnRegistryRecordsAPI()
     api.addRoutes(group)
   }
   
+  {
+    api := newApiKeysAPI()
+    api.addRoutes(group)
+  }
+  
 }
\ No newline at end of file
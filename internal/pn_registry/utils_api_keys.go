@@ -0,0 +1,276 @@
+package pn_registry
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bmathus/pnregistry-webapi/internal/auth"
+	"github.com/bmathus/pnregistry-webapi/internal/db_service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// prefix of API keys, keys have form pnr_<key id>_<secret>
+const apiKeyPrefix = "pnr_"
+
+// Configuration of API keys of machine clients
+type ApiKeyConfig struct {
+	TenantClaim   string        // claim of caller identity with tenant ID, key is bound to tenant of its issuer
+	AdminRole     string        // role of callers authenticated by bearer token allowed to manage API keys
+	FlushInterval time.Duration // interval of storing collected usage statistics of keys
+}
+
+// Store of API keys, only hashes of keys are stored in database. Keys of all tenants are stored
+// in one collection, because the key has to be verified before tenant of the request is known,
+// every key is bound to tenant of its issuer. Usage of keys is collected in memory and periodically
+// added to usage statistics in separate collection, so that requests do not wait for database writes.
+type apiKeyStore struct {
+	config  ApiKeyConfig
+	keyDb   db_service.DbService[ApiKey]
+	usageDb db_service.DbService[ApiKeyUsage]
+	lock    sync.Mutex
+	pending map[string]*ApiKeyUsage // usage not yet added to statistics in database
+}
+
+// API keys store, nil when API keys are not enabled
+var apiKeys *apiKeyStore
+
+// Enables API keys and starts background job which stores usage statistics of keys,
+// the job stops when the context is cancelled. Returned verifier authenticates requests with API key.
+func EnableApiKeys(ctx context.Context, keyDb db_service.DbService[ApiKey], usageDb db_service.DbService[ApiKeyUsage], config ApiKeyConfig) auth.KeyVerifier {
+	apiKeys = &apiKeyStore{
+		config:  config,
+		keyDb:   keyDb,
+		usageDb: usageDb,
+		pending: map[string]*ApiKeyUsage{},
+	}
+	log.Printf("API keys are enabled, keys are managed by role %v, usage is stored every %v", config.AdminRole, config.FlushInterval)
+
+	go func() {
+		ticker := time.NewTicker(config.FlushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				apiKeys.flushUsage(context.Background())
+				return
+			case <-ticker.C:
+				apiKeys.flushUsage(ctx)
+			}
+		}
+	}()
+	return apiKeys
+}
+
+// verifies the key against stored hash, previous key of rotated key is accepted until end of its grace period
+func (this *apiKeyStore) VerifyKey(ctx context.Context, key string) (auth.Identity, error) {
+	keyId, _, valid := parseApiKey(key)
+	if !valid {
+		return auth.Identity{}, errors.New("API key has invalid format")
+	}
+
+	apiKey, err := this.keyDb.FindDocument(ctx, keyId)
+	switch {
+	case err == db_service.ErrNotFound:
+		return auth.Identity{}, errors.New("API key is not valid")
+	case err != nil:
+		log.Printf("Failed to load API key %v: %v", keyId, err)
+		return auth.Identity{}, errors.New("API key cannot be verified now")
+	}
+
+	now := time.Now()
+	hash := hashApiKey(key)
+	matches := subtle.ConstantTimeCompare([]byte(hash), []byte(apiKey.Hash)) == 1
+	if !matches && apiKey.PreviousHash != "" && apiKey.PreviousValidUntil != nil && now.Before(*apiKey.PreviousValidUntil) {
+		matches = subtle.ConstantTimeCompare([]byte(hash), []byte(apiKey.PreviousHash)) == 1
+	}
+	switch {
+	case !matches:
+		return auth.Identity{}, errors.New("API key is not valid")
+	case apiKey.Revoked != nil:
+		return auth.Identity{}, errors.New("API key was revoked")
+	case apiKey.ExpiresAt != nil && !now.Before(*apiKey.ExpiresAt):
+		return auth.Identity{}, errors.New("API key has expired")
+	}
+
+	return this.identity(apiKey), nil
+}
+
+// adds the request to usage of the key, usage is stored by background job
+func (this *apiKeyStore) RecordUsage(keyId string, status int, clientIp string) {
+	now := time.Now()
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	usage, exists := this.pending[keyId]
+	if !exists {
+		usage = &ApiKeyUsage{Id: keyId}
+		this.pending[keyId] = usage
+	}
+	usage.Requests++
+	if status >= 400 {
+		usage.Failed++
+	}
+	usage.LastUsed = &now
+	usage.LastIp = clientIp
+}
+
+// returns usage statistics of the key including usage not yet stored
+func (this *apiKeyStore) Usage(ctx context.Context, keyId string) (*ApiKeyUsage, error) {
+	usage, err := this.usageDb.FindDocument(ctx, keyId)
+	switch {
+	case err == db_service.ErrNotFound:
+		usage = &ApiKeyUsage{Id: keyId}
+	case err != nil:
+		return nil, err
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if pending, exists := this.pending[keyId]; exists {
+		addUsage(usage, pending)
+	}
+	return usage, nil
+}
+
+// gin middleware which allows management of API keys only to callers with admin role authenticated
+// by bearer token, so that client cannot issue itself new keys
+func authorizeKeyAdmin(ctx *gin.Context) {
+	if apiKeys == nil {
+		ctx.AbortWithStatusJSON(http.StatusNotFound,
+			gin.H{
+				"status":  "Not Found",
+				"message": "API keys are not enabled",
+			})
+		return
+	}
+
+	identity, ok := auth.IdentityFromContext(ctx)
+	switch {
+	case !ok:
+		forbid(ctx, "Caller is not authenticated")
+	case identity.KeyId != "":
+		forbid(ctx, "API keys cannot be managed by callers authenticated by API key")
+	case !identity.HasRole(apiKeys.config.AdminRole):
+		forbid(ctx, fmt.Sprintf("Role %s is required to manage API keys", apiKeys.config.AdminRole))
+	default:
+		ctx.Next()
+	}
+}
+
+// Utility function which builds identity of the client owning the key,
+// scopes of the key are roles of the client evaluated by access policy
+func (this *apiKeyStore) identity(apiKey *ApiKey) auth.Identity {
+	claims := map[string]interface{}{}
+	for name, value := range apiKey.Claims {
+		claims[name] = value
+	}
+	claims["sub"] = "api-key:" + apiKey.Id
+	claims["name"] = apiKey.Name
+	if apiKey.Tenant != "" {
+		claims[this.config.TenantClaim] = apiKey.Tenant
+	}
+
+	return auth.Identity{
+		Subject: "api-key:" + apiKey.Id,
+		Name:    apiKey.Name,
+		Roles:   append([]string{}, apiKey.Scopes...),
+		Claims:  claims,
+		KeyId:   apiKey.Id,
+	}
+}
+
+// Utility function which adds collected usage to statistics in database, statistics are updated
+// only when not changed meanwhile by other instance of the service, otherwise usage is kept for next run
+func (this *apiKeyStore) flushUsage(ctx context.Context) {
+	this.lock.Lock()
+	pending := this.pending
+	this.pending = map[string]*ApiKeyUsage{}
+	this.lock.Unlock()
+
+	failed := 0
+	for keyId, usage := range pending {
+		stored, err := this.usageDb.FindDocument(ctx, keyId)
+		switch {
+		case err == db_service.ErrNotFound:
+			err = this.usageDb.CreateDocument(ctx, keyId, usage)
+		case err == nil:
+			condition := db_service.Eq("requests", stored.Requests)
+			addUsage(stored, usage)
+			err = this.usageDb.UpdateDocumentIf(ctx, keyId, condition, stored)
+		}
+
+		if err != nil {
+			failed++
+			this.lock.Lock()
+			if newer, exists := this.pending[keyId]; exists {
+				addUsage(usage, newer)
+			}
+			this.pending[keyId] = usage
+			this.lock.Unlock()
+		}
+	}
+
+	if failed > 0 {
+		log.Printf("Failed to store usage of %v API keys, it will be stored in next run", failed)
+	}
+}
+
+// Utility function which adds usage to the statistics, the later usage determines last use
+func addUsage(usage *ApiKeyUsage, added *ApiKeyUsage) {
+	usage.Requests += added.Requests
+	usage.Failed += added.Failed
+	if added.LastUsed != nil && (usage.LastUsed == nil || added.LastUsed.After(*usage.LastUsed)) {
+		usage.LastUsed = added.LastUsed
+		usage.LastIp = added.LastIp
+	}
+}
+
+// Utility function which generates new secret key with given ID, returns the key and its hash
+func newApiKey(keyId string) (string, string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+
+	key := apiKeyPrefix + keyId + "_" + hex.EncodeToString(secret)
+	return key, hashApiKey(key), nil
+}
+
+// Utility function which returns beginning of the key with few characters of the secret,
+// so that users can recognize the key without storing it
+func apiKeyHint(key string) string {
+	keyId, secret, _ := parseApiKey(key)
+	return apiKeyPrefix + keyId + "_" + secret[:4]
+}
+
+// Utility function which splits the key into key ID and secret
+func parseApiKey(key string) (string, string, bool) {
+	rest, found := strings.CutPrefix(key, apiKeyPrefix)
+	if !found {
+		return "", "", false
+	}
+	keyId, secret, found := strings.Cut(rest, "_")
+	if _, err := uuid.Parse(keyId); !found || err != nil || secret == "" {
+		return "", "", false
+	}
+	return keyId, secret, true
+}
+
+// keys are random with 256 bits of entropy, so a single round of SHA-256 is sufficient to protect them at rest
+func hashApiKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
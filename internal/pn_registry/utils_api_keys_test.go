@@ -0,0 +1,279 @@
+package pn_registry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bmathus/pnregistry-webapi/internal/auth"
+	"github.com/bmathus/pnregistry-webapi/internal/db_service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// stores API key changed by the test and returns its secret key
+func storeTestApiKey(t *testing.T, change func(apiKey *ApiKey)) (string, *ApiKey) {
+	t.Helper()
+	apiKey := &ApiKey{Id: uuid.New().String(), Name: "Mzdovy system", Scopes: []string{"hr"}, Tenant: "a", Created: time.Now()}
+	key, hash, err := newApiKey(apiKey.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiKey.Hash = hash
+	apiKey.Prefix = apiKeyHint(key)
+	if change != nil {
+		change(apiKey)
+	}
+	if err := apiKeys.keyDb.CreateDocument(context.Background(), apiKey.Id, apiKey); err != nil {
+		t.Fatal(err)
+	}
+	return key, apiKey
+}
+
+// service of API keys which fails to load them, as if database was not available
+type unavailableKeyService struct {
+	db_service.DbService[ApiKey]
+}
+
+func (this unavailableKeyService) FindDocument(ctx context.Context, id string) (*ApiKey, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestVerifyApiKey(t *testing.T) {
+	enableTestApiKeys(t)
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	previous, _, err := newApiKey(uuid.New().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid, validKey := storeTestApiKey(t, func(apiKey *ApiKey) { apiKey.Claims = map[string]string{"employer_id": "e1"} })
+	revoked, _ := storeTestApiKey(t, func(apiKey *ApiKey) { apiKey.Revoked = &past })
+	expired, _ := storeTestApiKey(t, func(apiKey *ApiKey) { apiKey.ExpiresAt = &past })
+	expiring, _ := storeTestApiKey(t, func(apiKey *ApiKey) { apiKey.ExpiresAt = &future })
+	// previous key of rotated key with the same ID as the new key
+	rotate := func(validUntil *time.Time) func(apiKey *ApiKey) {
+		return func(apiKey *ApiKey) {
+			_, secret, _ := parseApiKey(previous)
+			apiKey.PreviousHash = hashApiKey(apiKeyPrefix + apiKey.Id + "_" + secret)
+			apiKey.PreviousValidUntil = validUntil
+		}
+	}
+	previousKey := func(key string) string {
+		keyId, _, _ := parseApiKey(key)
+		_, secret, _ := parseApiKey(previous)
+		return apiKeyPrefix + keyId + "_" + secret
+	}
+	inGrace, _ := storeTestApiKey(t, rotate(&future))
+	afterGrace, _ := storeTestApiKey(t, rotate(&past))
+	withoutGrace, _ := storeTestApiKey(t, rotate(nil))
+	revokedInGrace, _ := storeTestApiKey(t, func(apiKey *ApiKey) {
+		rotate(&future)(apiKey)
+		apiKey.Revoked = &past
+	})
+	validId, validSecret, _ := parseApiKey(valid)
+
+	tests := []struct {
+		name string
+		key  string
+		err  string
+	}{
+		{"valid", valid, ""},
+		{"not expired yet", expiring, ""},
+		{"without prefix", strings.TrimPrefix(valid, apiKeyPrefix), "invalid format"},
+		{"invalid key ID", apiKeyPrefix + "key_" + validSecret, "invalid format"},
+		{"without secret", apiKeyPrefix + validId + "_", "invalid format"},
+		{"unknown key ID", apiKeyPrefix + uuid.New().String() + "_" + validSecret, "is not valid"},
+		{"wrong secret", apiKeyPrefix + validId + "_" + strings.Repeat("0", 64), "is not valid"},
+		{"revoked", revoked, "was revoked"},
+		{"expired", expired, "has expired"},
+		{"new key of rotated key", inGrace, ""},
+		{"previous key in grace period", previousKey(inGrace), ""},
+		{"previous key after grace period", previousKey(afterGrace), "is not valid"},
+		{"previous key without grace period", previousKey(withoutGrace), "is not valid"},
+		{"previous key of revoked key", previousKey(revokedInGrace), "was revoked"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := apiKeys.VerifyKey(context.Background(), test.key)
+			if test.err == "" && err != nil {
+				t.Errorf("Expected valid key, got %v", err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("Expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+
+	// scopes of the key are roles of its client, the client is bound to tenant of the key
+	identity, err := apiKeys.VerifyKey(context.Background(), valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.KeyId != validKey.Id || identity.Subject != "api-key:"+validKey.Id || !identity.HasRole("hr") {
+		t.Errorf("Expected identity of the key, got %+v", identity)
+	}
+	if identity.Claims["tenant"] != "a" || identity.Claims["employer_id"] != "e1" {
+		t.Errorf("Expected claims of the key with its tenant, got %v", identity.Claims)
+	}
+
+	// failure of database is not reported as invalid key
+	apiKeys.keyDb = unavailableKeyService{apiKeys.keyDb}
+	if _, err := apiKeys.VerifyKey(context.Background(), valid); err == nil || !strings.Contains(err.Error(), "cannot be verified now") {
+		t.Errorf("Expected key not verified without database, got %v", err)
+	}
+}
+
+func TestApiKeyUsage(t *testing.T) {
+	enableTestApiKeys(t)
+	ctx := context.Background()
+
+	apiKeys.RecordUsage("k1", http.StatusOK, "10.0.0.1")
+	apiKeys.RecordUsage("k1", http.StatusForbidden, "10.0.0.2")
+	usage, err := apiKeys.Usage(ctx, "k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Requests != 2 || usage.Failed != 1 || usage.LastIp != "10.0.0.2" || usage.LastUsed == nil {
+		t.Errorf("Expected usage not yet stored, got %+v", usage)
+	}
+	if usage, err := apiKeys.Usage(ctx, "k2"); err != nil || usage.Requests != 0 {
+		t.Errorf("Expected no usage of unused key, got %+v: %v", usage, err)
+	}
+
+	// stored usage is added to statistics of previous runs
+	apiKeys.flushUsage(ctx)
+	apiKeys.RecordUsage("k1", http.StatusInternalServerError, "10.0.0.3")
+	apiKeys.flushUsage(ctx)
+	stored, err := apiKeys.usageDb.FindDocument(ctx, "k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Requests != 3 || stored.Failed != 2 || stored.LastIp != "10.0.0.3" {
+		t.Errorf("Expected stored usage of all requests, got %+v", stored)
+	}
+	if len(apiKeys.pending) != 0 {
+		t.Errorf("Expected no pending usage after it was stored, got %v", apiKeys.pending)
+	}
+
+	apiKeys.RecordUsage("k1", http.StatusOK, "10.0.0.4")
+	if usage, err := apiKeys.Usage(ctx, "k1"); err != nil || usage.Requests != 4 || usage.LastIp != "10.0.0.4" {
+		t.Errorf("Expected stored and pending usage, got %+v: %v", usage, err)
+	}
+}
+
+// service of usage statistics which stores usage of other instance of the service right before
+// first write of every key, as if the other instance stored its usage meanwhile
+type concurrentUsageService struct {
+	db_service.DbService[ApiKeyUsage]
+	other   ApiKeyUsage
+	written map[string]bool
+}
+
+func (this concurrentUsageService) writeOther(ctx context.Context, keyId string) {
+	if this.written[keyId] {
+		return
+	}
+	this.written[keyId] = true
+	other := this.other
+	other.Id = keyId
+	stored, err := this.DbService.FindDocument(ctx, keyId)
+	if err == db_service.ErrNotFound {
+		this.DbService.CreateDocument(ctx, keyId, &other)
+		return
+	}
+	addUsage(stored, &other)
+	this.DbService.UpdateDocument(ctx, keyId, stored)
+}
+
+func (this concurrentUsageService) CreateDocument(ctx context.Context, id string, document *ApiKeyUsage) error {
+	this.writeOther(ctx, id)
+	return this.DbService.CreateDocument(ctx, id, document)
+}
+
+func (this concurrentUsageService) UpdateDocumentIf(ctx context.Context, id string, condition db_service.Filter, document *ApiKeyUsage) error {
+	this.writeOther(ctx, id)
+	return this.DbService.UpdateDocumentIf(ctx, id, condition, document)
+}
+
+func TestFlushApiKeyUsageConflict(t *testing.T) {
+	enableTestApiKeys(t)
+	ctx := context.Background()
+	memory := apiKeys.usageDb
+	lastUsed := time.Now().Add(-time.Hour)
+	apiKeys.usageDb = concurrentUsageService{memory, ApiKeyUsage{Requests: 10, Failed: 1, LastUsed: &lastUsed, LastIp: "10.0.0.9"}, map[string]bool{}}
+
+	// usage of new key conflicts with usage created by other instance
+	apiKeys.RecordUsage("k1", http.StatusOK, "10.0.0.1")
+	apiKeys.flushUsage(ctx)
+	if pending := apiKeys.pending["k1"]; pending == nil || pending.Requests != 1 {
+		t.Fatalf("Expected usage in conflict to be kept for next run, got %+v", pending)
+	}
+
+	// usage recorded meanwhile is merged with the kept usage
+	apiKeys.RecordUsage("k1", http.StatusBadRequest, "10.0.0.2")
+	apiKeys.flushUsage(ctx)
+	stored, err := memory.FindDocument(ctx, "k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Requests != 12 || stored.Failed != 2 || stored.LastIp != "10.0.0.2" {
+		t.Errorf("Expected usage of both instances, got %+v", stored)
+	}
+
+	// usage of key with statistics conflicts with statistics updated by other instance
+	apiKeys.RecordUsage("k1", http.StatusOK, "10.0.0.3")
+	apiKeys.usageDb = concurrentUsageService{memory, ApiKeyUsage{Requests: 5}, map[string]bool{}}
+	apiKeys.flushUsage(ctx)
+	if pending := apiKeys.pending["k1"]; pending == nil || pending.Requests != 1 {
+		t.Fatalf("Expected usage in conflict to be kept for next run, got %+v", pending)
+	}
+	apiKeys.flushUsage(ctx)
+	if stored, err := memory.FindDocument(ctx, "k1"); err != nil || stored.Requests != 18 || stored.LastIp != "10.0.0.3" {
+		t.Errorf("Expected usage of both instances, got %+v: %v", stored, err)
+	}
+}
+
+func TestAuthorizeKeyAdmin(t *testing.T) {
+	engine := newTestEngine(newTestServices(), func(ctx *gin.Context) {
+		switch ctx.GetHeader("X-Test-Caller") {
+		case "admin":
+			ctx.Set("identity", auth.Identity{Subject: "admin", Roles: []string{"admin"}})
+		case "doctor":
+			ctx.Set("identity", auth.Identity{Subject: "doctor", Roles: []string{"doctor"}})
+		case "api-key":
+			ctx.Set("identity", auth.Identity{Subject: "api-key:k1", Roles: []string{"admin"}, KeyId: "k1"})
+		}
+		ctx.Next()
+	})
+	caller := func(name string) map[string]string { return map[string]string{"X-Test-Caller": name} }
+
+	// management of keys is not available when API keys are not enabled
+	expectStatus(t, doRequest(engine, http.MethodGet, "/api/api-keys/", nil, caller("admin")), http.StatusNotFound)
+
+	enableTestApiKeys(t)
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+	}{
+		{"admin", caller("admin"), http.StatusOK},
+		{"anonymous", nil, http.StatusForbidden},
+		{"without admin role", caller("doctor"), http.StatusForbidden},
+		{"client with admin scope", caller("api-key"), http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expectStatus(t, doRequest(engine, http.MethodGet, "/api/api-keys/", nil, test.headers), test.status)
+			request := map[string]interface{}{"name": "Mzdovy system", "scopes": []string{"hr"}}
+			status := test.status
+			if status == http.StatusOK {
+				status = http.StatusCreated
+			}
+			expectStatus(t, doRequest(engine, http.MethodPost, "/api/api-keys/", request, test.headers), status)
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package pn_registry
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/bmathus/pnregistry-webapi/internal/auth"
+	"github.com/bmathus/pnregistry-webapi/internal/db_service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Utility function which persists audit entry about change of the record. Failure to store
+// the entry does not fail the request as the change was already made, it is only logged.
+func recordAudit(ctx *gin.Context, action string, recordId string, before *Record, after *Record) {
+	value, exists := ctx.Get("audit_service")
+	if !exists {
+		log.Printf("Audit of %v of record %v skipped: audit_service not found", action, recordId)
+		return
+	}
+
+	auditDb, ok := value.(db_service.DbService[AuditEntry])
+	if !ok {
+		log.Printf("Audit of %v of record %v skipped: audit_service context is not of type db_service.DbService", action, recordId)
+		return
+	}
+
+	entry := newAuditEntry(action, recordId, auditActor(ctx), ctx.GetString("request_id"), before, after)
+	storeAudit(ctx, auditDb, entry)
+}
+
+func newAuditEntry(action string, recordId string, actor string, requestId string, before *Record, after *Record) AuditEntry {
+	entry := AuditEntry{
+		Id:        uuid.New().String(),
+		RecordId:  recordId,
+		Action:    action,
+		Actor:     actor,
+		RequestId: requestId,
+		Timestamp: time.Now().UTC(),
+		Before:    before,
+		After:     after,
+		Changes:   diffRecords(before, after),
+	}
+	if after != nil {
+		entry.Version = after.Version
+	} else if before != nil {
+		entry.Version = before.Version
+	}
+	return entry
+}
+
+func storeAudit(ctx context.Context, auditDb db_service.DbService[AuditEntry], entry AuditEntry) {
+	if err := auditDb.CreateDocument(ctx, entry.Id, &entry); err != nil {
+		log.Printf("Failed to store audit of %v of record %v: %v", entry.Action, entry.RecordId, err)
+	}
+}
+
+// identity of the caller responsible for the change
+func auditActor(ctx *gin.Context) string {
+	// authenticated caller, X-User header is used only when authentication is disabled
+	if identity, ok := auth.IdentityFromContext(ctx); ok {
+		return identity.Subject
+	}
+	if actor := ctx.GetHeader("X-User"); actor != "" {
+		return actor
+	}
+	return "anonymous"
+}
+
+// Utility function which lists changed fields between two versions of the record,
+// fields are compared and stored in their JSON representation as seen by API clients
+func diffRecords(before *Record, after *Record) []FieldChange {
+	beforeFields, afterFields := recordFields(before), recordFields(after)
+
+	fields := []string{}
+	for field := range beforeFields {
+		fields = append(fields, field)
+	}
+	for field := range afterFields {
+		if _, exists := beforeFields[field]; !exists {
+			fields = append(fields, field)
+		}
+	}
+	slices.Sort(fields)
+
+	changes := []FieldChange{}
+	for _, field := range fields {
+		if !reflect.DeepEqual(beforeFields[field], afterFields[field]) {
+			changes = append(changes, FieldChange{
+				Field:  field,
+				Before: fieldValue(beforeFields, field),
+				After:  fieldValue(afterFields, field),
+			})
+		}
+	}
+	return changes
+}
+
+func recordFields(record *Record) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if record == nil {
+		return fields
+	}
+
+	data, err := json.Marshal(record)
+	if err == nil {
+		err = json.Unmarshal(data, &fields)
+	}
+	if err != nil {
+		log.Printf("Failed to convert record %v for audit: %v", record.Id, err)
+	}
+	return fields
+}
+
+// Utility function which returns JSON of the field, nil when the record does not have the field,
+// so that nested values are stored as they are seen by API clients and not as generic documents
+func fieldValue(fields map[string]interface{}, field string) json.RawMessage {
+	value, exists := fields[field]
+	if !exists {
+		return nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("Failed to convert field %v for audit: %v", field, err)
+		return nil
+	}
+	return data
+}
+
+// Utility function which reconstructs state of the record at given time from its audit trail,
+// returns ErrNotFound when the record did not exist at that time
+func recordAsOf(ctx context.Context, auditDb db_service.DbService[AuditEntry], recordId string, asOf time.Time) (*Record, error) {
+	entries, _, err := auditDb.QueryDocuments(ctx, db_service.Query{
+		Filter: db_service.And(
+			db_service.Eq("recordId", recordId),
+			db_service.Lte("timestamp", asOf),
+		),
+		Sort: []db_service.SortField{
+			{Field: "timestamp", Descending: true},
+			{Field: "version", Descending: true},
+		},
+		Limit: 1,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// last change before given time was deletion or there was no change at all
+	if len(entries) == 0 || entries[0].After == nil || entries[0].After.Deleted != nil {
+		return nil, db_service.ErrNotFound
+	}
+	return entries[0].After, nil
+}
@@ -0,0 +1,79 @@
+package pn_registry
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestRecordHistoryNestedChanges(t *testing.T) {
+	engine := newTestEngine(newTestServices())
+	createTestRecord(t, engine, newTestRecord("r1", "123", "2024-01-01", "2024-01-20"))
+
+	updated := newTestRecord("r1", "123", "2024-01-01", "2024-01-20")
+	updated["checkUps"] = []map[string]interface{}{{"date": "2024-01-10"}}
+	expectStatus(t, doRequest(engine, http.MethodPut, "/api/records/r1/", updated, nil), http.StatusOK)
+
+	recorder := doRequest(engine, http.MethodGet, "/api/records/r1/history", nil, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	entries := decodeResponse[[]AuditEntry](t, recorder)
+	if len(entries) != 2 {
+		t.Fatalf("Expected create and update in history, got %+v", entries)
+	}
+
+	for _, change := range entries[1].Changes {
+		if change.Field != "checkUps" {
+			continue
+		}
+		if string(change.Before) != "null" {
+			t.Errorf("Expected no check-ups before change, got %s", change.Before)
+		}
+		var checkUps []CheckUp
+		if err := json.Unmarshal(change.After, &checkUps); err != nil {
+			t.Fatalf("Expected list of check-ups after change, got %s: %v", change.After, err)
+		}
+		if len(checkUps) != 1 || checkUps[0].Date.String() != "2024-01-10" {
+			t.Errorf("Unexpected check-ups after change %+v", checkUps)
+		}
+		return
+	}
+	t.Errorf("Expected change of check-ups in history, got %+v", entries[1].Changes)
+}
+
+func TestFieldChangeLegacyValues(t *testing.T) {
+	// changes stored with values as generic documents are read as JSON
+	data, err := bson.Marshal(bson.D{
+		{Key: "field", Value: "checkUps"},
+		{Key: "before", Value: nil},
+		{Key: "after", Value: bson.A{bson.D{{Key: "date", Value: "2024-01-10"}, {Key: "done", Value: false}}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var change FieldChange
+	if err := bson.Unmarshal(data, &change); err != nil {
+		t.Fatal(err)
+	}
+	if change.Before != nil {
+		t.Errorf("Expected no value before change, got %s", change.Before)
+	}
+	if after := string(change.After); after != `[{"date":"2024-01-10","done":false}]` {
+		t.Errorf("Unexpected value after change %s", after)
+	}
+
+	// current changes are read as they were stored
+	stored := FieldChange{Field: "employer", Before: json.RawMessage(`"A"`), After: json.RawMessage(`"B"`)}
+	data, err = bson.Marshal(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bson.Unmarshal(data, &change); err != nil {
+		t.Fatal(err)
+	}
+	if string(change.Before) != `"A"` || string(change.After) != `"B"` {
+		t.Errorf("Unexpected change %+v", change)
+	}
+}
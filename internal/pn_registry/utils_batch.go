@@ -0,0 +1,62 @@
+package pn_registry
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Enum constant of possible values of field "op" of batch operation
+const (
+	BatchOpCreate = "create"
+	BatchOpUpdate = "update"
+	BatchOpDelete = "delete"
+)
+
+// names of single record operations corresponding to batch operations, used for authorization
+var batchOperationNames = map[string]string{
+	BatchOpCreate: "CreateRecord",
+	BatchOpUpdate: "UpdateRecord",
+	BatchOpDelete: "DeleteRecord",
+}
+
+// result of operation of atomic batch which was not applied because other operation failed
+var errBatchRolledBack = newRecordError(http.StatusFailedDependency, "Operation was not applied, other operation of atomic batch failed", nil)
+
+// Utility function which decodes and validates record of create or update operation
+// in the same way as request body of CreateRecord and UpdateRecord
+func decodeBatchRecord(operation BatchOperation) (*Record, *recordError) {
+	if len(operation.Record) == 0 {
+		return nil, newRecordError(http.StatusBadRequest, "Invalid request body", errors.New("Operation requires record"))
+	}
+
+	record := Record{}
+	if err := json.Unmarshal(operation.Record, &record); err != nil {
+		return nil, newRecordError(http.StatusBadRequest, "Invalid request body", err)
+	}
+	if err := binding.Validator.ValidateStruct(&record); err != nil {
+		return nil, newRecordError(http.StatusBadRequest, "Invalid request body", err)
+	}
+	return &record, nil
+}
+
+// sets result of the operation to the error
+func (r *BatchOperationResult) fail(recordErr *recordError) {
+	r.Status = recordErr.Status
+	r.ETag = ""
+	r.Body = recordErr.body()
+}
+
+// counts succeeded and failed operations of the response
+func (r *BatchResponse) countResults() {
+	r.Succeeded, r.Failed = 0, 0
+	for _, result := range r.Results {
+		if result.Status < http.StatusBadRequest {
+			r.Succeeded++
+		} else {
+			r.Failed++
+		}
+	}
+}
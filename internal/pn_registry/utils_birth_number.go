@@ -0,0 +1,90 @@
+package pn_registry
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Enum constant of possible values of field "sex" derived from birth number
+const (
+	SexMale   = "male"
+	SexFemale = "female"
+)
+
+// Data derived from Slovak/Czech birth number (rodné číslo)
+type BirthNumberInfo struct {
+	BirthDate DateType
+	Sex       string
+}
+
+var birthNumberPattern = regexp.MustCompile(`^\d{9,10}$`)
+
+// Utility function which parses Slovak/Czech birth number in form YYMMDDXXX (issued until 1953)
+// or YYMMDDXXXX (since 1954, divisible by 11). Women have month increased by 50, since 2004
+// month can be increased by additional 20 when the numbers for the day are exhausted.
+func ParseBirthNumber(birthNumber string) (BirthNumberInfo, error) {
+	if !birthNumberPattern.MatchString(birthNumber) {
+		return BirthNumberInfo{}, errors.New("Birth number must have 9 or 10 digits")
+	}
+
+	year, _ := strconv.Atoi(birthNumber[0:2])
+	month, _ := strconv.Atoi(birthNumber[2:4])
+	day, _ := strconv.Atoi(birthNumber[4:6])
+
+	if len(birthNumber) == 9 {
+		// 9 digit numbers without checksum were issued only to people born before 1954
+		if year >= 54 {
+			return BirthNumberInfo{}, errors.New("Birth number of people born since 1954 must have 10 digits")
+		}
+		year += 1900
+	} else {
+		if year >= 54 {
+			year += 1900
+		} else {
+			year += 2000
+		}
+
+		number, _ := strconv.ParseInt(birthNumber, 10, 64)
+		if number%11 != 0 {
+			// until 1985 numbers with remainder 10 of first 9 digits were issued with check digit 0
+			first, _ := strconv.ParseInt(birthNumber[0:9], 10, 64)
+			if year >= 1985 || first%11 != 10 || birthNumber[9] != '0' {
+				return BirthNumberInfo{}, errors.New("Birth number has invalid checksum")
+			}
+		}
+	}
+
+	info := BirthNumberInfo{Sex: SexMale}
+	if month > 50 {
+		info.Sex = SexFemale
+		month -= 50
+	}
+	if month > 20 {
+		if year < 2004 {
+			return BirthNumberInfo{}, errors.New("Birth number has invalid month")
+		}
+		month -= 20
+	}
+
+	birthDate := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	if month < 1 || month > 12 || birthDate.Day() != day || int(birthDate.Month()) != month {
+		return BirthNumberInfo{}, errors.New("Birth number has invalid date of birth")
+	}
+
+	info.BirthDate = DateType(birthDate)
+	if info.BirthDate.After(Today()) {
+		return BirthNumberInfo{}, errors.New("Birth number has date of birth in the future")
+	}
+
+	return info, nil
+}
+
+// custom validator for PatientId fields in birth number validation mode - valid Slovak/Czech birth number
+func BirthNumberValidator(fl validator.FieldLevel) bool {
+	_, err := ParseBirthNumber(fl.Field().String())
+	return err == nil
+}
@@ -0,0 +1,39 @@
+package pn_registry
+
+import (
+	"testing"
+)
+
+func TestParseBirthNumber(t *testing.T) {
+	tests := []struct {
+		birthNumber string
+		valid       bool
+		birthDate   string
+		sex         string
+	}{
+		{"9001011239", true, "1990-01-01", SexMale},
+		{"530101123", true, "1953-01-01", SexMale},
+		{"0471011233", true, "2004-01-01", SexFemale},
+		// check digit 0 for remainder 10 was issued only until 1985
+		{"8001010040", true, "1980-01-01", SexMale},
+		{"8551010040", false, "", ""},
+		{"9001010030", false, "", ""},
+		{"9001011230", false, "", ""},
+		{"540101123", false, "", ""},
+		{"12345", false, "", ""},
+	}
+
+	for _, test := range tests {
+		info, err := ParseBirthNumber(test.birthNumber)
+		switch {
+		case !test.valid:
+			if err == nil {
+				t.Errorf("Expected birth number %v to be invalid", test.birthNumber)
+			}
+		case err != nil:
+			t.Errorf("Expected birth number %v to be valid: %v", test.birthNumber, err)
+		case info.BirthDate.String() != test.birthDate || info.Sex != test.sex:
+			t.Errorf("Unexpected data %v %v of birth number %v", info.BirthDate, info.Sex, test.birthNumber)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package pn_registry
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// content type of iCalendar (RFC 5545) responses
+const calendarContentType = "text/calendar; charset=utf-8"
+
+// domain part of UIDs of calendar events, UIDs are derived from record IDs so that
+// calendar apps update the same events when the feed is downloaded again
+const calendarUidDomain = "pn-registry"
+
+// All-day event of iCalendar feed, end date is inclusive
+type calendarEvent struct {
+	Uid         string
+	Start       DateType
+	End         DateType
+	Summary     string
+	Description string
+	Cancelled   bool
+}
+
+// event covering validity period of the record
+func recordValidityEvent(record Record) calendarEvent {
+	return calendarEvent{
+		Uid:         fmt.Sprintf("%s@%s", record.Id, calendarUidDomain),
+		Start:       record.ValidFrom,
+		End:         record.ValidUntil,
+		Summary:     "Sick leave: " + record.FullName,
+		Description: recordCalendarDescription(record),
+		Cancelled:   record.LifecycleStatus() == StatusCancelled,
+	}
+}
+
+// event of the check up of the record, record can have only one check up on the same date
+func recordCheckUpEvent(record Record, checkUp CheckUp) calendarEvent {
+	description := recordCalendarDescription(record)
+	if checkUp.Done {
+		description += "\nCheck up done"
+		if checkUp.Notes != "" {
+			description += ": " + checkUp.Notes
+		}
+	}
+
+	return calendarEvent{
+		Uid:         fmt.Sprintf("%s-checkup-%s@%s", record.Id, time.Time(checkUp.Date).Format("20060102"), calendarUidDomain),
+		Start:       checkUp.Date,
+		End:         checkUp.Date,
+		Summary:     "Check up: " + record.FullName,
+		Description: description,
+		Cancelled:   record.LifecycleStatus() == StatusCancelled,
+	}
+}
+
+func recordCalendarDescription(record Record) string {
+	reason := record.Reason
+	if reasonInfo, found := reasonCatalog.Find(record.Reason); found {
+		reason = reasonInfo.Label.En
+	}
+
+	lines := []string{
+		"Patient ID: " + record.PatientId,
+		"Employer: " + record.Employer,
+		"Reason: " + reason,
+		fmt.Sprintf("Valid: %s - %s", record.ValidFrom, record.ValidUntil),
+		"State: " + record.LifecycleStatus(),
+	}
+	if record.Diagnosis != "" {
+		lines = append(lines, "Diagnosis: "+record.Diagnosis)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Utility function which renders events as iCalendar (RFC 5545) object
+func renderCalendar(name string, events []calendarEvent, now time.Time) []byte {
+	builder := &strings.Builder{}
+	writeLine := func(line string) {
+		builder.WriteString(foldCalendarLine(line))
+		builder.WriteString("\r\n")
+	}
+
+	stamp := now.UTC().Format("20060102T150405Z")
+
+	writeLine("BEGIN:VCALENDAR")
+	writeLine("VERSION:2.0")
+	writeLine("PRODID:-//PN registry//PN registry API//EN")
+	writeLine("CALSCALE:GREGORIAN")
+	writeLine("METHOD:PUBLISH")
+	writeLine("X-WR-CALNAME:" + escapeCalendarText(name))
+	for _, event := range events {
+		writeLine("BEGIN:VEVENT")
+		writeLine("UID:" + event.Uid)
+		writeLine("DTSTAMP:" + stamp)
+		writeLine("DTSTART;VALUE=DATE:" + time.Time(event.Start).Format("20060102"))
+		// end of all-day event is exclusive
+		writeLine("DTEND;VALUE=DATE:" + time.Time(event.End.AddDays(1)).Format("20060102"))
+		writeLine("SUMMARY:" + escapeCalendarText(event.Summary))
+		writeLine("DESCRIPTION:" + escapeCalendarText(event.Description))
+		writeLine("TRANSP:TRANSPARENT")
+		if event.Cancelled {
+			writeLine("STATUS:CANCELLED")
+		} else {
+			writeLine("STATUS:CONFIRMED")
+		}
+		writeLine("END:VEVENT")
+	}
+	writeLine("END:VCALENDAR")
+
+	return []byte(builder.String())
+}
+
+// escapes special characters of TEXT values
+func escapeCalendarText(text string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	).Replace(text)
+}
+
+// folds content line longer than 75 octets, continuation lines start with space,
+// multi-byte characters are never split
+func foldCalendarLine(line string) string {
+	const maxOctets = 75
+
+	builder := &strings.Builder{}
+	octets := 0
+	for _, r := range line {
+		size := utf8.RuneLen(r)
+		if octets+size > maxOctets {
+			builder.WriteString("\r\n ")
+			// leading space counts into length of the continuation line
+			octets = 1
+		}
+		builder.WriteRune(r)
+		octets += size
+	}
+	return builder.String()
+}
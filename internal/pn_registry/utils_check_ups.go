@@ -0,0 +1,173 @@
+package pn_registry
+
+import (
+	"slices"
+	"time"
+
+	"github.com/bmathus/pnregistry-webapi/internal/db_service"
+)
+
+// Utility function which converts legacy single check-up of the record into list of check-ups
+// and derives legacy fields from the list - 'checkUp' is the earliest pending check-up (or the last one
+// when all are done) and 'checkUpDone' is set when all check-ups are done. When the list is provided,
+// it is authoritative and legacy fields are ignored.
+func (r *Record) normalizeCheckUps() {
+	if r.CheckUps == nil && r.CheckUp != nil {
+		r.CheckUps = []CheckUp{{Date: *r.CheckUp, Done: r.CheckUpDone}}
+	}
+
+	slices.SortStableFunc(r.CheckUps, func(a, b CheckUp) int {
+		return time.Time(a.Date).Compare(time.Time(b.Date))
+	})
+
+	r.CheckUp = nil
+	r.CheckUpDone = len(r.CheckUps) != 0
+	for _, checkUp := range r.CheckUps {
+		if !checkUp.Done {
+			date := checkUp.Date
+			r.CheckUp = &date
+			r.CheckUpDone = false
+			break
+		}
+	}
+	if r.CheckUp == nil && len(r.CheckUps) != 0 {
+		date := r.CheckUps[len(r.CheckUps)-1].Date
+		r.CheckUp = &date
+	}
+}
+
+// Utility function which applies changes of legacy fields 'checkUp' and 'checkUpDone' of updated record
+// to check-ups of its stored version and normalizes the record. Changed legacy fields update the check-up
+// they were derived from - pending check-up is moved to the new date, check-up on the same date is marked
+// as (not) done and check-up on other date is added. Returns description of the conflict or empty string
+// when the change can not be applied.
+func (r *Record) mergeLegacyCheckUp(stored *Record) string {
+	if stored == nil {
+		r.normalizeCheckUps()
+		return ""
+	}
+
+	legacyChanged := r.CheckUpDone != stored.CheckUpDone || !sameCheckUpDate(r.CheckUp, stored.CheckUp)
+	listChanged := r.CheckUps != nil && !slices.EqualFunc(r.CheckUps, stored.CheckUps, sameCheckUp)
+
+	switch {
+	case !legacyChanged && !listChanged:
+		// record sent without list of check-ups keeps the stored ones
+		r.CheckUps = slices.Clone(stored.CheckUps)
+	case legacyChanged && listChanged:
+		// both representations were changed, they have to describe the same check-ups
+		checkUp, checkUpDone := r.CheckUp, r.CheckUpDone
+		r.normalizeCheckUps()
+		if r.CheckUpDone != checkUpDone || !sameCheckUpDate(r.CheckUp, checkUp) {
+			return "Fields 'checkUp' and 'checkUpDone' do not correspond to 'checkUps', change only 'checkUps'"
+		}
+		return ""
+	case legacyChanged:
+		if r.CheckUp == nil && r.CheckUpDone {
+			return "Field 'checkUpDone' can be set only together with 'checkUp' date"
+		}
+		r.CheckUps = applyLegacyCheckUp(stored, r.CheckUp, r.CheckUpDone)
+	}
+
+	r.normalizeCheckUps()
+	return ""
+}
+
+// Utility function which returns check-ups of the stored record with the legacy check-up applied
+func applyLegacyCheckUp(stored *Record, date *DateType, done bool) []CheckUp {
+	checkUps := slices.Clone(stored.CheckUps)
+	target := -1
+	if stored.CheckUp != nil {
+		target = stored.findCheckUp(*stored.CheckUp)
+	}
+
+	switch {
+	case date == nil:
+		if target != -1 {
+			checkUps = slices.Delete(checkUps, target, target+1)
+		}
+	case target != -1 && (!checkUps[target].Done || checkUps[target].Date == *date):
+		checkUps[target].Date = *date
+		checkUps[target].Done = done
+	default:
+		if index := stored.findCheckUp(*date); index != -1 {
+			checkUps[index].Done = done
+		} else {
+			checkUps = append(checkUps, CheckUp{Date: *date, Done: done})
+		}
+	}
+	return checkUps
+}
+
+func sameCheckUpDate(a *DateType, b *DateType) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+func sameCheckUp(a CheckUp, b CheckUp) bool {
+	return a.Date == b.Date && a.Done == b.Done && a.Notes == b.Notes
+}
+
+// sets time of completion of done check-ups which do not have it yet
+func (r *Record) stampCheckUps(now time.Time) {
+	for i := range r.CheckUps {
+		if !r.CheckUps[i].Done {
+			r.CheckUps[i].DoneAt = nil
+		} else if r.CheckUps[i].DoneAt == nil {
+			r.CheckUps[i].DoneAt = &now
+		}
+	}
+}
+
+// Utility function which validates check-ups of the record,
+// returns description of the violation or empty string
+func checkUpsConflict(record Record) string {
+	for i, checkUp := range record.CheckUps {
+		if record.ValidFrom.After(checkUp.Date) {
+			return "'Check Up' date can only be on or after 'Valid from' date"
+		}
+		if i != 0 && record.CheckUps[i-1].Date == checkUp.Date {
+			return "Record can have only one check up on the same date"
+		}
+	}
+	return ""
+}
+
+// returns index of the record's check-up planned on the date or -1
+func (r Record) findCheckUp(date DateType) int {
+	return slices.IndexFunc(r.CheckUps, func(checkUp CheckUp) bool {
+		return checkUp.Date == date
+	})
+}
+
+// condition matching records of ongoing sick leaves with check-up, which is not done yet and is planned
+// within <from, to> period, nil from means any date before 'to'
+func pendingCheckUpCondition(from *DateType, to DateType) db_service.Filter {
+	checkUpFilters := []db_service.Filter{db_service.Eq("done", false), db_service.Lte("date", to)}
+	legacyFilters := []db_service.Filter{
+		db_service.Exists("checkUps", false),
+		db_service.Eq("checkUpDone", false),
+		db_service.Lte("checkUp", to),
+	}
+	if from != nil {
+		checkUpFilters = append(checkUpFilters, db_service.Gte("date", *from))
+		legacyFilters = append(legacyFilters, db_service.Gte("checkUp", *from))
+	}
+
+	return db_service.And(
+		db_service.Nin("status", StatusClosed, StatusCancelled),
+		db_service.Or(
+			db_service.ElemMatch("checkUps", db_service.And(checkUpFilters...)),
+			// records stored before multiple check-ups were supported
+			db_service.And(legacyFilters...),
+		),
+	)
+}
+
+// condition matching records with check-up (done or not) planned within <from, to> period
+func checkUpInPeriodCondition(from DateType, to DateType) db_service.Filter {
+	return db_service.Or(
+		db_service.ElemMatch("checkUps", db_service.Between("date", from, to)),
+		// records stored before multiple check-ups were supported
+		db_service.And(db_service.Exists("checkUps", false), db_service.Between("checkUp", from, to)),
+	)
+}
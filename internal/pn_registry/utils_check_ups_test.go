@@ -0,0 +1,92 @@
+package pn_registry
+
+import (
+	"net/http"
+	"testing"
+)
+
+var mergePatch = map[string]string{"Content-Type": "application/merge-patch+json"}
+
+// creates record with pending check-ups on given dates
+func createCheckUpTestRecord(t *testing.T, dates ...string) *testServices {
+	t.Helper()
+	services := newTestServices()
+	engine := newTestEngine(services)
+
+	record := newTestRecord("r1", "123", "2024-01-01", "2024-01-31")
+	checkUps := []map[string]interface{}{}
+	for _, date := range dates {
+		checkUps = append(checkUps, map[string]interface{}{"date": date})
+	}
+	record["checkUps"] = checkUps
+	createTestRecord(t, engine, record)
+	return services
+}
+
+func TestPatchLegacyCheckUpDone(t *testing.T) {
+	engine := newTestEngine(createCheckUpTestRecord(t, "2024-01-10"))
+
+	recorder := doRequest(engine, http.MethodPatch, "/api/records/r1/", `{"checkUpDone":true}`, mergePatch)
+	expectStatus(t, recorder, http.StatusOK)
+	record := decodeResponse[Record](t, recorder)
+	if !record.CheckUpDone || len(record.CheckUps) != 1 || !record.CheckUps[0].Done || record.CheckUps[0].DoneAt == nil {
+		t.Errorf("Expected check-up to be done, got %+v", record)
+	}
+
+	stored := decodeResponse[Record](t, doRequest(engine, http.MethodGet, "/api/records/r1/", nil, nil))
+	if !stored.CheckUpDone {
+		t.Errorf("Expected check-up done to be stored, got %+v", stored)
+	}
+}
+
+func TestPatchLegacyCheckUpDate(t *testing.T) {
+	engine := newTestEngine(createCheckUpTestRecord(t, "2024-01-10", "2024-01-20"))
+
+	// pending check-up is moved
+	recorder := doRequest(engine, http.MethodPatch, "/api/records/r1/", `{"checkUp":"2024-01-12"}`, mergePatch)
+	expectStatus(t, recorder, http.StatusOK)
+	record := decodeResponse[Record](t, recorder)
+	if len(record.CheckUps) != 2 || record.CheckUps[0].Date.String() != "2024-01-12" || record.CheckUps[1].Date.String() != "2024-01-20" {
+		t.Errorf("Expected earliest check-up to be moved, got %+v", record.CheckUps)
+	}
+
+	// completion of the earliest check-up makes the next one current
+	recorder = doRequest(engine, http.MethodPatch, "/api/records/r1/", `{"checkUpDone":true}`, mergePatch)
+	expectStatus(t, recorder, http.StatusOK)
+	record = decodeResponse[Record](t, recorder)
+	if !record.CheckUps[0].Done || record.CheckUps[1].Done || record.CheckUp.String() != "2024-01-20" || record.CheckUpDone {
+		t.Errorf("Expected only earliest check-up to be done, got %+v", record)
+	}
+
+	// removed legacy check-up removes the current one
+	recorder = doRequest(engine, http.MethodPatch, "/api/records/r1/", `{"checkUp":null}`, mergePatch)
+	expectStatus(t, recorder, http.StatusOK)
+	record = decodeResponse[Record](t, recorder)
+	if len(record.CheckUps) != 1 || record.CheckUps[0].Date.String() != "2024-01-12" {
+		t.Errorf("Expected pending check-up to be removed, got %+v", record.CheckUps)
+	}
+}
+
+func TestUpdateLegacyRecordKeepsCheckUps(t *testing.T) {
+	engine := newTestEngine(createCheckUpTestRecord(t, "2024-01-10", "2024-01-20"))
+
+	// client not aware of the list sends only legacy fields it has read
+	updated := newTestRecord("r1", "123", "2024-01-01", "2024-01-31")
+	updated["checkUp"] = "2024-01-10"
+	updated["employer"] = "Stavby a.s."
+	recorder := doRequest(engine, http.MethodPut, "/api/records/r1/", updated, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	if record := decodeResponse[Record](t, recorder); len(record.CheckUps) != 2 {
+		t.Errorf("Expected stored check-ups to be kept, got %+v", record.CheckUps)
+	}
+}
+
+func TestPatchInconsistentCheckUps(t *testing.T) {
+	engine := newTestEngine(createCheckUpTestRecord(t, "2024-01-10"))
+
+	patch := `{"checkUpDone":true,"checkUps":[{"date":"2024-01-10"},{"date":"2024-01-15"}]}`
+	expectStatus(t, doRequest(engine, http.MethodPatch, "/api/records/r1/", patch, mergePatch), http.StatusUnprocessableEntity)
+
+	patch = `{"checkUp":null,"checkUpDone":true}`
+	expectStatus(t, doRequest(engine, http.MethodPatch, "/api/records/r1/", patch, mergePatch), http.StatusUnprocessableEntity)
+}
@@ -0,0 +1,107 @@
+package pn_registry
+
+import (
+	_ "embed"
+	"errors"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// ICD-10 catalog of diagnoses bundled with the service, one "code;name" per line with header
+//
+//go:embed data/icd10.csv
+var diagnosisCatalogData string
+
+// diagnoses of the catalog in order of the catalog file and indexed by code
+var diagnosisCatalog, diagnosisByCode = parseDiagnosisCatalog(diagnosisCatalogData)
+
+// format of ICD-10 codes - chapter letter, two digits of category and optional subcategory
+var diagnosisCodePattern = regexp.MustCompile(`^[A-Z][0-9]{2}(\.[0-9A-Z]{1,4})?$`)
+
+// Replaces bundled catalog of diagnoses by catalog loaded from file in the same "code;name" format,
+// so that deployments can use full ICD-10 classification
+func LoadDiagnosisCatalogFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	diagnoses, byCode := parseDiagnosisCatalog(string(data))
+	if len(diagnoses) == 0 {
+		return errors.New("catalog of diagnoses is empty")
+	}
+	diagnosisCatalog, diagnosisByCode = diagnoses, byCode
+	return nil
+}
+
+func parseDiagnosisCatalog(data string) ([]Diagnosis, map[string]Diagnosis) {
+	diagnoses := []Diagnosis{}
+	byCode := map[string]Diagnosis{}
+
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if i == 0 || line == "" {
+			continue
+		}
+
+		code, name, found := strings.Cut(line, ";")
+		if !found {
+			log.Printf("Invalid line %v of ICD-10 catalog: %v", i+1, line)
+			continue
+		}
+
+		diagnosis := Diagnosis{Code: strings.TrimSpace(code), Name: strings.TrimSpace(name)}
+		diagnoses = append(diagnoses, diagnosis)
+		byCode[diagnosis.Code] = diagnosis
+	}
+	return diagnoses, byCode
+}
+
+// Utility function which finds diagnoses which code starts with the query or name contains it,
+// comparison is case insensitive and at most limit diagnoses are returned
+func searchDiagnoses(query string, limit int) []Diagnosis {
+	query = strings.ToLower(strings.TrimSpace(query))
+
+	result := []Diagnosis{}
+	for _, diagnosis := range diagnosisCatalog {
+		if len(result) == limit {
+			break
+		}
+		if strings.HasPrefix(strings.ToLower(diagnosis.Code), query) || strings.Contains(strings.ToLower(diagnosis.Name), query) {
+			result = append(result, diagnosis)
+		}
+	}
+	return result
+}
+
+// injuries and poisonings (chapter XIX of ICD-10, codes S00-T98) can be only result of accident
+func isInjuryDiagnosis(code string) bool {
+	return strings.HasPrefix(code, "S") || strings.HasPrefix(code, "T")
+}
+
+// Utility function which checks consistency of the diagnosis with reason of the record,
+// returns description of the inconsistency or empty string
+func diagnosisReasonConflict(diagnosis string, reason string) string {
+	if !isInjuryDiagnosis(diagnosis) {
+		return ""
+	}
+	if reasonInfo, found := reasonCatalog.Find(reason); !found || !reasonInfo.Injury {
+		return "Injury diagnosis (ICD-10 codes S00-T98) requires reason of injury, e.g. 'uraz' or 'pracovny uraz'"
+	}
+	return ""
+}
+
+// custom validator for diagnosis field in catalog validation mode - only codes from ICD-10 catalog
+func DiagnosisValidator(fl validator.FieldLevel) bool {
+	_, valid := diagnosisByCode[fl.Field().String()]
+	return valid
+}
+
+// custom validator for diagnosis field - any code in ICD-10 format, catalog is used only for search of diagnoses
+func DiagnosisFormatValidator(fl validator.FieldLevel) bool {
+	return diagnosisCodePattern.MatchString(fl.Field().String())
+}
@@ -0,0 +1,80 @@
+package pn_registry
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+)
+
+type diagnosisHolder struct {
+	Diagnosis string `validate:"icd10-code"`
+}
+
+func TestDiagnosisValidators(t *testing.T) {
+	format, catalog := validator.New(), validator.New()
+	format.RegisterValidation("icd10-code", DiagnosisFormatValidator)
+	catalog.RegisterValidation("icd10-code", DiagnosisValidator)
+
+	tests := []struct {
+		code    string
+		format  bool
+		catalog bool
+	}{
+		{"J45.9", true, true},
+		{"A00", true, false},
+		{"Z00.00", true, false},
+		{"j45.9", false, false},
+		{"J4", false, false},
+		{"J45.", false, false},
+		{"X", false, false},
+	}
+
+	for _, test := range tests {
+		if valid := format.Struct(diagnosisHolder{test.code}) == nil; valid != test.format {
+			t.Errorf("Expected format validity %v of code %q, got %v", test.format, test.code, valid)
+		}
+		if valid := catalog.Struct(diagnosisHolder{test.code}) == nil; valid != test.catalog {
+			t.Errorf("Expected catalog validity %v of code %q, got %v", test.catalog, test.code, valid)
+		}
+	}
+}
+
+func TestLoadDiagnosisCatalogFile(t *testing.T) {
+	bundled, bundledByCode := diagnosisCatalog, diagnosisByCode
+	defer func() {
+		diagnosisCatalog, diagnosisByCode = bundled, bundledByCode
+	}()
+
+	path := filepath.Join(t.TempDir(), "icd10.csv")
+	if err := os.WriteFile(path, []byte("code;name\nA00;Cholera\nA00.0;Cholera due to Vibrio cholerae 01, biovar cholerae\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadDiagnosisCatalogFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := diagnosisByCode["A00.0"]; !found || len(diagnosisCatalog) != 2 {
+		t.Errorf("Expected catalog loaded from file, got %+v", diagnosisCatalog)
+	}
+
+	if err := os.WriteFile(path, []byte("code;name\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadDiagnosisCatalogFile(path); err == nil {
+		t.Errorf("Expected empty catalog to be rejected")
+	}
+}
+
+func TestCreateRecordDiagnosisOutsideCatalog(t *testing.T) {
+	engine := newTestEngine(newTestServices())
+
+	record := newTestRecord("r1", "123", "2024-01-01", "2024-01-10")
+	record["diagnosis"] = "A00"
+	createTestRecord(t, engine, record)
+
+	invalid := newTestRecord("r2", "456", "2024-01-01", "2024-01-10")
+	invalid["diagnosis"] = "cholera"
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/records/", invalid, nil), http.StatusBadRequest)
+}
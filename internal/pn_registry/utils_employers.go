@@ -0,0 +1,64 @@
+package pn_registry
+
+import (
+	"github.com/bmathus/pnregistry-webapi/internal/db_service"
+	"github.com/gin-gonic/gin"
+)
+
+// Utility function which loads registered employer, returns nil when the employer is not registered
+// or employer service is not available in the context
+func findEmployer(ctx *gin.Context, employerId string) (*Employer, error) {
+	value, exists := ctx.Get("employer_service")
+	if !exists {
+		return nil, nil
+	}
+
+	employerDb, ok := value.(db_service.DbService[Employer])
+	if !ok {
+		return nil, nil
+	}
+
+	employer, err := employerDb.FindDocument(ctx, employerId)
+	if err == db_service.ErrNotFound {
+		return nil, nil
+	}
+	return employer, err
+}
+
+// Utility function which propagates name of the employer to all records referencing the employer
+// which are not in final state, closed and cancelled records keep the name they were issued with,
+// records which already have the name are left untouched so the function can be safely retried
+func renameEmployerRecords(ctx *gin.Context, db db_service.DbService[Record], employerId string, name string) error {
+	records, _, err := db.QueryDocuments(ctx, db_service.Query{
+		Filter: db_service.And(
+			db_service.Eq("employerId", employerId),
+			notFinal(),
+			notDeleted(),
+			db_service.Ne("employer", name),
+		),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		renamedRecord := record
+		renamedRecord.Employer = name
+		renamedRecord.Version = record.Version + 1
+
+		if err := db.UpdateDocumentIf(ctx, record.Id, versionCondition(record.Version), &renamedRecord); err != nil {
+			return err
+		}
+		recordAudit(ctx, AuditActionUpdate, record.Id, &record, &renamedRecord)
+	}
+	return nil
+}
+
+// condition matching records valid on given date, cancelled records were never valid
+func activeOn(date DateType) db_service.Filter {
+	return db_service.And(
+		db_service.Lte("validFrom", date),
+		db_service.Gte("validUntil", date),
+		db_service.Ne("status", StatusCancelled),
+	)
+}
@@ -0,0 +1,40 @@
+package pn_registry
+
+import (
+	"github.com/bmathus/pnregistry-webapi/internal/db_service"
+)
+
+// personal data of patients which are encrypted in stored records
+var encryptedRecordFields = []string{"fullName", "patientId"}
+
+// personal data which are encrypted in stored patients, the ID is birth number of the patient
+var encryptedPatientFields = []string{"id", "fullName", "birthDate", "contact"}
+
+// record snapshots and changed values which are encrypted in stored audit entries
+var encryptedAuditFields = []string{"before", "after", "changes.before", "changes.after"}
+
+// Encryption of personal data in stored records, their audit trail and patients
+type RecordEncryption struct {
+	Records  *db_service.FieldEncryption
+	Audit    *db_service.FieldEncryption
+	Patients *db_service.FieldEncryption
+}
+
+// Creates encryption of personal data. Records and patients can be found by encrypted fields thanks to their
+// blind index, but they can not be sorted by them anymore, such queries fail with db_service.ErrEncryptedField.
+// Snapshots and changed values in audit entries are encrypted whole, because audit is never queried by them.
+func NewRecordEncryption(keys db_service.EncryptionKeys) (RecordEncryption, error) {
+	var encryption RecordEncryption
+	var err error
+	if encryption.Records, err = db_service.NewFieldEncryption(keys, encryptedRecordFields, encryptedRecordFields); err != nil {
+		return encryption, err
+	}
+	if encryption.Audit, err = db_service.NewFieldEncryption(keys, encryptedAuditFields, nil); err != nil {
+		return encryption, err
+	}
+	// patients are found only by their ID
+	if encryption.Patients, err = db_service.NewFieldEncryption(keys, encryptedPatientFields, []string{"id"}); err != nil {
+		return encryption, err
+	}
+	return encryption, nil
+}
@@ -0,0 +1,146 @@
+package pn_registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bmathus/pnregistry-webapi/internal/db_service"
+	"github.com/gin-gonic/gin"
+)
+
+// in-memory services which encrypt personal data in the same way as services of main with configured keys
+func newEncryptedTestServices(t *testing.T) *testServices {
+	t.Helper()
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+	encryption, err := NewRecordEncryption(db_service.EncryptionKeys{Keys: map[string]string{"k1": key}, IndexKey: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	services := newTestServices()
+	services.records = db_service.NewMemoryService[Record](db_service.MemoryServiceConfig{Encryption: encryption.Records})
+	services.audit = db_service.NewMemoryService[AuditEntry](db_service.MemoryServiceConfig{Encryption: encryption.Audit})
+	services.patients = db_service.NewMemoryService[Patient](db_service.MemoryServiceConfig{Encryption: encryption.Patients})
+	services.encryption = encryption.Records
+	return services
+}
+
+func TestEncryptedPatients(t *testing.T) {
+	services := newEncryptedTestServices(t)
+	engine := newTestEngine(services)
+
+	patient := map[string]interface{}{
+		"id":        "8001011234",
+		"fullName":  "Jozef Mrkvicka",
+		"birthDate": "1980-01-01",
+		"contact":   map[string]interface{}{"phone": "+421900123456"},
+	}
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/patients/", patient, nil), http.StatusCreated)
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/patients/", patient, nil), http.StatusConflict)
+	other := map[string]interface{}{"id": "7001011234", "fullName": "Anna Mrkvickova"}
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/patients/", other, nil), http.StatusCreated)
+
+	// personal data of the patient are stored encrypted, so they can not be queried by their value
+	for _, filter := range []db_service.Filter{
+		db_service.Gt("id", "7"),
+		db_service.Eq("fullName", "Jozef Mrkvicka"),
+		db_service.Lt("birthDate", "1990-01-01"),
+		db_service.Eq("contact.phone", "+421900123456"),
+	} {
+		if _, _, err := services.patients.QueryDocuments(context.Background(), db_service.Query{Filter: filter}); !errors.Is(err, db_service.ErrEncryptedField) {
+			t.Errorf("Expected query of encrypted field to be rejected, got %v", err)
+		}
+	}
+
+	recorder := doRequest(engine, http.MethodGet, "/api/patients/8001011234/", nil, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	if stored := decodeResponse[Patient](t, recorder); stored.FullName != "Jozef Mrkvicka" || stored.Contact == nil || stored.Contact.Phone != "+421900123456" {
+		t.Errorf("Expected decrypted patient, got %+v", stored)
+	}
+
+	recorder = doRequest(engine, http.MethodGet, "/api/patients/", nil, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	if patients := decodeResponse[[]Patient](t, recorder); len(patients) != 2 || patients[0].Id != "7001011234" || patients[1].Id != "8001011234" {
+		t.Errorf("Expected patients sorted by full name, got %+v", patients)
+	}
+
+	patient["fullName"] = "Jozef Mrkva"
+	expectStatus(t, doRequest(engine, http.MethodPut, "/api/patients/8001011234/", patient, nil), http.StatusOK)
+	expectStatus(t, doRequest(engine, http.MethodDelete, "/api/patients/8001011234/", nil, nil), http.StatusNoContent)
+	expectStatus(t, doRequest(engine, http.MethodGet, "/api/patients/8001011234/", nil, nil), http.StatusNotFound)
+}
+
+func TestEncryptedRecordsSort(t *testing.T) {
+	encrypted := newTestEngine(newEncryptedTestServices(t))
+	plain := newTestEngine(newTestServices())
+	for _, engine := range []*gin.Engine{encrypted, plain} {
+		createTestRecord(t, engine, newTestRecord("r1", "123", "2024-01-01", "2024-01-10"))
+	}
+
+	// sort fields are allowed by encryption of the service which runs the query
+	expectStatus(t, doRequest(encrypted, http.MethodGet, "/api/records/?sort=fullName", nil, nil), http.StatusBadRequest)
+	expectStatus(t, doRequest(encrypted, http.MethodGet, "/api/records/?sort=validFrom", nil, nil), http.StatusOK)
+	expectStatus(t, doRequest(plain, http.MethodGet, "/api/records/?sort=fullName", nil, nil), http.StatusOK)
+
+	// only fields which can be sorted are advertised
+	for _, test := range []struct {
+		engine     *gin.Engine
+		advertised bool
+	}{{encrypted, false}, {plain, true}} {
+		recorder := doRequest(test.engine, http.MethodGet, "/api/records/?sort=diagnosis", nil, nil)
+		expectStatus(t, recorder, http.StatusBadRequest)
+		message := decodeResponse[map[string]string](t, recorder)["error"]
+		if !strings.Contains(message, "validFrom") || strings.Contains(message, "fullName") != test.advertised {
+			t.Errorf("Expected fullName advertised %v, got %q", test.advertised, message)
+		}
+	}
+}
+
+func TestEncryptedAudit(t *testing.T) {
+	services := newEncryptedTestServices(t)
+	engine := newTestEngine(services)
+
+	record := newTestRecord("r1", "123", "2024-01-01", "2024-01-10")
+	createTestRecord(t, engine, record)
+	record["fullName"] = "Jozef Mrkva"
+	record["diagnosis"] = "J06.9"
+	expectStatus(t, doRequest(engine, http.MethodPut, "/api/records/r1/", record, map[string]string{"If-Match": `"1"`}), http.StatusOK)
+	expectStatus(t, doRequest(engine, http.MethodDelete, "/api/records/r1/", nil, nil), http.StatusNoContent)
+	purgeDeletedRecords(context.Background(), services.records, services.audit, time.Now().Add(time.Hour))
+
+	// snapshots and changed values are stored encrypted whole
+	for _, filter := range []db_service.Filter{
+		db_service.Eq("before.diagnosis", "J06.9"),
+		db_service.Eq("after.employer", "Stavby s.r.o."),
+		db_service.ElemMatch("changes", db_service.Eq("after", "Jozef Mrkva")),
+	} {
+		if _, _, err := services.audit.QueryDocuments(context.Background(), db_service.Query{Filter: filter}); !errors.Is(err, db_service.ErrEncryptedField) {
+			t.Errorf("Expected query of encrypted snapshot to be rejected, got %v", err)
+		}
+	}
+
+	entries, _, err := services.audit.QueryDocuments(context.Background(), db_service.Query{Filter: db_service.Eq("recordId", "r1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions := map[string]AuditEntry{}
+	for _, entry := range entries {
+		actions[entry.Action] = entry
+	}
+	if purge := actions[AuditActionPurge]; purge.Before == nil || purge.Before.Diagnosis != "J06.9" {
+		t.Errorf("Expected decrypted snapshot of purged record, got %+v", purge.Before)
+	}
+	changes := map[string]string{}
+	for _, change := range actions[AuditActionUpdate].Changes {
+		changes[change.Field] = string(change.After)
+	}
+	if changes["fullName"] != `"Jozef Mrkva"` {
+		t.Errorf("Expected decrypted change of full name, got %v", changes)
+	}
+}
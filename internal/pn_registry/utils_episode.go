@@ -0,0 +1,39 @@
+package pn_registry
+
+import (
+	"github.com/bmathus/pnregistry-webapi/internal/db_service"
+)
+
+// identifier of episode (chain of continued records) of the record, it is ID of the first record of the chain
+func (r Record) EpisodeKey() string {
+	if r.EpisodeId != "" {
+		return r.EpisodeId
+	}
+	return r.Id
+}
+
+// condition matching all records of the episode
+func episodeCondition(episodeId string) db_service.Filter {
+	return db_service.Or(db_service.Eq("id", episodeId), db_service.Eq("episodeId", episodeId))
+}
+
+// Utility function which summarizes records of the episode ordered by validity,
+// duration of the episode is cumulative duration of all its records
+func newEpisode(episodeId string, records []Record) Episode {
+	episode := Episode{
+		Id:      episodeId,
+		Records: records,
+	}
+
+	for i, record := range records {
+		if i == 0 || episode.ValidFrom.After(record.ValidFrom) {
+			episode.ValidFrom = record.ValidFrom
+		}
+		if i == 0 || record.ValidUntil.After(episode.ValidUntil) {
+			episode.ValidUntil = record.ValidUntil
+		}
+		episode.PatientId = record.PatientId
+		episode.DurationDays += record.ValidFrom.DaysTo(record.ValidUntil) + 1
+	}
+	return episode
+}
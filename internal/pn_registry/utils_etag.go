@@ -0,0 +1,36 @@
+package pn_registry
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bmathus/pnregistry-webapi/internal/db_service"
+)
+
+// entity tag of the record, changes with every update of the record
+func (r Record) ETag() string {
+	return fmt.Sprintf("\"%d\"", r.Version)
+}
+
+// condition matching stored record of given version, records stored before versioning have no version field
+func versionCondition(version int64) db_service.Filter {
+	if version == 0 {
+		return db_service.Or(db_service.Eq("version", int64(0)), db_service.Exists("version", false))
+	}
+	return db_service.Eq("version", version)
+}
+
+// checks if any of entity tags in If-Match or If-None-Match header matches the etag,
+// If-Match uses strong comparison and If-None-Match weak comparison (RFC 9110)
+func etagMatches(header string, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package pn_registry
+
+import (
+	"strconv"
+	"strings"
+)
+
+// supported formats of records export and their content types
+var exportContentTypes = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"ndjson": "application/x-ndjson",
+}
+
+// number of exported records after which the buffered output is sent to client
+const exportFlushInterval = 100
+
+// columns of CSV export in stable order, new columns are only appended
+var recordCsvColumns = []string{
+	"id",
+	"patientId",
+	"fullName",
+	"employerId",
+	"employer",
+	"reason",
+	"diagnosis",
+	"issued",
+	"validFrom",
+	"validUntil",
+	"checkUp",
+	"checkUpDone",
+	"checkUps",
+	"status",
+	"predecessorId",
+	"episodeId",
+	"version",
+}
+
+// Utility function which converts record into CSV row with columns of recordCsvColumns,
+// dates are formatted as YYYY-MM-DD and dates of check-ups are separated by ';'
+func recordCsvRow(record Record) []string {
+	checkUp := ""
+	if record.CheckUp != nil {
+		checkUp = record.CheckUp.String()
+	}
+
+	checkUps := make([]string, 0, len(record.CheckUps))
+	for _, scheduled := range record.CheckUps {
+		checkUps = append(checkUps, scheduled.Date.String())
+	}
+
+	return []string{
+		record.Id,
+		record.PatientId,
+		record.FullName,
+		record.EmployerId,
+		record.Employer,
+		record.Reason,
+		record.Diagnosis,
+		record.Issued.String(),
+		record.ValidFrom.String(),
+		record.ValidUntil.String(),
+		checkUp,
+		strconv.FormatBool(record.CheckUpDone),
+		strings.Join(checkUps, ";"),
+		record.LifecycleStatus(),
+		record.PredecessorId,
+		record.EpisodeId,
+		strconv.FormatInt(record.Version, 10),
+	}
+}
@@ -0,0 +1,233 @@
+package pn_registry
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Enum constant of possible values of query parameter "mode" of records import
+const (
+	ImportModeAllOrNothing = "all-or-nothing"
+	ImportModeBestEffort   = "best-effort"
+)
+
+// Enum constant of possible values of field "status" of import row result
+const (
+	ImportRowAccepted    = "accepted"
+	ImportRowRejected    = "rejected"
+	ImportRowConflicting = "conflicting"
+)
+
+// maximal number of rows of single import
+const importMaxRows = 10000
+
+// maximal length of single NDJSON line
+const importMaxLineBytes = 1024 * 1024
+
+// maximal number of rows of all-or-nothing import, which is written in single transaction
+// and has to fit into limits of MongoDB transactions (60 seconds and 16MB of oplog entries)
+const importMaxAtomicRows = 1000
+
+var errImportTooLarge = fmt.Errorf("Import can contain at most %v rows", importMaxRows)
+
+var errAtomicImportTooLarge = fmt.Errorf("All-or-nothing import can contain at most %v rows, use best-effort mode or split the file", importMaxAtomicRows)
+
+// columns of CSV export which are managed by server and ignored by import
+var importIgnoredColumns = []string{"status", "predecessorId", "episodeId", "version"}
+
+// Row of imported file, Err is set when the row cannot be decoded or its fields are invalid
+type importRow struct {
+	Row    int
+	Record Record
+	Err    error
+}
+
+// Utility function which resolves format of imported file from query parameter "format"
+// or from Content-Type of the request, returns empty string when format is not supported
+func importFormat(format string, contentType string) string {
+	if format != "" {
+		if _, supported := exportContentTypes[format]; supported {
+			return format
+		}
+		return ""
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv":
+		return "csv"
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		return "ndjson"
+	}
+	return ""
+}
+
+// Utility function which reads rows of imported file in CSV (columns of records export) or NDJSON format
+// and validates fields of every record with the same validators as request body of CreateRecord.
+// Returns error only when the file itself cannot be read, invalid rows are returned with their error.
+func parseImportRows(format string, body io.Reader) ([]importRow, error) {
+	var rows []importRow
+	var err error
+	if format == "csv" {
+		rows, err = parseImportCsv(body)
+	} else {
+		rows, err = parseImportNdjson(body)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range rows {
+		if rows[i].Err != nil {
+			continue
+		}
+		if rows[i].Record.Id == "" {
+			rows[i].Record.Id = "@new"
+		}
+		rows[i].Err = binding.Validator.ValidateStruct(&rows[i].Record)
+	}
+	return rows, nil
+}
+
+func parseImportNdjson(body io.Reader) ([]importRow, error) {
+	rows := []importRow{}
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), importMaxLineBytes)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if len(rows) == importMaxRows {
+			return nil, errImportTooLarge
+		}
+
+		row := importRow{Row: len(rows) + 1}
+		row.Err = json.Unmarshal([]byte(line), &row.Record)
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+func parseImportCsv(body io.Reader) ([]importRow, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return []importRow{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i, column := range header {
+		column = strings.TrimSpace(strings.TrimPrefix(column, "\ufeff"))
+		if !slices.Contains(recordCsvColumns, column) {
+			return nil, fmt.Errorf("Unknown column '%v'", column)
+		}
+		if slices.Contains(header[:i], column) {
+			return nil, fmt.Errorf("Duplicate column '%v'", column)
+		}
+		header[i] = column
+	}
+
+	rows := []importRow{}
+	for {
+		values, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) == importMaxRows {
+			return nil, errImportTooLarge
+		}
+
+		row := importRow{Row: len(rows) + 1}
+		if len(values) != len(header) {
+			row.Err = fmt.Errorf("Row has %v columns, header has %v columns", len(values), len(header))
+		} else {
+			row.Record, row.Err = csvImportRecord(header, values)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// converts CSV row into record through its JSON representation, so that the fields are decoded
+// the same way as request body. Check-ups before the date of pending check-up (column "checkUp")
+// are done, as in records export.
+func csvImportRecord(header []string, values []string) (Record, error) {
+	fields := map[string]any{}
+	for i, column := range header {
+		value := strings.TrimSpace(values[i])
+		if value != "" && !slices.Contains(importIgnoredColumns, column) {
+			fields[column] = value
+		}
+	}
+
+	allDone := false
+	if value, exists := fields["checkUpDone"]; exists {
+		done, err := strconv.ParseBool(value.(string))
+		if err != nil {
+			return Record{}, errors.New("Column 'checkUpDone' must be true or false")
+		}
+		fields["checkUpDone"] = done
+		allDone = done
+	}
+
+	if value, exists := fields["checkUps"]; exists {
+		pending, _ := fields["checkUp"].(string)
+		checkUps := []map[string]any{}
+		for _, date := range strings.Split(value.(string), ";") {
+			date = strings.TrimSpace(date)
+			if date != "" {
+				checkUps = append(checkUps, map[string]any{
+					"date": date,
+					"done": allDone || (pending != "" && date < pending),
+				})
+			}
+		}
+		fields["checkUps"] = checkUps
+	}
+
+	encoded, err := json.Marshal(fields)
+	if err != nil {
+		return Record{}, err
+	}
+
+	record := Record{}
+	err = json.Unmarshal(encoded, &record)
+	return record, err
+}
+
+// counts results of rows of the report by their status
+func (r *ImportReport) countRows() {
+	r.Accepted, r.Rejected, r.Conflicting = 0, 0, 0
+	for _, row := range r.Rows {
+		switch row.Status {
+		case ImportRowAccepted:
+			r.Accepted++
+		case ImportRowRejected:
+			r.Rejected++
+		case ImportRowConflicting:
+			r.Conflicting++
+		}
+	}
+}
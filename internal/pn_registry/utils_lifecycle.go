@@ -0,0 +1,55 @@
+package pn_registry
+
+import (
+	"slices"
+
+	"github.com/bmathus/pnregistry-webapi/internal/db_service"
+)
+
+// all lifecycle states of the record
+var lifecycleStatuses = map[string]struct{}{
+	StatusIssued:    {},
+	StatusActive:    {},
+	StatusClosed:    {},
+	StatusCancelled: {},
+	StatusExtended:  {},
+}
+
+// allowed transitions between lifecycle states of the record, closed and cancelled records are final
+var allowedTransitions = map[string][]string{
+	StatusIssued:   {StatusActive},
+	StatusActive:   {StatusClosed, StatusCancelled, StatusExtended},
+	StatusExtended: {StatusClosed, StatusExtended},
+}
+
+// lifecycle state of the record, records stored before lifecycle was introduced are active
+func (r Record) LifecycleStatus() string {
+	if r.Status == "" {
+		return StatusActive
+	}
+	return r.Status
+}
+
+// record in final state can not be modified anymore
+func (r Record) IsFinal() bool {
+	status := r.LifecycleStatus()
+	return status == StatusClosed || status == StatusCancelled
+}
+
+// checks if the record can transition from its current state to the status
+func (r Record) CanTransitionTo(status string) bool {
+	return slices.Contains(allowedTransitions[r.LifecycleStatus()], status)
+}
+
+// condition matching records in given lifecycle state, records without state are active
+func statusCondition(status string) db_service.Filter {
+	if status == StatusActive {
+		return db_service.Or(db_service.Eq("status", status), db_service.Exists("status", false))
+	}
+	return db_service.Eq("status", status)
+}
+
+// condition matching records not in final state, which can still be modified
+func notFinal() db_service.Filter {
+	return db_service.Nin("status", StatusClosed, StatusCancelled)
+}
@@ -0,0 +1,43 @@
+package pn_registry
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRecordTransitions(t *testing.T) {
+	tests := []struct {
+		from    string
+		to      string
+		allowed bool
+	}{
+		{StatusIssued, StatusActive, true},
+		{StatusIssued, StatusCancelled, false},
+		{StatusIssued, StatusClosed, false},
+		{StatusIssued, StatusExtended, false},
+		{StatusActive, StatusClosed, true},
+		{StatusActive, StatusCancelled, true},
+		{StatusActive, StatusExtended, true},
+		{StatusExtended, StatusClosed, true},
+		{StatusExtended, StatusExtended, true},
+		{StatusExtended, StatusCancelled, false},
+		{StatusClosed, StatusActive, false},
+		{StatusCancelled, StatusActive, false},
+		{"", StatusClosed, true}, // records stored before lifecycle was introduced are active
+	}
+	for _, test := range tests {
+		if allowed := (Record{Status: test.from}).CanTransitionTo(test.to); allowed != test.allowed {
+			t.Errorf("Expected transition %q → %q allowed %v, got %v", test.from, test.to, test.allowed, allowed)
+		}
+	}
+}
+
+func TestCancelIssuedRecord(t *testing.T) {
+	engine := newTestEngine(newTestServices())
+	createTestRecord(t, engine, newTestRecord("r1", "123", "2024-01-01", "2024-01-10"))
+
+	// issued record has to be activated before it can be cancelled
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/records/r1/cancel", nil, nil), http.StatusConflict)
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/records/r1/activate", nil, nil), http.StatusOK)
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/records/r1/cancel", nil, nil), http.StatusOK)
+}
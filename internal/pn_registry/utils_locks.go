@@ -0,0 +1,290 @@
+package pn_registry
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bmathus/pnregistry-webapi/internal/db_service"
+	"github.com/bmathus/pnregistry-webapi/internal/tenant"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Mutual exclusion per key, used to serialize checks and writes of records of the same patient
+// so that concurrent requests cannot create overlapping records. Keys are scoped by tenant of the request,
+// so that requests of different tenants with the same IDs do not wait for each other. Keys are locked
+// in the process memory and, when database locks are enabled (see EnableDatabaseLocks), also by leases
+// stored in database, so that requests served by different replicas of the service are serialized too.
+type keyedMutex struct {
+	name    string
+	lock    sync.Mutex
+	entries map[string]*keyedMutexEntry
+}
+
+type keyedMutexEntry struct {
+	mutex sync.Mutex
+	refs  int
+}
+
+func newKeyedMutex(name string) *keyedMutex {
+	return &keyedMutex{name: name, entries: map[string]*keyedMutexEntry{}}
+}
+
+// locks all keys of the tenant of the request and returns function which unlocks them,
+// fails when leases of the keys cannot be acquired in database
+func (this *keyedMutex) Lock(ctx *gin.Context, keys ...string) (func(), error) {
+	// tenant IDs cannot contain '/', so keys of different tenants never collide
+	tenantId := tenant.FromContext(ctx)
+	keys = slices.Clone(keys)
+	for i := range keys {
+		keys[i] = tenantId + "/" + keys[i]
+	}
+
+	// lock keys always in the same order to avoid deadlocks
+	slices.Sort(keys)
+	keys = slices.Compact(keys)
+
+	unlock := this.lockLocal(keys)
+	leases := lockLeases
+	if leases == nil {
+		return unlock, nil
+	}
+
+	// requests of this replica wait for each other in memory, so only one of them polls the database
+	release, err := leases.acquire(ctx.Request.Context(), this.name, keys)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	return func() {
+		release()
+		unlock()
+	}, nil
+}
+
+func (this *keyedMutex) lockLocal(keys []string) func() {
+	for _, key := range keys {
+		this.lock.Lock()
+		entry, exists := this.entries[key]
+		if !exists {
+			entry = &keyedMutexEntry{}
+			this.entries[key] = entry
+		}
+		entry.refs++
+		this.lock.Unlock()
+
+		entry.mutex.Lock()
+	}
+
+	return func() {
+		for _, key := range keys {
+			this.lock.Lock()
+			entry := this.entries[key]
+			entry.refs--
+			if entry.refs == 0 {
+				delete(this.entries, key)
+			}
+			this.lock.Unlock()
+
+			entry.mutex.Unlock()
+		}
+	}
+}
+
+// Lease of locked key stored in database. Unique index on id lets only one replica create the lease,
+// other replicas wait until it is deleted by its owner or until it expires when its owner stopped.
+type LockLease struct {
+	Id        string    `json:"id" bson:"id"` // hash of the locked key
+	Owner     string    `json:"owner" bson:"owner"`
+	ExpiresAt time.Time `json:"expiresAt" bson:"expiresAt"`
+}
+
+// Configuration of locks stored in database
+type LockConfig struct {
+	Lease       time.Duration // leases are renewed while the lock is held, lease of stopped replica expires after this duration
+	WaitTimeout time.Duration // request fails when it cannot acquire its locks within this duration
+	RetryDelay  time.Duration // delay between attempts to acquire lease held by other request
+}
+
+type lockLeaseStore struct {
+	config LockConfig
+	db     db_service.DbService[LockLease]
+}
+
+var errLockUnavailable = errors.New("lock is not available")
+
+// leases of locks in database, nil when keys are locked only in the process memory
+var lockLeases *lockLeaseStore
+
+// Enables locks stored in database, which serialize requests of all replicas of the service using the same
+// database. Without them keys are locked only in the process memory, which is enough for single replica.
+func EnableDatabaseLocks(db db_service.DbService[LockLease], config LockConfig) {
+	config.Lease = cmp.Or(config.Lease, 30*time.Second)
+	config.WaitTimeout = cmp.Or(config.WaitTimeout, 10*time.Second)
+	config.RetryDelay = cmp.Or(config.RetryDelay, 50*time.Millisecond)
+	lockLeases = &lockLeaseStore{config: config, db: db}
+}
+
+// acquires leases of all keys and returns function which releases them
+func (this *lockLeaseStore) acquire(ctx context.Context, name string, keys []string) (func(), error) {
+	ctx, cancel := context.WithTimeout(ctx, this.config.WaitTimeout)
+	defer cancel()
+
+	owner := uuid.NewString()
+	ids := []string{}
+	release := func() {
+		for _, id := range ids {
+			this.release(id, owner)
+		}
+	}
+	for _, key := range keys {
+		id := lockLeaseId(name, key)
+		if err := this.acquireLease(ctx, id, owner); err != nil {
+			release()
+			return nil, fmt.Errorf("%w: %w", errLockUnavailable, err)
+		}
+		ids = append(ids, id)
+	}
+
+	// leases are renewed while the locks are held, so that they expire only when this replica stops
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(this.config.Lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				for _, id := range ids {
+					this.renew(id, owner)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-stopped
+		release()
+	}, nil
+}
+
+// keys contain IDs of patients, so only their hash is stored
+func lockLeaseId(name string, key string) string {
+	hash := sha256.Sum256([]byte(name + ":" + key))
+	return hex.EncodeToString(hash[:])
+}
+
+func (this *lockLeaseStore) acquireLease(ctx context.Context, id string, owner string) error {
+	for {
+		lease := LockLease{Id: id, Owner: owner, ExpiresAt: time.Now().Add(this.config.Lease)}
+		err := this.db.CreateDocument(ctx, id, &lease)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, db_service.ErrConflict) {
+			return err
+		}
+
+		// lease of stopped replica is taken over once it expires
+		err = this.db.UpdateDocumentIf(ctx, id, db_service.Lt("expiresAt", time.Now()), &lease)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, db_service.ErrConditionFailed) && !errors.Is(err, db_service.ErrNotFound) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("held by other request: %w", ctx.Err())
+		case <-time.After(this.config.RetryDelay):
+		}
+	}
+}
+
+func (this *lockLeaseStore) renew(id string, owner string) {
+	ctx, cancel := context.WithTimeout(context.Background(), this.config.Lease/3)
+	defer cancel()
+	lease := LockLease{Id: id, Owner: owner, ExpiresAt: time.Now().Add(this.config.Lease)}
+	if err := this.db.UpdateDocumentIf(ctx, id, db_service.Eq("owner", owner), &lease); err != nil {
+		log.Printf("Failed to renew lease of lock %v: %v", id, err)
+	}
+}
+
+func (this *lockLeaseStore) release(id string, owner string) {
+	// lease is released also when the request was cancelled meanwhile
+	ctx, cancel := context.WithTimeout(context.Background(), this.config.WaitTimeout)
+	defer cancel()
+	err := this.db.DeleteDocumentIf(ctx, id, db_service.Eq("owner", owner))
+	if err != nil && !errors.Is(err, db_service.ErrNotFound) && !errors.Is(err, db_service.ErrConditionFailed) {
+		// lease expires anyway, other requests just wait for it longer
+		log.Printf("Failed to release lease of lock %v: %v", id, err)
+	}
+}
+
+// Utility function which creates error response of request which could not lock the data it writes
+func lockError(err error) *recordError {
+	return newRecordError(http.StatusServiceUnavailable, "Failed to lock the data of the request, try again later", err)
+}
+
+// locks of patients (by tenant and patient ID) which records are being created or updated
+var patientLocks = newKeyedMutex("patient")
+
+// locks of employers (by tenant and employer ID) which are being updated or referenced by records being written
+var employerLocks = newKeyedMutex("employer")
+
+// locks of employers' company IDs (by tenant and IČO), so that two employers cannot be registered with the same IČO
+var companyIdLocks = newKeyedMutex("companyId")
+
+// Utility function which loads the record and locks its patient and employer. The record is loaded again
+// under the lock, so that its checks and writes are based on version which cannot be changed meanwhile.
+func lockRecord(ctx *gin.Context, db db_service.DbService[Record], recordId string) (*Record, func(), error) {
+	for {
+		record, err := db.FindDocument(ctx, recordId)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		unlock, err := patientLocks.Lock(ctx, record.PatientId)
+		if err != nil {
+			return nil, nil, err
+		}
+		if record.EmployerId != "" {
+			unlockEmployer, err := employerLocks.Lock(ctx, record.EmployerId)
+			if err != nil {
+				unlock()
+				return nil, nil, err
+			}
+			unlockPatient := unlock
+			unlock = func() {
+				unlockEmployer()
+				unlockPatient()
+			}
+		}
+
+		locked, err := db.FindDocument(ctx, recordId)
+		if err != nil {
+			unlock()
+			return nil, nil, err
+		}
+		if locked.PatientId == record.PatientId && locked.EmployerId == record.EmployerId {
+			return locked, unlock, nil
+		}
+
+		// record was moved to other patient or employer before it was locked, locks of current ones are needed
+		unlock()
+	}
+}
@@ -0,0 +1,167 @@
+package pn_registry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bmathus/pnregistry-webapi/internal/db_service"
+	"github.com/gin-gonic/gin"
+)
+
+// context of request of the tenant, as set by tenant middleware
+func tenantContext(tenantId string) *gin.Context {
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/api/records/", nil)
+	ctx.Set("tenant_id", tenantId)
+	return ctx
+}
+
+// locks the keys in other goroutine, returned channel receives unlock function once the keys are locked
+func lockAsync(t *testing.T, locks *keyedMutex, ctx *gin.Context, keys ...string) <-chan func() {
+	locked := make(chan func(), 1)
+	go func() {
+		unlock, err := locks.Lock(ctx, keys...)
+		if err != nil {
+			t.Errorf("Failed to lock %v: %v", keys, err)
+			unlock = func() {}
+		}
+		locked <- unlock
+	}()
+	return locked
+}
+
+// locks the keys, failing the test when they cannot be locked
+func mustLock(t *testing.T, locks *keyedMutex, ctx *gin.Context, keys ...string) func() {
+	t.Helper()
+	unlock, err := locks.Lock(ctx, keys...)
+	if err != nil {
+		t.Fatalf("Failed to lock %v: %v", keys, err)
+	}
+	return unlock
+}
+
+// enables locks in database for the duration of the test, returns database of their leases
+func enableTestDatabaseLocks(t *testing.T, config LockConfig) db_service.DbService[LockLease] {
+	t.Helper()
+	db := db_service.NewMemoryService[LockLease](db_service.MemoryServiceConfig{})
+	EnableDatabaseLocks(db, config)
+	t.Cleanup(func() { lockLeases = nil })
+	return db
+}
+
+func TestKeyedMutexTenants(t *testing.T) {
+	locks := newKeyedMutex("patient")
+	unlock := mustLock(t, locks, tenantContext("a"), "8001011234", "e1")
+
+	// the same IDs of other tenant are not blocked
+	select {
+	case unlockOther := <-lockAsync(t, locks, tenantContext("b"), "e1", "8001011234"):
+		unlockOther()
+	case <-time.After(time.Second):
+		t.Fatal("Lock of other tenant waits for lock of tenant a")
+	}
+
+	// the same IDs of the same tenant wait until they are unlocked
+	locked := lockAsync(t, locks, tenantContext("a"), "8001011234")
+	select {
+	case <-locked:
+		t.Fatal("Lock of tenant a was acquired twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	select {
+	case unlockAgain := <-locked:
+		unlockAgain()
+	case <-time.After(time.Second):
+		t.Fatal("Lock of tenant a was not acquired after unlock")
+	}
+
+	if len(locks.entries) != 0 {
+		t.Errorf("Expected no entries after all keys are unlocked, got %v", locks.entries)
+	}
+}
+
+func TestDatabaseLocksReplicas(t *testing.T) {
+	db := enableTestDatabaseLocks(t, LockConfig{})
+
+	// locks of two replicas of the service share only the database
+	replicaA, replicaB := newKeyedMutex("patient"), newKeyedMutex("patient")
+	unlock := mustLock(t, replicaA, tenantContext(""), "8001011234")
+
+	leases, _, err := db.QueryDocuments(context.Background(), db_service.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leases) != 1 || leases[0].Id != lockLeaseId("patient", "/8001011234") {
+		t.Fatalf("Expected lease of the patient stored by hash of the key, got %+v", leases)
+	}
+
+	locked := lockAsync(t, replicaB, tenantContext(""), "8001011234")
+	select {
+	case <-locked:
+		t.Fatal("Lock held by replica A was acquired by replica B")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the same key of other mutex is independent
+	mustLock(t, newKeyedMutex("employer"), tenantContext(""), "8001011234")()
+
+	unlock()
+	select {
+	case unlockB := <-locked:
+		unlockB()
+	case <-time.After(time.Second):
+		t.Fatal("Lock released by replica A was not acquired by replica B")
+	}
+
+	if leases, _, err := db.QueryDocuments(context.Background(), db_service.Query{}); err != nil || len(leases) != 0 {
+		t.Errorf("Expected no leases after all keys are unlocked, got %+v (%v)", leases, err)
+	}
+}
+
+func TestDatabaseLocksExpiry(t *testing.T) {
+	ctx := context.Background()
+	db := enableTestDatabaseLocks(t, LockConfig{Lease: 300 * time.Millisecond, WaitTimeout: 100 * time.Millisecond})
+	locks := newKeyedMutex("patient")
+
+	// lease held by other request is not taken over until it expires
+	held := LockLease{Id: lockLeaseId("patient", "/8001011234"), Owner: "other", ExpiresAt: time.Now().Add(time.Minute)}
+	if err := db.CreateDocument(ctx, held.Id, &held); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := locks.Lock(tenantContext(""), "8001011234"); !errors.Is(err, errLockUnavailable) {
+		t.Errorf("Expected lock held by other request to be unavailable, got %v", err)
+	}
+
+	// lease of stopped replica expired
+	held.ExpiresAt = time.Now().Add(-time.Second)
+	if err := db.UpdateDocument(ctx, held.Id, &held); err != nil {
+		t.Fatal(err)
+	}
+	unlock := mustLock(t, locks, tenantContext(""), "8001011234")
+
+	// lease is renewed while the lock is held, so other replica cannot take it over after its duration
+	time.Sleep(500 * time.Millisecond)
+	other := newKeyedMutex("patient")
+	if _, err := other.Lock(tenantContext(""), "8001011234"); !errors.Is(err, errLockUnavailable) {
+		t.Errorf("Expected renewed lease to be unavailable, got %v", err)
+	}
+
+	unlock()
+	mustLock(t, other, tenantContext(""), "8001011234")()
+}
+
+func TestDatabaseLocksUnavailable(t *testing.T) {
+	enableTestDatabaseLocks(t, LockConfig{WaitTimeout: 50 * time.Millisecond})
+	engine := newTestEngine(newTestServices())
+
+	// patient of the record is locked by other replica of the service
+	unlock := mustLock(t, newKeyedMutex("patient"), tenantContext(""), "123")
+	defer unlock()
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/records/", newTestRecord("r1", "123", "2024-01-01", "2024-01-10"), nil), http.StatusServiceUnavailable)
+}
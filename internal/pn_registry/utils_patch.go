@@ -0,0 +1,47 @@
+package pn_registry
+
+import (
+	"encoding/json"
+	"errors"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/gin-gonic/gin/binding"
+)
+
+var errUnsupportedPatchType = errors.New("unsupported patch content type")
+
+// Utility function which applies JSON Merge Patch (RFC 7386) or JSON Patch (RFC 6902) on the record
+// and validates fields of the patched record with the same validators as request body of UpdateRecord
+func applyRecordPatch(record Record, contentType string, patch []byte) (Record, error) {
+	original, err := json.Marshal(record)
+	if err != nil {
+		return Record{}, err
+	}
+
+	var patched []byte
+	switch contentType {
+	case "application/merge-patch+json", "application/json":
+		patched, err = jsonpatch.MergePatch(original, patch)
+	case "application/json-patch+json":
+		var operations jsonpatch.Patch
+		operations, err = jsonpatch.DecodePatch(patch)
+		if err == nil {
+			patched, err = operations.Apply(original)
+		}
+	default:
+		return Record{}, errUnsupportedPatchType
+	}
+	if err != nil {
+		return Record{}, err
+	}
+
+	patchedRecord := Record{}
+	if err := json.Unmarshal(patched, &patchedRecord); err != nil {
+		return Record{}, err
+	}
+	if err := binding.Validator.ValidateStruct(&patchedRecord); err != nil {
+		return Record{}, err
+	}
+
+	return patchedRecord, nil
+}
@@ -0,0 +1,55 @@
+package pn_registry
+
+import (
+	"github.com/bmathus/pnregistry-webapi/internal/db_service"
+	"github.com/gin-gonic/gin"
+)
+
+// Utility function which loads registered patient, returns nil when the patient is not registered
+// or patient service is not available in the context
+func findPatient(ctx *gin.Context, patientId string) (*Patient, error) {
+	value, exists := ctx.Get("patient_service")
+	if !exists {
+		return nil, nil
+	}
+
+	patientDb, ok := value.(db_service.DbService[Patient])
+	if !ok {
+		return nil, nil
+	}
+
+	patient, err := patientDb.FindDocument(ctx, patientId)
+	if err == db_service.ErrNotFound {
+		return nil, nil
+	}
+	return patient, err
+}
+
+// Utility function which propagates full name of the patient to all their records which are not
+// in final state, closed and cancelled records keep the name they were issued with,
+// records which already have the name are left untouched so the function can be safely retried
+func renamePatientRecords(ctx *gin.Context, db db_service.DbService[Record], patientId string, fullName string) error {
+	records, _, err := db.QueryDocuments(ctx, db_service.Query{
+		Filter: db_service.And(
+			db_service.Eq("patientId", patientId),
+			notFinal(),
+			notDeleted(),
+			db_service.Ne("fullName", fullName),
+		),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		renamedRecord := record
+		renamedRecord.FullName = fullName
+		renamedRecord.Version = record.Version + 1
+
+		if err := db.UpdateDocumentIf(ctx, record.Id, versionCondition(record.Version), &renamedRecord); err != nil {
+			return err
+		}
+		recordAudit(ctx, AuditActionUpdate, record.Id, &record, &renamedRecord)
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package pn_registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+
+	"github.com/bmathus/pnregistry-webapi/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// Access policy of the API, which grants operations of records, patients and employers (by name
+// of the handler, '*' grants all of them) to roles of callers taken from their bearer token
+type accessPolicy struct {
+	Roles map[string]rolePolicy `json:"roles"`
+}
+
+// Operations granted to the role. Role can be restricted to records of employer, which ID is in
+// claim of the token, and to some fields of records. Restricted role can only read records.
+type rolePolicy struct {
+	Operations    []string `json:"operations"`
+	EmployerClaim string   `json:"employerClaim,omitempty"`
+	Fields        []string `json:"fields,omitempty"`
+}
+
+// Restriction of records accessible by the caller, set into context for operations of restricted role
+type recordScope struct {
+	EmployerId string
+	Fields     []string
+}
+
+// key of record scope in gin context
+const recordScopeKey = "record_scope"
+
+// operations which respect record scope and can be granted to restricted roles
+var scopedOperations = []string{"ExportRecords", "GetEmployerRecords", "GetPatientRecords", "GetRecord", "GetRecordAll"}
+
+// policy enforced for operations of the API, nil when access is not restricted
+var recordAccessPolicy *accessPolicy
+
+// Loads access policy from JSON file, operations of the API are authorized only when policy is loaded
+func LoadAccessPolicyFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	policy := accessPolicy{}
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return err
+	}
+
+	// policy is validated against operations of the API, so that typos do not silently deny access
+	operations := []string{"*"}
+	for _, apiType := range []reflect.Type{
+		reflect.TypeOf((*PnRegistryRecordsAPI)(nil)).Elem(),
+		reflect.TypeOf((*PatientsAPI)(nil)).Elem(),
+		reflect.TypeOf((*EmployersAPI)(nil)).Elem(),
+	} {
+		for i := 0; i < apiType.NumMethod(); i++ {
+			if method := apiType.Method(i); method.IsExported() {
+				operations = append(operations, method.Name)
+			}
+		}
+	}
+
+	for role, roleConfig := range policy.Roles {
+		for _, operation := range roleConfig.Operations {
+			if !slices.Contains(operations, operation) {
+				return fmt.Errorf("Role '%s' has unknown operation '%s'", role, operation)
+			}
+			if roleConfig.isRestricted() && !slices.Contains(scopedOperations, operation) {
+				return fmt.Errorf("Role '%s' is restricted to employer or fields and can only have operations %v", role, scopedOperations)
+			}
+		}
+	}
+
+	recordAccessPolicy = &policy
+	log.Printf("Loaded access policy with %v roles from file %v", len(policy.Roles), path)
+	return nil
+}
+
+func (this rolePolicy) isRestricted() bool {
+	return this.EmployerClaim != "" || len(this.Fields) != 0
+}
+
+func (this rolePolicy) allows(operation string) bool {
+	return slices.Contains(this.Operations, operation) || slices.Contains(this.Operations, "*")
+}
+
+// gin middleware which authorizes the operation by access policy before its handler,
+// caller without role granting the operation is rejected with 403
+func authorize(operation string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		scope, err := checkAccess(ctx, operation)
+		if err != nil {
+			forbid(ctx, err.Error())
+			return
+		}
+
+		if scope != nil {
+			ctx.Set(recordScopeKey, *scope)
+		}
+		ctx.Next()
+	}
+}
+
+// evaluates access policy for the operation, returns restriction of records when the operation is granted
+// only by restricted role. Unrestricted role takes precedence over restricted ones.
+func checkAccess(ctx *gin.Context, operation string) (*recordScope, error) {
+	if recordAccessPolicy == nil {
+		return nil, nil
+	}
+
+	identity, ok := auth.IdentityFromContext(ctx)
+	if !ok {
+		return nil, errors.New("Caller is not authenticated")
+	}
+
+	var scope *recordScope
+	var scopeErr error
+	granted := false
+	for _, role := range identity.Roles {
+		roleConfig, exists := recordAccessPolicy.Roles[role]
+		if !exists || !roleConfig.allows(operation) {
+			continue
+		}
+		if !roleConfig.isRestricted() {
+			return nil, nil
+		}
+		if !granted {
+			granted = true
+			scope, scopeErr = roleConfig.scope(identity, role)
+		}
+	}
+
+	if !granted {
+		return nil, fmt.Errorf("Roles %v are not allowed to perform %s", identity.Roles, operation)
+	}
+	return scope, scopeErr
+}
+
+func (this rolePolicy) scope(identity auth.Identity, role string) (*recordScope, error) {
+	scope := &recordScope{Fields: this.Fields}
+	if this.EmployerClaim != "" {
+		employerId, _ := identity.Claim(this.EmployerClaim)
+		if employerId, ok := employerId.(string); ok && employerId != "" {
+			scope.EmployerId = employerId
+		} else {
+			return nil, fmt.Errorf("Token has no claim '%s' required by role '%s'", this.EmployerClaim, role)
+		}
+	}
+	return scope, nil
+}
+
+func forbid(ctx *gin.Context, reason string) {
+	ctx.AbortWithStatusJSON(http.StatusForbidden,
+		gin.H{
+			"status":  "Forbidden",
+			"message": "Access denied",
+			"error":   reason,
+		})
+}
+
+// returns restriction of records accessible by the caller, if any
+func scopeFromContext(ctx *gin.Context) (recordScope, bool) {
+	value, exists := ctx.Get(recordScopeKey)
+	if !exists {
+		return recordScope{}, false
+	}
+	scope, ok := value.(recordScope)
+	return scope, ok
+}
+
+// whether the record is accessible within the scope
+func (this recordScope) allows(record Record) bool {
+	return this.EmployerId == "" || record.EmployerId == this.EmployerId
+}
+
+// returns records limited to fields of the scope in their JSON representation
+func (this recordScope) limitAll(records []Record) interface{} {
+	if len(this.Fields) == 0 {
+		return records
+	}
+
+	limited := make([]interface{}, 0, len(records))
+	for _, record := range records {
+		limited = append(limited, this.limit(record))
+	}
+	return limited
+}
+
+// returns record limited to fields of the scope in its JSON representation
+func (this recordScope) limit(record Record) interface{} {
+	if len(this.Fields) == 0 {
+		return record
+	}
+
+	fields := recordFields(&record)
+	for field := range fields {
+		if !slices.Contains(this.Fields, field) {
+			delete(fields, field)
+		}
+	}
+	return fields
+}
+
+// returns columns of records export limited to fields of the scope
+func (this recordScope) csvColumns() []string {
+	if len(this.Fields) == 0 {
+		return recordCsvColumns
+	}
+
+	columns := []string{}
+	for _, column := range recordCsvColumns {
+		if slices.Contains(this.Fields, column) {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// returns values of CSV row of records export limited to fields of the scope
+func (this recordScope) limitCsvRow(row []string) []string {
+	if len(this.Fields) == 0 {
+		return row
+	}
+
+	limited := []string{}
+	for i, column := range recordCsvColumns {
+		if slices.Contains(this.Fields, column) {
+			limited = append(limited, row[i])
+		}
+	}
+	return limited
+}
@@ -0,0 +1,118 @@
+package pn_registry
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// enforces the access policy of the deployment for the duration of the test
+func loadTestAccessPolicy(t *testing.T) {
+	t.Helper()
+	if err := LoadAccessPolicyFile("../../deployments/policy/access-policy.json"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { recordAccessPolicy = nil })
+}
+
+func TestPolicyPatientsAndEmployers(t *testing.T) {
+	loadTestAccessPolicy(t)
+	services := newTestServices()
+	engine := newTestEngine(services, testIdentity)
+	admin := map[string]string{"X-Test-Roles": "admin"}
+	nurse := map[string]string{"X-Test-Roles": "nurse"}
+	hr := map[string]string{"X-Test-Roles": "hr", "X-Test-Employer": "e1"}
+
+	employer := map[string]interface{}{"id": "e1", "name": "Stavby s.r.o.", "ico": "12345678"}
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/employers/", employer, admin), http.StatusCreated)
+	patient := map[string]interface{}{"id": "8001011234", "fullName": "Jozef Mrkvicka"}
+	expectStatus(t, doRequest(engine, http.MethodPost, "/api/patients/", patient, admin), http.StatusCreated)
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		body    interface{}
+		headers map[string]string
+		status  int
+	}{
+		{"hr lists patients", http.MethodGet, "/api/patients/", nil, hr, http.StatusForbidden},
+		{"hr reads patient", http.MethodGet, "/api/patients/8001011234/", nil, hr, http.StatusForbidden},
+		{"hr reads patient's records", http.MethodGet, "/api/patients/8001011234/records", nil, hr, http.StatusForbidden},
+		{"hr updates patient", http.MethodPut, "/api/patients/8001011234/", patient, hr, http.StatusForbidden},
+		{"hr deletes patient", http.MethodDelete, "/api/patients/8001011234/", nil, hr, http.StatusForbidden},
+		{"hr reads employer", http.MethodGet, "/api/employers/e1/", nil, hr, http.StatusForbidden},
+		{"hr updates employer", http.MethodPut, "/api/employers/e1/", employer, hr, http.StatusForbidden},
+		{"hr reads records of its employer", http.MethodGet, "/api/employers/e1/records", nil, hr, http.StatusOK},
+		{"nurse creates patient", http.MethodPost, "/api/patients/", patient, nurse, http.StatusForbidden},
+		{"nurse deletes employer", http.MethodDelete, "/api/employers/e1/", nil, nurse, http.StatusForbidden},
+		{"nurse lists patients", http.MethodGet, "/api/patients/", nil, nurse, http.StatusOK},
+		{"nurse reads employer", http.MethodGet, "/api/employers/e1/", nil, nurse, http.StatusOK},
+		{"anonymous lists employers", http.MethodGet, "/api/employers/", nil, nil, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expectStatus(t, doRequest(engine, test.method, test.path, test.body, test.headers), test.status)
+		})
+	}
+}
+
+func TestPolicyEmployerRecordsScope(t *testing.T) {
+	loadTestAccessPolicy(t)
+	services := newTestServices()
+	engine := newTestEngine(services, testIdentity)
+	admin := map[string]string{"X-Test-Roles": "admin"}
+
+	for id, ico := range map[string]string{"e1": "12345678", "e2": "87654321"} {
+		employer := map[string]interface{}{"id": id, "name": "Stavby " + id, "ico": ico}
+		expectStatus(t, doRequest(engine, http.MethodPost, "/api/employers/", employer, admin), http.StatusCreated)
+	}
+	storeEmployerRecord(t, services, "r1", StatusActive)
+
+	// records of other employer are not visible to restricted role
+	hr := map[string]string{"X-Test-Roles": "hr", "X-Test-Employer": "e2"}
+	recorder := doRequest(engine, http.MethodGet, "/api/employers/e1/records?date=2024-01-15", nil, hr)
+	expectStatus(t, recorder, http.StatusOK)
+	if records := decodeResponse[[]map[string]interface{}](t, recorder); len(records) != 0 {
+		t.Errorf("Expected no records of other employer, got %+v", records)
+	}
+
+	hr["X-Test-Employer"] = "e1"
+	recorder = doRequest(engine, http.MethodGet, "/api/employers/e1/records?date=2024-01-15", nil, hr)
+	expectStatus(t, recorder, http.StatusOK)
+	records := decodeResponse[[]map[string]interface{}](t, recorder)
+	if len(records) != 1 || records[0]["id"] != "r1" {
+		t.Fatalf("Expected record r1, got %+v", records)
+	}
+	if _, exists := records[0]["patientId"]; exists {
+		t.Errorf("Expected record limited to fields of the role, got %+v", records[0])
+	}
+}
+
+func TestLoadAccessPolicyFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		valid  bool
+	}{
+		{"patient operation", `{"roles": {"doctor": {"operations": ["GetPatient", "UpdateEmployer"]}}}`, true},
+		{"unknown operation", `{"roles": {"doctor": {"operations": ["GetPatients"]}}}`, false},
+		{"internal method", `{"roles": {"doctor": {"operations": ["addRoutes"]}}}`, false},
+		{"restricted role with scoped operation", `{"roles": {"hr": {"operations": ["GetEmployerRecords"], "employerClaim": "employer_id"}}}`, true},
+		{"restricted role with patient operation", `{"roles": {"hr": {"operations": ["GetPatient"], "employerClaim": "employer_id"}}}`, false},
+	}
+	t.Cleanup(func() { recordAccessPolicy = nil })
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "policy.json")
+			if err := os.WriteFile(path, []byte(test.policy), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := LoadAccessPolicyFile(path); (err == nil) != test.valid {
+				t.Errorf("Expected valid %v, got error %v", test.valid, err)
+			}
+		})
+	}
+}
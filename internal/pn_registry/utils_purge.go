@@ -0,0 +1,81 @@
+package pn_registry
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/bmathus/pnregistry-webapi/internal/db_service"
+)
+
+// Starts background job which periodically hard-deletes records that were (soft) deleted
+// longer than retention period ago. The job stops when the context is cancelled.
+func StartPurgeJob(ctx context.Context, db db_service.DbService[Record], auditDb db_service.DbService[AuditEntry], retention time.Duration, interval time.Duration) {
+	log.Printf("Purge of deleted records scheduled every %v, retention period %v", interval, retention)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			purgeDeletedRecords(ctx, db, auditDb, time.Now().UTC().Add(-retention))
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// number of records loaded and purged at once
+const purgeBatchSize = 100
+
+// Utility function which hard-deletes records deleted before given time, records are purged in batches
+// ordered by ID, so that the job does not load all deleted records into memory at once
+func purgeDeletedRecords(ctx context.Context, db db_service.DbService[Record], auditDb db_service.DbService[AuditEntry], deletedBefore time.Time) {
+	purged := 0
+	lastId := ""
+	for ctx.Err() == nil {
+		filter := db_service.Lt("deleted.deletedAt", deletedBefore)
+		if lastId != "" {
+			filter = db_service.And(filter, db_service.Gt("id", lastId))
+		}
+
+		records, _, err := db.QueryDocuments(ctx, db_service.Query{
+			Filter: filter,
+			Sort:   []db_service.SortField{{Field: "id"}},
+			Limit:  purgeBatchSize,
+		})
+		if err != nil {
+			log.Printf("Failed to load deleted records for purge: %v", err)
+			break
+		}
+
+		for _, record := range records {
+			// record restored in the meantime has different version and is not purged
+			err := db.DeleteDocumentIf(ctx, record.Id, versionCondition(record.Version))
+
+			switch err {
+			case nil:
+				purged++
+				storeAudit(ctx, auditDb, newAuditEntry(AuditActionPurge, record.Id, "system", "", &record, nil))
+			case db_service.ErrNotFound, db_service.ErrConditionFailed:
+				// already purged or restored
+			default:
+				log.Printf("Failed to purge deleted record %v: %v", record.Id, err)
+			}
+		}
+
+		// records which failed to be purged are skipped and retried in next run of the job
+		if len(records) < purgeBatchSize {
+			break
+		}
+		lastId = records[len(records)-1].Id
+	}
+
+	if purged != 0 {
+		log.Printf("Purged %v deleted records", purged)
+	}
+}
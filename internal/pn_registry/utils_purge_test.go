@@ -0,0 +1,69 @@
+package pn_registry
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bmathus/pnregistry-webapi/internal/db_service"
+)
+
+// service of records which remembers limits of queries
+type limitRecordingService struct {
+	db_service.DbService[Record]
+	limits *[]int64
+}
+
+func (this limitRecordingService) QueryDocuments(ctx context.Context, query db_service.Query) ([]Record, int64, error) {
+	*this.limits = append(*this.limits, query.Limit)
+	return this.DbService.QueryDocuments(ctx, query)
+}
+
+func TestPurgeDeletedRecords(t *testing.T) {
+	ctx := context.Background()
+	services := newTestServices()
+	now := time.Now().UTC()
+
+	store := func(id string, deleted *Deletion) {
+		record := Record{Id: id, PatientId: "123", FullName: "Jozef Mrkvicka", Version: 1, Deleted: deleted}
+		if err := services.records.CreateDocument(ctx, id, &record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	const expired = 2*purgeBatchSize + 10
+	for i := 0; i < expired; i++ {
+		store(fmt.Sprintf("old-%03d", i), &Deletion{DeletedAt: now.Add(-48 * time.Hour)})
+	}
+	store("recent", &Deletion{DeletedAt: now})
+	store("active", nil)
+
+	limits := []int64{}
+	db := limitRecordingService{services.records, &limits}
+	purgeDeletedRecords(ctx, db, services.audit, now.Add(-24*time.Hour))
+
+	remaining, _, err := services.records.QueryDocuments(ctx, db_service.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 2 {
+		t.Errorf("Expected only recently deleted and active records to remain, got %v records", len(remaining))
+	}
+
+	_, purged, err := services.audit.QueryDocuments(ctx, db_service.Query{Filter: db_service.Eq("action", AuditActionPurge)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != expired {
+		t.Errorf("Expected %v purge audit entries, got %v", expired, purged)
+	}
+
+	if len(limits) != 3 {
+		t.Errorf("Expected records to be purged in 3 batches, got %v", len(limits))
+	}
+	for _, limit := range limits {
+		if limit != purgeBatchSize {
+			t.Errorf("Expected batches limited to %v records, got limit %v", purgeBatchSize, limit)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package pn_registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bmathus/pnregistry-webapi/internal/db_service"
+	"github.com/go-playground/validator/v10"
+)
+
+// reasons of the official ePN in Slovakia, used when no other catalog is configured
+// and to seed empty reason collection
+var defaultReasons = []Reason{
+	{Id: Choroba, Label: ReasonLabel{Sk: "Choroba", En: "Illness"}},
+	{Id: Uraz, Label: ReasonLabel{Sk: "Úraz", En: "Injury"}, Injury: true},
+	{Id: ChorobaZPovolania, Label: ReasonLabel{Sk: "Choroba z povolania", En: "Occupational disease"}},
+	{Id: KarantenneOpatrenieIzolacia, Label: ReasonLabel{Sk: "Karanténne opatrenie/izolácia", En: "Quarantine/isolation"}},
+	{Id: PracovnyUraz, Label: ReasonLabel{Sk: "Pracovný úraz", En: "Work injury"}, Injury: true},
+	{Id: Ine, Label: ReasonLabel{Sk: "Iné", En: "Other"}},
+}
+
+// Catalog of reasons which can be used in records, it can be replaced at runtime
+// when the catalog is loaded from configuration file or database
+type reasonCatalogStore struct {
+	lock    sync.RWMutex
+	reasons []Reason
+	byId    map[string]Reason
+}
+
+var reasonCatalog = newReasonCatalogStore(defaultReasons)
+
+func newReasonCatalogStore(reasons []Reason) *reasonCatalogStore {
+	store := &reasonCatalogStore{}
+	if err := store.Set(reasons); err != nil {
+		log.Panicf("Invalid default reason catalog: %v", err)
+	}
+	return store
+}
+
+// replaces reasons of the catalog, the catalog is not changed when reasons are not valid
+func (this *reasonCatalogStore) Set(reasons []Reason) error {
+	byId := map[string]Reason{}
+	for _, reason := range reasons {
+		if reason.Id == "" {
+			return fmt.Errorf("reason without id")
+		}
+		if reason.Label.Sk == "" || reason.Label.En == "" {
+			return fmt.Errorf("reason '%s' must have both sk and en label", reason.Id)
+		}
+		if reason.MaxDurationDays < 0 {
+			return fmt.Errorf("reason '%s' has negative maximum duration", reason.Id)
+		}
+		if _, exists := byId[reason.Id]; exists {
+			return fmt.Errorf("duplicate reason '%s'", reason.Id)
+		}
+		byId[reason.Id] = reason
+	}
+	if len(byId) == 0 {
+		return fmt.Errorf("catalog has no reasons")
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.reasons = append([]Reason{}, reasons...)
+	this.byId = byId
+	return nil
+}
+
+// returns all reasons in order of the catalog
+func (this *reasonCatalogStore) All() []Reason {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return append([]Reason{}, this.reasons...)
+}
+
+func (this *reasonCatalogStore) Find(id string) (Reason, bool) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	reason, found := this.byId[id]
+	return reason, found
+}
+
+// Loads reason catalog from JSON file containing array of reasons
+func LoadReasonCatalogFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	reasons := []Reason{}
+	if err := json.Unmarshal(data, &reasons); err != nil {
+		return err
+	}
+
+	if err := reasonCatalog.Set(reasons); err != nil {
+		return err
+	}
+	log.Printf("Loaded %v reasons from file %v", len(reasons), path)
+	return nil
+}
+
+// Loads reason catalog from database and starts background job which periodically reloads it,
+// so that changes of the collection are applied without restart. Empty collection is seeded
+// with default reasons. The job stops when the context is cancelled.
+func StartReasonCatalogSync(ctx context.Context, reasonDb db_service.DbService[Reason], interval time.Duration) {
+	log.Printf("Reason catalog is loaded from database every %v", interval)
+
+	if err := seedReasons(ctx, reasonDb); err != nil {
+		log.Printf("Failed to seed reason catalog: %v", err)
+	}
+	syncReasonCatalog(ctx, reasonDb)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				syncReasonCatalog(ctx, reasonDb)
+			}
+		}
+	}()
+}
+
+// Utility function which stores default reasons when the collection is empty
+func seedReasons(ctx context.Context, reasonDb db_service.DbService[Reason]) error {
+	_, total, err := reasonDb.QueryDocuments(ctx, db_service.Query{Projection: []string{"id"}, Limit: 1})
+	if err != nil || total != 0 {
+		return err
+	}
+
+	for _, reason := range defaultReasons {
+		if err := reasonDb.CreateDocument(ctx, reason.Id, &reason); err != nil && err != db_service.ErrConflict {
+			return err
+		}
+	}
+	log.Printf("Reason catalog seeded with %v default reasons", len(defaultReasons))
+	return nil
+}
+
+// Utility function which replaces reason catalog with reasons stored in database,
+// current catalog is kept when loading fails
+func syncReasonCatalog(ctx context.Context, reasonDb db_service.DbService[Reason]) {
+	reasons, _, err := reasonDb.QueryDocuments(ctx, db_service.Query{})
+	if err != nil {
+		log.Printf("Failed to load reason catalog from database: %v", err)
+		return
+	}
+
+	if err := reasonCatalog.Set(reasons); err != nil {
+		log.Printf("Invalid reason catalog in database: %v", err)
+	}
+}
+
+// Utility function which checks the record against metadata of its reason,
+// returns description of the violation or empty string
+func reasonConflict(record Record) string {
+	reason, found := reasonCatalog.Find(record.Reason)
+	if !found {
+		return fmt.Sprintf("Reason '%s' is not supported", record.Reason)
+	}
+
+	if reason.MaxDurationDays > 0 && record.ValidFrom.DaysTo(record.ValidUntil)+1 > reason.MaxDurationDays {
+		return fmt.Sprintf("Record with reason '%s' can be valid for at most %v days", reason.Id, reason.MaxDurationDays)
+	}
+	if reason.CheckUpRequired && record.CheckUp == nil {
+		return fmt.Sprintf("Record with reason '%s' requires 'Check Up' date", reason.Id)
+	}
+	return ""
+}
+
+// custom validator for reason field - only reasons of the catalog
+func ReasonValidator(fl validator.FieldLevel) bool {
+	_, valid := reasonCatalog.Find(fl.Field().String())
+	return valid
+}
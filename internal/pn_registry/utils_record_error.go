@@ -0,0 +1,83 @@
+package pn_registry
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/bmathus/pnregistry-webapi/internal/db_service"
+	"github.com/gin-gonic/gin"
+)
+
+// Failure of validation or processing of the record, which can be reported as error response
+// or as result of single record of bulk operation
+type recordError struct {
+	Status  int
+	Message string
+	Err     error
+}
+
+func newRecordError(status int, message string, err error) *recordError {
+	return &recordError{Status: status, Message: message, Err: err}
+}
+
+// responds with error in the same format as other error responses of the API
+func (e *recordError) respond(ctx *gin.Context) {
+	ctx.JSON(e.Status, e.body())
+}
+
+// body of error response
+func (e *recordError) body() gin.H {
+	body := gin.H{
+		"status":  http.StatusText(e.Status),
+		"message": e.Message,
+	}
+	if e.Err != nil {
+		body["error"] = e.Err.Error()
+	}
+	return body
+}
+
+// failures of database are not caused by the record itself
+func (e *recordError) isServerError() bool {
+	return e.Status >= http.StatusInternalServerError
+}
+
+// maps failure of writing new record into database
+func createWriteError(err error) *recordError {
+	if err == db_service.ErrConflict {
+		return newRecordError(http.StatusConflict, "Record already exists", err)
+	}
+	return newRecordError(http.StatusBadGateway, "Failed to create record in database", err)
+}
+
+// maps failure of writing updated record into database
+func updateWriteError(err error) *recordError {
+	switch err {
+	case db_service.ErrConditionFailed:
+		return newRecordError(http.StatusPreconditionFailed, "Record was modified by another request, load it and try again", err)
+	case db_service.ErrNotFound:
+		return newRecordError(http.StatusNotFound, "Record with specified ID not found", err)
+	default:
+		return newRecordError(http.StatusBadGateway, "Failed to update record in database", err)
+	}
+}
+
+// maps failure of marking record as deleted in database
+func deleteWriteError(err error) *recordError {
+	switch err {
+	case db_service.ErrConditionFailed:
+		return newRecordError(http.StatusPreconditionFailed, "Record was modified, version does not match If-Match header", err)
+	case db_service.ErrNotFound:
+		return newRecordError(http.StatusNotFound, "Record with specified ID not found", err)
+	default:
+		return newRecordError(http.StatusBadGateway, "Failed to delete record from database", err)
+	}
+}
+
+// maps failure of bulk write into database, atomic writes are not available without transactions
+func bulkWriteError(message string, err error) *recordError {
+	if errors.Is(err, db_service.ErrTransactionsUnsupported) {
+		return newRecordError(http.StatusServiceUnavailable, "Atomic writes are not available, database does not support transactions", err)
+	}
+	return newRecordError(http.StatusBadGateway, message, err)
+}
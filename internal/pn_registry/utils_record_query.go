@@ -0,0 +1,126 @@
+package pn_registry
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bmathus/pnregistry-webapi/internal/db_service"
+	"github.com/gin-gonic/gin"
+)
+
+// maximum number of records returned in one page
+const maxPageLimit = 1000
+
+// fields by which the list of records can be sorted
+var sortableRecordFields = []string{"validFrom", "validUntil", "issued", "fullName", "checkUp"}
+
+// Utility function which returns fields by which records can be sorted, fields encrypted
+// by encryption of records of the request can not be sorted
+func recordSortFields(ctx *gin.Context) []string {
+	value, _ := ctx.Get("record_encryption")
+	encryption, _ := value.(*db_service.FieldEncryption)
+
+	fields := []string{}
+	for _, field := range sortableRecordFields {
+		if !encryption.Covers(field) {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// Utility function which builds db query from query parameters of records list request
+func parseRecordQuery(ctx *gin.Context) (db_service.Query, error) {
+	query := db_service.Query{}
+	filters := []db_service.Filter{notDeleted()}
+
+	// Caller restricted to records of employer never sees other records
+	if scope, ok := scopeFromContext(ctx); ok && scope.EmployerId != "" {
+		filters = append(filters, db_service.Eq("employerId", scope.EmployerId))
+	}
+
+	// Paging
+	if value := ctx.Query("limit"); value != "" {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return query, fmt.Errorf("Parameter 'limit' must be a number between 1 and %d", maxPageLimit)
+		}
+		query.Limit = limit
+	}
+	if value := ctx.Query("offset"); value != "" {
+		offset, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || offset < 0 {
+			return query, fmt.Errorf("Parameter 'offset' must be a non-negative number")
+		}
+		query.Offset = offset
+	}
+
+	// Sorting - comma separated fields, descending order with '-' prefix
+	if value := ctx.Query("sort"); value != "" {
+		for _, field := range strings.Split(value, ",") {
+			sortField := db_service.SortField{Field: strings.TrimSpace(field)}
+			if strings.HasPrefix(sortField.Field, "-") {
+				sortField.Field = sortField.Field[1:]
+				sortField.Descending = true
+			}
+			if sortable := recordSortFields(ctx); !slices.Contains(sortable, sortField.Field) {
+				return query, fmt.Errorf("Records can not be sorted by '%s', use one of %s", sortField.Field, strings.Join(sortable, ", "))
+			}
+			query.Sort = append(query.Sort, sortField)
+		}
+		// ensure stable order of pages for records with equal values
+		query.Sort = append(query.Sort, db_service.SortField{Field: "id"})
+	}
+
+	// Equality filters
+	for _, field := range []string{"patientId", "employer", "employerId", "reason", "diagnosis"} {
+		if value := ctx.Query(field); value != "" {
+			filters = append(filters, db_service.Eq(field, value))
+		}
+	}
+	if value := ctx.Query("status"); value != "" {
+		// comma separated list of lifecycle states
+		statusFilters := []db_service.Filter{}
+		for _, status := range strings.Split(value, ",") {
+			status = strings.TrimSpace(status)
+			if _, ok := lifecycleStatuses[status]; !ok {
+				return query, fmt.Errorf("Parameter 'status' must be one of issued, active, closed, cancelled, extended")
+			}
+			statusFilters = append(statusFilters, statusCondition(status))
+		}
+		filters = append(filters, db_service.Or(statusFilters...))
+	}
+	if value := ctx.Query("checkUpDone"); value != "" {
+		checkUpDone, err := strconv.ParseBool(value)
+		if err != nil {
+			return query, fmt.Errorf("Parameter 'checkUpDone' must be true or false")
+		}
+		filters = append(filters, db_service.Eq("checkUpDone", checkUpDone))
+	}
+
+	// Date range filters
+	dateFilters := []struct {
+		param string
+		build func(date DateType) db_service.Filter
+	}{
+		// records which validity period overlaps with <from, to> period
+		{"from", func(date DateType) db_service.Filter { return db_service.Gte("validUntil", date) }},
+		{"to", func(date DateType) db_service.Filter { return db_service.Lte("validFrom", date) }},
+		{"issuedFrom", func(date DateType) db_service.Filter { return db_service.Gte("issued", date) }},
+		{"issuedTo", func(date DateType) db_service.Filter { return db_service.Lte("issued", date) }},
+	}
+	for _, dateFilter := range dateFilters {
+		if value := ctx.Query(dateFilter.param); value != "" {
+			date, err := ParseDate(value)
+			if err != nil {
+				return query, fmt.Errorf("Parameter '%s': %w", dateFilter.param, err)
+			}
+			filters = append(filters, dateFilter.build(date))
+		}
+	}
+
+	query.Filter = db_service.And(filters...)
+	return query, nil
+}
@@ -18,19 +18,9 @@ func MaxLengthValidator(fl validator.FieldLevel) bool {
 	return len(fl.Field().String()) <= 50
 }
 
-// custom validator for reason field - validates only possible values
-func ReasonValidator(fl validator.FieldLevel) bool {
-	// validation vith struct for O(1) speed
-	var ValidReasons = map[string]struct{}{
-		Choroba:                     {},
-		Uraz:                        {},
-		ChorobaZPovolania:           {},
-		KarantenneOpatrenieIzolacia: {},
-		PracovnyUraz:                {},
-		Ine:                         {},
-	}
-
-	reason := fl.Field().String()
-	_, valid := ValidReasons[reason]
-	return valid
+// custom validator for IČO (company ID) of employer - exactly 8 digits
+func CompanyIDValidator(fl validator.FieldLevel) bool {
+	companyID := fl.Field().String()
+	matched, _ := regexp.MatchString(`^\d{8}$`, companyID)
+	return matched
 }
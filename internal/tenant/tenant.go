@@ -0,0 +1,86 @@
+package tenant
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+
+	"github.com/bmathus/pnregistry-webapi/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// key of tenant ID in gin context
+const tenantKey = "tenant_id"
+
+// tenant ID becomes part of database name, so it is limited to safe characters
+var tenantIdPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// Configuration of multi-tenancy. Tenant of authenticated caller is taken from claim of the token
+// and header can only repeat it, header alone selects tenant only when authentication is disabled.
+type Config struct {
+	Tenants       []string
+	Claim         string // claim of bearer token with tenant ID, nested claim is addressed by dot separated path
+	Header        string // header with tenant ID
+	Authenticated bool   // whether requests are authenticated, then the token is the only source of tenant
+}
+
+// checks that tenant IDs are usable as part of database name
+func ValidateTenants(tenants []string) error {
+	for _, tenant := range tenants {
+		if !tenantIdPattern.MatchString(tenant) {
+			return fmt.Errorf("Tenant ID '%s' must have at most 32 lowercase letters, digits, '-' or '_'", tenant)
+		}
+	}
+	return nil
+}
+
+// returns tenant of the request, empty when multi-tenancy is disabled
+func FromContext(ctx *gin.Context) string {
+	return ctx.GetString(tenantKey)
+}
+
+// gin middleware which resolves tenant of the request and puts it into the context,
+// requests without tenant or with unknown tenant are rejected
+func Middleware(config Config) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		header := ctx.GetHeader(config.Header)
+
+		tenantId := header
+		if identity, ok := auth.IdentityFromContext(ctx); ok {
+			claim, _ := identity.Claim(config.Claim)
+			tenantId, _ = claim.(string)
+			if tenantId == "" {
+				reject(ctx, http.StatusForbidden, fmt.Sprintf("Token has no tenant claim '%s'", config.Claim))
+				return
+			}
+			if header != "" && header != tenantId {
+				reject(ctx, http.StatusForbidden, fmt.Sprintf("Tenant in header %s does not match tenant of the token", config.Header))
+				return
+			}
+		} else if config.Authenticated {
+			reject(ctx, http.StatusUnauthorized, "Tenant can be selected only by authenticated caller")
+			return
+		} else if tenantId == "" {
+			reject(ctx, http.StatusBadRequest, fmt.Sprintf("Header %s is required", config.Header))
+			return
+		}
+
+		if !slices.Contains(config.Tenants, tenantId) {
+			reject(ctx, http.StatusForbidden, fmt.Sprintf("Tenant '%s' is not known", tenantId))
+			return
+		}
+
+		ctx.Set(tenantKey, tenantId)
+		ctx.Next()
+	}
+}
+
+func reject(ctx *gin.Context, status int, reason string) {
+	ctx.AbortWithStatusJSON(status,
+		gin.H{
+			"status":  http.StatusText(status),
+			"message": "Tenant not resolved",
+			"error":   reason,
+		})
+}
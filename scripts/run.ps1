@@ -30,6 +30,10 @@ switch ($command) {
             mongo down
         }
     }
+    "start-memory" {
+        $env:PN_REGISTRY_API_DB_TYPE="memory"
+        go run ${ProjectRoot}/cmd/pnregistry-api-service
+    }
     "docker" {
        docker build -t thamako3/pnregistry-webapi:local-build -f ${ProjectRoot}/build/docker/Dockerfile .
     }